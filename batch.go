@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 const noTransactionSeqNo uint64 = 0
@@ -15,10 +16,11 @@ type WriteBatch struct {
 	opts          WriteBatchOptions
 	mu            *sync.Mutex
 	db            *DB
+	ns            *Namespace // namespace of Put and Delete, nil is the default one
 	pendingWrites map[string]*data.LogRecord
 }
 
-// initialize write batch
+// initialize write batch, batch of read-only db rejects all writes with ErrReadOnly
 func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 	if db.options.IndexType == BPtree && !db.seqNoFileExists && !db.isInitial {
 		panic("cannot use write batch in bptree, seq No file does not exitst.")
@@ -33,6 +35,17 @@ func (db *DB) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
 
 // put kv to batch
 func (writeBatch *WriteBatch) Put(key []byte, value []byte) error {
+	return writeBatch.PutIn(writeBatch.ns, key, value)
+}
+
+// put kv of namespace to batch, nil ns is the default namespace
+func (writeBatch *WriteBatch) PutIn(ns *Namespace, key []byte, value []byte) error {
+	if writeBatch.db.isClosed() {
+		return ErrDBClosed
+	}
+	if writeBatch.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
@@ -40,43 +53,72 @@ func (writeBatch *WriteBatch) Put(key []byte, value []byte) error {
 	defer writeBatch.mu.Unlock()
 
 	lr := &data.LogRecord{
-		Key:   key,
-		Value: value,
-		Type:  data.LogRecordNormal,
+		Key:       key,
+		Value:     value,
+		Type:      data.LogRecordNormal,
+		Namespace: ns.namespaceId(),
 	}
-	writeBatch.pendingWrites[string(key)] = lr
+	writeBatch.pendingWrites[batchKey(lr.Namespace, key)] = lr
 	return nil
 }
 
 // delete kv in index/batch, if key exists, add deleted-type log record
 func (writeBatch *WriteBatch) Delete(key []byte) error {
+	return writeBatch.DeleteIn(writeBatch.ns, key)
+}
+
+// delete kv of namespace in batch, nil ns is the default namespace
+func (writeBatch *WriteBatch) DeleteIn(ns *Namespace, key []byte) error {
+	if writeBatch.db.isClosed() {
+		return ErrDBClosed
+	}
+	if writeBatch.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 	writeBatch.mu.Lock()
 	defer writeBatch.mu.Unlock()
 
-	lrp := writeBatch.db.index.Get(key)
+	var lrp *data.LogRecordPos
+	if ns == nil {
+		lrp = writeBatch.db.index.Get(key)
+	} else {
+		writeBatch.db.mu.RLock()
+		if !ns.dropped {
+			lrp = ns.index.Get(key)
+		}
+		writeBatch.db.mu.RUnlock()
+	}
+	pendingKey := batchKey(ns.namespaceId(), key)
 	// if key is not found in index
 	if lrp == nil {
 		// if key exists in batch, delete it directly
-		if writeBatch.pendingWrites[string(key)] != nil {
-			delete(writeBatch.pendingWrites, string(key))
+		if writeBatch.pendingWrites[pendingKey] != nil {
+			delete(writeBatch.pendingWrites, pendingKey)
 		}
 		return nil
 	}
 
 	// append deleted-type log record
 	lr := &data.LogRecord{
-		Key:  key,
-		Type: data.LogRecordDeleted,
+		Key:       key,
+		Type:      data.LogRecordDeleted,
+		Namespace: ns.namespaceId(),
 	}
-	writeBatch.pendingWrites[string(key)] = lr
+	writeBatch.pendingWrites[pendingKey] = lr
 	return nil
 }
 
 // write batch to data file and update index
 func (writeBatch *WriteBatch) Commit() error {
+	if writeBatch.db.isClosed() {
+		return ErrDBClosed
+	}
+	if writeBatch.db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	writeBatch.mu.Lock()
 	defer writeBatch.mu.Unlock()
 
@@ -93,58 +135,99 @@ func (writeBatch *WriteBatch) Commit() error {
 	writeBatch.db.mu.Lock()
 	defer writeBatch.db.mu.Unlock()
 
+	if err := writeBatch.db.commitPendingWrites(writeBatch.pendingWrites, writeBatch.opts.SyncWrites); err != nil {
+		return err
+	}
+
+	// clean batch
+	writeBatch.pendingWrites = make(map[string]*data.LogRecord)
+
+	return nil
+}
+
+// write pending records as a transaction (seqNo + finish record) and update index, under db.mu
+func (db *DB) commitPendingWrites(pendingWrites map[string]*data.LogRecord, sync bool) error {
+	// the namespaces may be dropped after writes are added
+	for _, lr := range pendingWrites {
+		if db.indexOf(lr.Namespace) == nil {
+			return ErrNamespaceDropped
+		}
+	}
+
 	// get the newest global id for transaction
-	seqNo := atomic.AddUint64(&writeBatch.db.seqNo, 1)
+	seqNo := atomic.AddUint64(&db.seqNo, 1)
+	// all records of the batch share the same commit seqNo and timestamp
+	commitSeqNo := db.commitSeqNo + 1
+	timestamp := time.Now().UnixNano()
 
 	// write batch to data file
 	lrpos := make(map[string]*data.LogRecordPos) // to update index
-	for _, lr := range writeBatch.pendingWrites {
-		lrp, err := writeBatch.db.AppendLogRecord(&data.LogRecord{
-			Key:   logRecordKeyWithSeq(lr.Key, seqNo), // encode key with seqNo
-			Value: lr.Value,
-			Type:  lr.Type,
+	for pendingKey, lr := range pendingWrites {
+		lrp, err := db.AppendLogRecord(&data.LogRecord{
+			Key:       logRecordKeyWithSeq(lr.Key, seqNo), // encode key with seqNo
+			Value:     lr.Value,
+			Type:      lr.Type,
+			SeqNo:     commitSeqNo,
+			Namespace: lr.Namespace,
+			Timestamp: timestamp,
 		})
 		if err != nil {
 			return err
 		}
-		lrpos[string(lr.Key)] = lrp
+		lrpos[pendingKey] = lrp
 	}
 
 	// for atom, we need to add a finish-type log record to show we had finished writing to data file
 	finishRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(txnFinKey, seqNo),
-		Type: data.LogRecordTxnFinish,
+		Key:   logRecordKeyWithSeq(txnFinKey, seqNo),
+		Type:  data.LogRecordTxnFinish,
+		SeqNo: commitSeqNo,
 	}
-	_, err := writeBatch.db.AppendLogRecord(finishRecord)
+	_, err := db.AppendLogRecord(finishRecord)
 	if err != nil {
 		return err
 	}
 
 	// if opts.sync == true && active file != nil, then sync
-	if writeBatch.opts.SyncWrites && writeBatch.db.activeFile != nil {
-		if err := writeBatch.db.activeFile.Sync(); err != nil {
+	if sync && db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
 			return err
 		}
 	}
 
 	// update index
-	for _, lr := range writeBatch.pendingWrites {
-		pos := lrpos[string(lr.Key)]
+	db.commitSeqNo = commitSeqNo
+	for pendingKey, lr := range pendingWrites {
+		pos := lrpos[pendingKey]
+		if lr.Namespace != defaultNamespaceId {
+			db.namespaceIds[lr.Namespace].updateIndex(lr.Key, lr.Type, pos)
+			continue
+		}
+
 		var oldPos *data.LogRecordPos
 		if lr.Type == data.LogRecordNormal {
-			oldPos = writeBatch.db.index.Put(lr.Key, pos)
+			oldPos = db.index.Put(lr.Key, pos)
+			db.updateSecondaryIndexes(lr.Key, lr.Value, pos)
 		}
 		if lr.Type == data.LogRecordDeleted {
-			oldPos, _ = writeBatch.db.index.Delete(lr.Key)
+			oldPos, _ = db.index.Delete(lr.Key)
+			db.updateSecondaryIndexes(lr.Key, nil, nil)
 		}
 
 		if oldPos != nil {
-			writeBatch.db.reclaimSize += int64(oldPos.Size)
+			db.reclaimSize += oldPos.TotalSize()
+			db.releaseBlobs(oldPos)
 		}
-	}
+		db.addVersion(lr.Key, commitSeqNo, timestamp, pos, lr.Type == data.LogRecordDeleted)
+		// tell the running transactions that the key has been changed
+		db.markKeyCommitted(lr.Key, seqNo)
 
-	// clean batch
-	writeBatch.pendingWrites = make(map[string]*data.LogRecord)
+		if lr.Type == data.LogRecordDeleted {
+			db.notifyWatchers(EventDelete, lr.Key, nil, db.commitSeqNo)
+		} else {
+			db.notifyWatchers(EventPut, lr.Key, lr.Value, db.commitSeqNo)
+		}
+	}
 
 	return nil
 }
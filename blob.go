@@ -0,0 +1,436 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// open blob files in the directory which are not opened yet, the largest one is the active blob file
+func (db *DB) loadBlobFiles() error {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+
+	fileIds, err := fileIdsOf(dirEntries, data.BlobFileNameSuffix)
+	if err != nil {
+		return err
+	}
+
+	for _, fid := range fileIds {
+		if _, ok := db.blobFiles[uint32(fid)]; ok {
+			continue
+		}
+		if isNew, err := db.isNewFile(data.GetBlobFileName(db.options.DirPath, uint32(fid))); err != nil || isNew {
+			return err
+		}
+		blobFile, err := data.OpenBlobFile(db.options.DirPath, uint32(fid), db.fileOptions)
+		if err != nil {
+			return fileHeaderError(err, data.GetBlobFileName(db.options.DirPath, uint32(fid)))
+		}
+		size, err := blobFile.IOManager.Size()
+		if err != nil {
+			return err
+		}
+		blobFile.WriteOff = size
+		db.blobFiles[uint32(fid)] = blobFile
+		db.activeBlobFile = blobFile
+	}
+	return nil
+}
+
+// invalid size of blob file is all but its valid blobs, which are known after index is loaded
+func (db *DB) loadBlobReclaimSize() {
+	validSize := make(map[uint32]int64)
+	for _, blobPos := range db.blobRefs {
+		validSize[blobPos.Fid] += int64(blobPos.Size)
+	}
+	for fid, blobFile := range db.blobFiles {
+		db.blobReclaimSize[fid] = blobFile.WriteOff - validSize[fid]
+	}
+}
+
+// under lock
+func (db *DB) setActiveBlobFile() error {
+	var fileId uint32 = 0
+	for fid := range db.blobFiles {
+		if fid >= fileId {
+			fileId = fid + 1
+		}
+	}
+
+	blobFile, err := data.OpenBlobFile(db.options.DirPath, fileId, db.fileOptions)
+	if err != nil {
+		return err
+	}
+	db.blobFiles[fileId] = blobFile
+	db.activeBlobFile = blobFile
+	return nil
+}
+
+// append blob record to the active blob file, key of blob is the real key, under db.mu
+func (db *DB) appendBlob(blob *data.LogRecord) (*data.BlobPos, error) {
+	blob.Codec = db.codecOf(blob.Value)
+	encRecord, size := data.EncodeLogRecord(blob)
+	return db.writeActiveBlobFile(size, func(blobFile *data.DataFile) error {
+		return blobFile.Write(encRecord)
+	})
+}
+
+// write a blob record of size to the active blob file with write, under db.mu
+func (db *DB) writeActiveBlobFile(size int64, write func(blobFile *data.DataFile) error) (*data.BlobPos, error) {
+	// one large value may be bigger than the file size limit, so it goes to a new file only if the active one is not empty
+	if db.activeBlobFile == nil || !db.canAppend(db.activeBlobFile) ||
+		(db.activeBlobFile.WriteOff > 0 && db.activeBlobFile.WriteOff+size > db.options.DataFileSize) {
+		if db.activeBlobFile != nil {
+			if err := db.activeBlobFile.Sync(); err != nil {
+				return nil, err
+			}
+		}
+		if err := db.setActiveBlobFile(); err != nil {
+			return nil, err
+		}
+	}
+
+	writeOff := db.activeBlobFile.WriteOff
+	if err := write(db.activeBlobFile); err != nil {
+		return nil, err
+	}
+	size = db.activeBlobFile.WriteOff - writeOff
+	// blob is persisted before the record pointing to it
+	if db.options.SyncWrites {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return nil, err
+		}
+	}
+	return &data.BlobPos{Fid: db.activeBlobFile.FileId, Offset: writeOff, Size: uint32(size)}, nil
+}
+
+// get the value of blob record in data file
+func (db *DB) readBlob(value []byte) ([]byte, error) {
+	return readBlobFrom(db.blobFiles, value)
+}
+
+func readBlobFrom(blobFiles map[uint32]*data.DataFile, value []byte) ([]byte, error) {
+	blobPos := data.DecodeBlobPos(value)
+	blobFile, ok := blobFiles[blobPos.Fid]
+	if !ok {
+		return nil, ErrDataFileNotFound
+	}
+	blob, _, err := blobFile.ReadLogRecord(blobPos.Offset)
+	if err != nil {
+		return nil, err
+	}
+	return blob.Value, nil
+}
+
+func isBlobRecord(typ data.LogRecordType) bool {
+	return typ == data.LogRecordBlob || typ == data.LogRecordBlobMoved
+}
+
+// blob record at pos is valid, value is its encoded BlobPos, under db.mu
+func (db *DB) addBlobRef(pos *data.LogRecordPos, value []byte) {
+	db.blobRefs[recordPosition{fid: pos.Fid, offset: pos.Offset}] = data.DecodeBlobPos(value)
+}
+
+// read the blob record at pos loaded from hint file, and mark its blob valid
+func (db *DB) loadBlobRef(pos *data.LogRecordPos) error {
+	logRecord, err := db.readLogRecord(pos)
+	if err != nil {
+		return err
+	}
+	db.addBlobRef(pos, logRecord.Value)
+	return nil
+}
+
+// the chain of records at pos becomes invalid, count their blobs as invalid ones, under db.mu
+func (db *DB) releaseBlobs(pos *data.LogRecordPos) {
+	if len(db.blobRefs) == 0 {
+		return
+	}
+	for p := pos; p != nil; p = p.Prev {
+		recordPos := recordPosition{fid: p.Fid, offset: p.Offset}
+		if blobPos, ok := db.blobRefs[recordPos]; ok {
+			db.blobReclaimSize[blobPos.Fid] += int64(blobPos.Size)
+			delete(db.blobRefs, recordPos)
+		}
+	}
+}
+
+// records in the indexes of dropped namespaces are invalid, so are their blobs
+// the indexes are not written any more, so they are walked without lock, and db.mu is only locked for each blob
+func (db *DB) releaseDroppedBlobs() {
+	db.mu.Lock()
+	indexes := db.droppedIndexes
+	db.droppedIndexes = nil
+	db.mu.Unlock()
+
+	for _, idx := range indexes {
+		it := idx.Iterator(false)
+		for it.Rewind(); it.Valid(); it.Next() {
+			db.mu.Lock()
+			db.releaseBlobs(it.Value())
+			db.mu.Unlock()
+		}
+		it.Close()
+		_ = idx.Close()
+	}
+}
+
+// pin the blob files for a snapshot or an iterator, which may read the blobs moved by CompactBlobs later, under db.mu.Lock
+func (db *DB) pinBlobFiles() []uint32 {
+	if len(db.blobFiles) == 0 {
+		return nil
+	}
+	fids := make([]uint32, 0, len(db.blobFiles))
+	for fid := range db.blobFiles {
+		db.blobPins[fid]++
+		fids = append(fids, fid)
+	}
+	return fids
+}
+
+// under db.mu.Lock
+func (db *DB) unpinBlobFiles(fids []uint32) {
+	for _, fid := range fids {
+		if db.blobPins[fid]--; db.blobPins[fid] == 0 {
+			delete(db.blobPins, fid)
+		}
+	}
+}
+
+func (db *DB) blobFilesSize() int64 {
+	var size int64
+	for _, blobFile := range db.blobFiles {
+		size += blobFile.WriteOff
+	}
+	return size
+}
+
+func (db *DB) closeBlobFiles() error {
+	for _, blobFile := range db.blobFiles {
+		if err := blobFile.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rewrite the valid values of blob files whose invalid ratio reaches Options.BlobFileGCRatio, and remove the files,
+// each moved value gets a small record with its new pos in data file, while Compact only rewrites those records.
+// values kept for retained versions or changes are not moved, and their files are removed by a later call,
+// so are the files pinned by open snapshots and iterators.
+// values of dropped namespaces are counted as invalid here
+func (db *DB) CompactBlobs() error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.releaseDroppedBlobs()
+	db.mu.Lock()
+
+	if db.closed {
+		db.mu.Unlock()
+		return ErrDBClosed
+	}
+
+	if db.options.IndexType == BPtree {
+		db.mu.Unlock()
+		return ErrBlobUnsupported
+	}
+
+	if db.isCompactingBlobs {
+		db.mu.Unlock()
+		return ErrMergeIsInProgress
+	}
+
+	// expired keys are invalid, so are their blobs
+	db.removeExpiredKeys()
+
+	var gcFiles []*data.DataFile
+	for fid, blobFile := range db.blobFiles {
+		reclaimSize := db.blobReclaimSize[fid]
+		// files of the old key are re-encrypted
+		if (reclaimSize > 0 && float32(reclaimSize)/float32(blobFile.WriteOff) >= db.options.BlobFileGCRatio) || db.isStaleEncryption(blobFile) {
+			gcFiles = append(gcFiles, blobFile)
+		}
+	}
+	if len(gcFiles) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	sort.Slice(gcFiles, func(i, j int) bool {
+		return gcFiles[i].FileId < gcFiles[j].FileId
+	})
+	// values are moved to a new active blob file
+	for _, blobFile := range gcFiles {
+		if blobFile == db.activeBlobFile {
+			db.activeBlobFile = nil
+		}
+	}
+	changesFloor := db.changesRetentionFloor()
+
+	db.isCompactingBlobs = true
+	db.inflight.Add(1)
+	db.mu.Unlock()
+
+	defer func() {
+		db.mu.Lock()
+		db.isCompactingBlobs = false
+		db.mu.Unlock()
+		db.inflight.Done()
+	}()
+
+	// files are not written any more, so read them without lock
+	var removeFiles []*data.DataFile
+	for _, blobFile := range gcFiles {
+		kept := false
+		var offset int64 = 0
+		for {
+			blob, size, err := blobFile.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			blobPos := &data.BlobPos{Fid: blobFile.FileId, Offset: offset, Size: uint32(size)}
+			offset += size
+
+			db.mu.Lock()
+			keep, err := db.moveBlob(blob, blobPos, changesFloor)
+			db.mu.Unlock()
+			if err != nil {
+				return err
+			}
+			kept = kept || keep
+		}
+		if !kept {
+			removeFiles = append(removeFiles, blobFile)
+		}
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	// moved values and their records are persisted before the old files are removed
+	if db.activeBlobFile != nil {
+		if err := db.activeBlobFile.Sync(); err != nil {
+			return err
+		}
+	}
+	if db.activeFile != nil {
+		if err := db.activeFile.Sync(); err != nil {
+			return err
+		}
+	}
+
+	// read-only db, GetReader, snapshots or iterators may be reading the files, then they are removed by a later call
+	return db.withoutReaders(func() error {
+		for _, blobFile := range removeFiles {
+			if db.blobPins[blobFile.FileId] > 0 {
+				continue
+			}
+			if err := blobFile.Close(); err != nil {
+				return err
+			}
+			delete(db.blobFiles, blobFile.FileId)
+			delete(db.blobReclaimSize, blobFile.FileId)
+			if err := os.Remove(data.GetBlobFileName(db.options.DirPath, blobFile.FileId)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// move the blob at blobPos to the active blob file if it is valid, under db.mu
+// return true if the blob is kept in its file for merge operands, retained changes or versions
+func (db *DB) moveBlob(blob *data.LogRecord, blobPos *data.BlobPos, changesFloor uint64) (bool, error) {
+	// changes read the record pointing to the old pos
+	if blob.Namespace == defaultNamespaceId && blob.SeqNo > changesFloor {
+		return true, nil
+	}
+
+	var head *data.LogRecordPos
+	if idx := db.indexOf(blob.Namespace); idx != nil {
+		head = idx.Get(blob.Key)
+	}
+	for p := head; p != nil; p = p.Prev {
+		ref, ok := db.blobRefs[recordPosition{fid: p.Fid, offset: p.Offset}]
+		if !ok || *ref != *blobPos {
+			continue
+		}
+		// previous value of merge operands
+		if p != head {
+			return true, nil
+		}
+		if blob.Namespace == defaultNamespaceId {
+			retained, err := db.isRetainedBlob(blob.Key, blobPos)
+			if err != nil || retained {
+				return retained, err
+			}
+		}
+		return false, db.writeMovedBlob(blob, head)
+	}
+
+	if blob.Namespace != defaultNamespaceId {
+		return false, nil
+	}
+	return db.isRetainedBlob(blob.Key, blobPos)
+}
+
+// write blob to the active blob file and the record of its new pos to data file, under db.mu
+func (db *DB) writeMovedBlob(blob *data.LogRecord, head *data.LogRecordPos) error {
+	blobPos, err := db.appendBlob(blob)
+	if err != nil {
+		return err
+	}
+
+	// the same version as the record at head, only the pos of value is changed
+	logRecord := &data.LogRecord{
+		Key:       logRecordKeyWithSeq(blob.Key, noTransactionSeqNo),
+		Value:     data.EncodeBlobPos(blobPos),
+		Type:      data.LogRecordBlobMoved,
+		Expire:    head.Expire,
+		SeqNo:     blob.SeqNo,
+		Namespace: blob.Namespace,
+		Timestamp: blob.Timestamp,
+	}
+	pos, err := db.AppendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	return db.loadLogRecordIntoIndex(logRecord, blob.Key, pos)
+}
+
+// whether a retained old version of key, or the previous value of it, is the blob at blobPos, under db.mu
+func (db *DB) isRetainedBlob(key []byte, blobPos *data.BlobPos) (bool, error) {
+	if db.histories == nil {
+		return false, nil
+	}
+	h, ok := db.histories[string(key)]
+	if !ok {
+		return false, nil
+	}
+
+	db.pruneVersions(h, time.Now().UnixNano())
+	db.dropDeletedHistory(string(key), h)
+	for _, v := range h.versions[:len(h.versions)-1] {
+		if v.deleted {
+			continue
+		}
+		for p := v.pos; p != nil; p = p.Prev {
+			logRecord, err := db.readLogRecord(p)
+			if err != nil {
+				return false, err
+			}
+			if isBlobRecord(logRecord.Type) && *data.DecodeBlobPos(logRecord.Value) == *blobPos {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
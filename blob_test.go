@@ -0,0 +1,268 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Blob(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-1")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 128
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: large values are in blob files, small ones in data files
+	values := make(map[int][]byte)
+	for i := 0; i < 200; i++ {
+		values[i] = utils.RandomValue(1024)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	assert.Nil(t, db.Put([]byte("small"), []byte("value")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), values[0]))
+	assert.Nil(t, wb.Commit())
+
+	stat := db.Stat()
+	assert.True(t, stat.BlobFileNum > 1)
+	assert.Equal(t, int64(0), stat.BlobReclaimSize)
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, values[10], val)
+	val, err = db.Get([]byte("batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, values[0], val)
+
+	// case2: overwritten and deleted values are invalid
+	for i := 0; i < 100; i++ {
+		values[i] = utils.RandomValue(1024)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	for i := 100; i < 150; i++ {
+		assert.Nil(t, db.Delete(utils.GetTestKey(i)))
+		delete(values, i)
+	}
+	reclaimSize := db.Stat().BlobReclaimSize
+	assert.True(t, reclaimSize > 0)
+
+	// case3: merge does not touch blob files
+	assert.Nil(t, db.Compact())
+	assert.Equal(t, reclaimSize, db.Stat().BlobReclaimSize)
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, reclaimSize, db2.Stat().BlobReclaimSize)
+	for i, v := range values {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, v, val)
+	}
+
+	// case4: blob gc moves valid values and removes the old files
+	assert.Nil(t, db2.CompactBlobs())
+	stat = db2.Stat()
+	assert.True(t, stat.BlobReclaimSize < reclaimSize)
+	for i, v := range values {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, v, val)
+	}
+	val, err = db2.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+
+	// case5: moved values survive merge and restart
+	assert.Nil(t, db2.Compact())
+	assert.Nil(t, db2.Close())
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.BlobFileNum, db3.Stat().BlobFileNum)
+	assert.Equal(t, stat.BlobReclaimSize, db3.Stat().BlobReclaimSize)
+	for i, v := range values {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, v, val)
+	}
+	_, err = db3.Get(utils.GetTestKey(120))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db3.Close())
+
+	opts.IndexType = BPtree
+	_, err = Open(opts)
+	assert.Equal(t, ErrBlobUnsupported, err)
+}
+
+func TestDB_CompactBlobs_Retained(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-2")
+	opts.DirPath = dir
+	opts.ValueThreshold = 16
+	opts.BlobFileGCRatio = 0
+	opts.MergeOperator = MergeOperatorAppend
+	opts.VersionRetention = 2
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	v1, v2 := utils.RandomValue(64), utils.RandomValue(64)
+	assert.Nil(t, db.Put([]byte("a"), v1))
+	assert.Nil(t, db.Put([]byte("a"), v2))
+	assert.Nil(t, db.Put([]byte("b"), v1))
+	assert.Nil(t, db.Merge([]byte("b"), []byte("+op")))
+	assert.Nil(t, db.Put([]byte("c"), v1))
+	assert.Nil(t, db.Put([]byte("c"), []byte("small")))
+
+	// case1: values of retained versions and merge operands are kept
+	assert.True(t, db.Stat().BlobReclaimSize > 0)
+	assert.Nil(t, db.CompactBlobs())
+	versions, err := db.History([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, v1, versions[0].Value)
+	assert.Equal(t, v2, versions[1].Value)
+	val, err := db.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, append(append([]byte(nil), v1...), []byte("+op")...), val)
+
+	// case2: changes read values from blob files
+	it, err := db.ChangesSince(0)
+	assert.Nil(t, err)
+	assert.True(t, it.Valid())
+	assert.Equal(t, v1, it.Event().Value)
+	it.Close()
+
+	// case3: restart
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	versions2, err := db2.History([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, versions, versions2)
+	val, err = db2.Get([]byte("c"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("small"), val)
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_CompactBlobs_Snapshot(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-4")
+	opts.DirPath = dir
+	opts.ValueThreshold = 16
+	opts.BlobFileGCRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	v1, v2 := utils.RandomValue(64), utils.RandomValue(64)
+	assert.Nil(t, db.Put([]byte("a"), v1))
+	assert.Nil(t, db.Put([]byte("b"), v1))
+	snap := db.NewSnapshot()
+	it := db.NewIterator(DefaultIteratorOptions)
+	assert.Nil(t, db.Put([]byte("a"), v2))
+	assert.Nil(t, db.Delete([]byte("b")))
+	blobFileNum := db.Stat().BlobFileNum
+
+	// case1: the blob files are not removed while the snapshot and iterator read them
+	assert.Nil(t, db.CompactBlobs())
+	assert.Equal(t, blobFileNum+1, db.Stat().BlobFileNum)
+	val, err := snap.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, v1, val)
+	val, err = snap.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, v1, val)
+	assert.True(t, it.Valid())
+	val, err = it.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, v1, val)
+	val, err = db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, v2, val)
+
+	// case2: removed by a later call after they are released
+	it.Close()
+	assert.Nil(t, snap.Release())
+	assert.Nil(t, db.CompactBlobs())
+	assert.Equal(t, uint(1), db.Stat().BlobFileNum)
+	val, err = db.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, v2, val)
+}
+
+func TestDB_Blob_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-3")
+	opts.DirPath = dir
+	opts.DataFileSize = 1024
+	opts.ValueThreshold = 16
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	assert.Nil(t, db.Put([]byte("a"), utils.RandomValue(1024)))
+
+	roOpts := opts
+	roOpts.ReadOnly = true
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+
+	// case1: refresh opens the new blob files
+	value := utils.RandomValue(4096)
+	assert.Nil(t, db.Put([]byte("b"), value))
+	assert.Nil(t, reader.Refresh())
+	val, err := reader.Get([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	// case2: blob files in use by reader are not removed
+	assert.Nil(t, db.Delete([]byte("a")))
+	assert.Nil(t, db.CompactBlobs())
+	assert.Equal(t, uint(2), db.Stat().BlobFileNum)
+	assert.Nil(t, reader.Close())
+	assert.Nil(t, db.CompactBlobs())
+	assert.Equal(t, uint(1), db.Stat().BlobFileNum)
+	assert.Equal(t, ErrReadOnly, reader.CompactBlobs())
+}
+
+func TestDB_CompactBlobs_DropNamespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-blob-5")
+	opts.DirPath = dir
+	opts.ValueThreshold = 16
+	opts.BlobFileGCRatio = 0.5
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	sessions, err := db.CreateNamespace("sessions")
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	value := utils.RandomValue(64)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), value))
+
+	// case1: values of dropped namespace are counted as invalid by CompactBlobs
+	assert.Nil(t, db.DropNamespace("sessions"))
+	assert.Equal(t, int64(0), db.Stat().BlobReclaimSize)
+	assert.Equal(t, 1, len(db.droppedIndexes))
+	assert.Nil(t, db.CompactBlobs())
+	assert.Equal(t, 0, len(db.droppedIndexes))
+	assert.Equal(t, 1, len(db.blobRefs))
+	assert.Equal(t, uint(1), db.Stat().BlobFileNum)
+
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+}
@@ -0,0 +1,308 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// iterate committed changes of the default namespace after seqNo in commit order, which are read from data files
+// the changes committed after calling ChangesSince are not included, call it again to get them
+// return ErrChangesCompacted if some of the changes have been dropped by compaction,
+// see Options.ChangeRetention
+// close the iterator after use, db.Close waits for it
+func (db *DB) ChangesSince(seqNo uint64) (*ChangeIterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if seqNo < db.changesFloor {
+		return nil, ErrChangesCompacted
+	}
+
+	var files []*data.DataFile
+	for _, file := range db.olderFiles {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].FileId < files[j].FileId
+	})
+	var endOffset int64
+	if db.activeFile != nil {
+		files = append(files, db.activeFile)
+		endOffset = db.activeFile.WriteOff
+	}
+
+	// values in blob files are read with the records
+	blobFiles := make(map[uint32]*data.DataFile, len(db.blobFiles))
+	for fid, blobFile := range db.blobFiles {
+		blobFiles[fid] = blobFile
+	}
+
+	db.inflight.Add(1)
+	it := &ChangeIterator{
+		db:         db,
+		files:      files,
+		blobFiles:  blobFiles,
+		endOffset:  endOffset,
+		since:      seqNo,
+		txnRecords: make(map[uint64][]*data.LogRecord),
+	}
+	it.skipFiles()
+	it.Next()
+	return it, nil
+}
+
+// iterator of committed changes, returned by DB.ChangesSince
+// merge operand is EventMerge with the operand as Value, and range tombstone is
+// EventDeleteRange with the start as Key and the end as Value
+type ChangeIterator struct {
+	db         *DB
+	files      []*data.DataFile
+	blobFiles  map[uint32]*data.DataFile
+	endOffset  int64 // write offset of the last file when the iterator is created
+	since      uint64
+	fileIdx    int
+	offset     int64
+	txnRecords map[uint64][]*data.LogRecord // records of unfinished write batch
+	events     []*Event                     // changes ready to be returned
+	cur        *Event
+	err        error
+	closed     bool
+}
+
+// move to the next change
+func (it *ChangeIterator) Next() {
+	it.cur = nil
+	for len(it.events) == 0 && it.err == nil && it.fileIdx < len(it.files) {
+		it.readRecord()
+	}
+	if len(it.events) > 0 {
+		it.cur = it.events[0]
+		it.events = it.events[1:]
+	}
+}
+
+// whether there is a current change
+func (it *ChangeIterator) Valid() bool {
+	return it.cur != nil
+}
+
+// get current change
+func (it *ChangeIterator) Event() *Event {
+	return it.cur
+}
+
+// error happened when reading data files, iteration stops at it
+func (it *ChangeIterator) Err() error {
+	return it.err
+}
+
+// close iterator and release resources, it can be called repeatedly
+func (it *ChangeIterator) Close() {
+	if it.closed {
+		return
+	}
+	it.closed = true
+	it.db.inflight.Done()
+	it.files = nil
+	it.blobFiles = nil
+	it.txnRecords = nil
+	it.events = nil
+	it.cur = nil
+}
+
+// seqNos grow with data files, so skip the files that the next file starts before since
+func (it *ChangeIterator) skipFiles() {
+	for it.fileIdx+1 < len(it.files) {
+		lr, _, err := it.files[it.fileIdx+1].ReadLogRecord(0)
+		// moved blob record keeps the seqNo of its version, which is older than the file
+		if err != nil || lr.SeqNo > it.since || lr.Type == data.LogRecordBlobMoved {
+			return
+		}
+		it.fileIdx++
+	}
+}
+
+// read one log record and turn it into changes if it is committed
+func (it *ChangeIterator) readRecord() {
+	file := it.files[it.fileIdx]
+	isLast := it.fileIdx == len(it.files)-1
+	if isLast && it.offset >= it.endOffset {
+		it.fileIdx++
+		return
+	}
+
+	fileSize := it.endOffset
+	if !isLast {
+		var err error
+		if fileSize, err = file.IOManager.Size(); err != nil {
+			it.err = err
+			return
+		}
+	}
+	// corrupted data is skipped as it is by Options.Recovery
+	lr, offset, size, err := readValidRecord(file, it.offset, fileSize)
+	if err != nil {
+		if err == io.EOF {
+			it.fileIdx++
+			it.offset = 0
+			return
+		}
+		it.err = err
+		return
+	}
+	it.offset = offset + size
+
+	// only changes of the default namespace are returned
+	if lr.SeqNo <= it.since || lr.Namespace != defaultNamespaceId {
+		return
+	}
+
+	realKey, txnSeqNo := parseLogRecordKey(lr.Key)
+	lr.Key = realKey
+	if txnSeqNo == noTransactionSeqNo {
+		it.appendEvent(lr)
+		return
+	}
+
+	// records of write batch are committed with the finish record
+	if lr.Type == data.LogRecordTxnFinish {
+		for _, txnRecord := range it.txnRecords[txnSeqNo] {
+			it.appendEvent(txnRecord)
+		}
+		delete(it.txnRecords, txnSeqNo)
+		return
+	}
+	it.txnRecords[txnSeqNo] = append(it.txnRecords[txnSeqNo], lr)
+}
+
+func (it *ChangeIterator) appendEvent(lr *data.LogRecord) {
+	event := &Event{Key: lr.Key, Value: lr.Value, SeqNo: lr.SeqNo}
+	switch lr.Type {
+	case data.LogRecordNormal:
+		event.Type = EventPut
+	case data.LogRecordBlob:
+		value, err := readBlobFrom(it.blobFiles, lr.Value)
+		if err != nil {
+			// blob file has been removed by CompactBlobs
+			if err == ErrDataFileNotFound {
+				err = ErrChangesCompacted
+			}
+			it.err = err
+			return
+		}
+		event.Type = EventPut
+		event.Value = value
+	case data.LogRecordDeleted:
+		event.Type = EventDelete
+		event.Value = nil
+	case data.LogRecordMerge:
+		_, operand, err := decodeMergeOperand(lr.Value)
+		if err != nil {
+			it.err = err
+			return
+		}
+		event.Type = EventMerge
+		event.Value = operand
+	case data.LogRecordRangeDeleted:
+		event.Type = EventDeleteRange
+	default:
+		return
+	}
+	it.events = append(it.events, event)
+}
+
+// invalid records and blobs of the changes after it are kept by compaction, under db.mu
+func (db *DB) changesRetentionFloor() uint64 {
+	floor := db.changesFloor
+	if db.commitSeqNo > db.options.ChangeRetention && db.commitSeqNo-db.options.ChangeRetention > floor {
+		floor = db.commitSeqNo - db.options.ChangeRetention
+	}
+	return floor
+}
+
+// load commit seqNo and changes floor saved by the last merge, both are 0 if never merged
+func (db *DB) loadMergedSeqNos() error {
+	mergeFinishedFileName := filepath.Join(db.options.DirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinishedFileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(db.options.DirPath, db.fileOptions)
+	if err != nil {
+		return err
+	}
+	defer mergeFinishedFile.Close()
+
+	var offset int64 = 0
+	for {
+		record, size, err := mergeFinishedFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		offset += size
+
+		switch string(record.Key) {
+		case mergeCommitSeqNoKey:
+			seqNo, err := strconv.ParseUint(string(record.Value), 10, 64)
+			if err != nil {
+				return err
+			}
+			if seqNo > db.commitSeqNo {
+				db.commitSeqNo = seqNo
+			}
+		case mergeChangesFloorKey:
+			floor, err := strconv.ParseUint(string(record.Value), 10, 64)
+			if err != nil {
+				return err
+			}
+			db.changesFloor = floor
+		}
+	}
+	return nil
+}
+
+// index is not loaded from data files for B+ Tree, get the newest commit seqNo
+// from the last data file which is not empty
+func (db *DB) loadCommitSeqNoFromLastFile() error {
+	if db.activeFile == nil {
+		return nil
+	}
+	files := []*data.DataFile{db.activeFile}
+	for i := len(db.fileIds) - 1; i >= 0; i-- {
+		if file, ok := db.olderFiles[uint32(db.fileIds[i])]; ok {
+			files = append(files, file)
+		}
+	}
+
+	for _, file := range files {
+		var offset int64 = 0
+		for {
+			record, size, err := file.ReadLogRecord(offset)
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+			offset += size
+			if record.SeqNo > db.commitSeqNo {
+				db.commitSeqNo = record.SeqNo
+			}
+		}
+		if offset > 0 {
+			return nil
+		}
+	}
+	return nil
+}
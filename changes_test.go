@@ -0,0 +1,182 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func collectChanges(t *testing.T, db *DB, seqNo uint64) []*Event {
+	it, err := db.ChangesSince(seqNo)
+	assert.Nil(t, err)
+	defer it.Close()
+	var events []*Event
+	for ; it.Valid(); it.Next() {
+		events = append(events, it.Event())
+	}
+	assert.Nil(t, it.Err())
+	return events
+}
+
+func TestDB_ChangesSince(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-changes-1")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.MergeOperator = MergeOperatorAppend
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, db.Delete([]byte("a")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("b"), []byte("2")))
+	assert.Nil(t, wb.Put([]byte("c"), []byte("3")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Merge([]byte("c"), []byte("4")))
+	assert.Nil(t, db.DeleteRange([]byte("b"), []byte("c")))
+
+	// case1: all the changes in commit order
+	events := collectChanges(t, db, 0)
+	assert.Equal(t, 6, len(events))
+	assert.Equal(t, EventPut, events[0].Type)
+	assert.Equal(t, []byte("a"), events[0].Key)
+	assert.Equal(t, []byte("1"), events[0].Value)
+	assert.Equal(t, EventDelete, events[1].Type)
+	assert.Equal(t, events[2].SeqNo, events[3].SeqNo)
+	assert.ElementsMatch(t, [][]byte{[]byte("b"), []byte("c")}, [][]byte{events[2].Key, events[3].Key})
+	assert.Equal(t, EventMerge, events[4].Type)
+	assert.Equal(t, []byte("4"), events[4].Value)
+	assert.Equal(t, EventDeleteRange, events[5].Type)
+	assert.Equal(t, []byte("b"), events[5].Key)
+	assert.Equal(t, []byte("c"), events[5].Value)
+	for i := 1; i < len(events); i++ {
+		assert.True(t, events[i].SeqNo >= events[i-1].SeqNo)
+	}
+
+	// case2: changes after a checkpoint
+	checkpoint := events[3].SeqNo
+	events = collectChanges(t, db, checkpoint)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, EventMerge, events[0].Type)
+
+	// case3: changes across data files
+	for i := 0; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+	events = collectChanges(t, db, checkpoint+2+100)
+	assert.Equal(t, 400, len(events))
+	assert.Equal(t, utils.GetTestKey(100), events[0].Key)
+
+	// case4: close waits for change iterator
+	lastSeqNo := db.commitSeqNo
+	it, err := db.ChangesSince(checkpoint)
+	assert.Nil(t, err)
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("db is closed before change iterator")
+	case <-time.After(100 * time.Millisecond):
+	}
+	it.Close()
+	it.Close()
+	assert.Nil(t, <-closed)
+	_, err = db.ChangesSince(checkpoint)
+	assert.Equal(t, ErrDBClosed, err)
+
+	// case5: restart, seqNo goes on
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, lastSeqNo, db2.commitSeqNo)
+	assert.Nil(t, db2.Put([]byte("d"), []byte("5")))
+	events = collectChanges(t, db2, lastSeqNo)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, lastSeqNo+1, events[0].SeqNo)
+	db2.Close()
+}
+
+func TestDB_ChangesSince_Compact(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-changes-2")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.ChangeRetention = 100
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 300; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i%50), utils.RandomValue(24)))
+	}
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Delete(utils.GetTestKey(0)))
+	assert.Nil(t, wb.Delete(utils.GetTestKey(1)))
+	assert.Nil(t, wb.Commit())
+	assert.Equal(t, uint64(301), db.commitSeqNo)
+
+	err = db.Compact()
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// case1: changes out of retention window are dropped
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(301), db2.commitSeqNo)
+	assert.Equal(t, uint64(201), db2.changesFloor)
+	_, err = db2.ChangesSince(200)
+	assert.Equal(t, ErrChangesCompacted, err)
+
+	// case2: changes in retention window are kept, include overwritten and deleted ones
+	events := collectChanges(t, db2, 201)
+	assert.Equal(t, 101, len(events))
+	assert.Equal(t, uint64(202), events[0].SeqNo)
+	assert.Equal(t, EventDelete, events[99].Type)
+	assert.Equal(t, EventDelete, events[100].Type)
+
+	// case3: index is not affected by the kept records
+	assert.Equal(t, 48, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(0))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// case4: seqNo goes on after all the records are compacted
+	assert.Nil(t, db2.Put([]byte("new"), []byte("value")))
+	events = collectChanges(t, db2, 301)
+	assert.Equal(t, 1, len(events))
+	assert.Equal(t, uint64(302), events[0].SeqNo)
+	db2.Close()
+}
+
+func TestDB_ChangesSince_BPtree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-changes-3")
+	opts.DirPath = dir
+	opts.IndexType = BPtree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	err = db.Close()
+	assert.Nil(t, err)
+
+	// seqNo is recovered from the last data file
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), db2.commitSeqNo)
+	assert.Equal(t, 10, len(collectChanges(t, db2, 0)))
+	db2.Close()
+}
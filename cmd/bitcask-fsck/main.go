@@ -0,0 +1,49 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// check a database directory offline, and print the report as json
+// exit code: 0 if no issue is found, 1 if some issues are found, 2 if the dir can not be checked
+func main() {
+	indent := flag.Bool("indent", false, "indent the json report")
+	keyList := flag.String("key", "", "comma separated hex encoded keys of encrypted files, or id=hex for the keys of a KeyProvider")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: bitcask-fsck [-indent] [-key keys] <dir>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keys, err := bitcask.ParseKeyList(*keyList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask-fsck: %v\n", err)
+		os.Exit(2)
+	}
+
+	report, err := bitcask.VerifyDir(flag.Arg(0), keys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask-fsck: %v\n", err)
+		os.Exit(2)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	if *indent {
+		encoder.SetIndent("", "  ")
+	}
+	if err := encoder.Encode(report); err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask-fsck: %v\n", err)
+		os.Exit(2)
+	}
+	if !report.OK() {
+		os.Exit(1)
+	}
+}
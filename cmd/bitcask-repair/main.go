@@ -0,0 +1,51 @@
+package main
+
+import (
+	bitcask "bitcask-go"
+	"flag"
+	"fmt"
+	"os"
+)
+
+// salvage the valid records of damaged data files offline, see bitcask.RepairDir
+// exit code: 0 if the dir is repaired or not damaged, 2 if it can not be repaired
+func main() {
+	keyList := flag.String("key", "", "comma separated hex encoded keys of the database, or id=hex for the keys of a KeyProvider, the first one encrypts the repaired files")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "usage: bitcask-repair [-key keys] <dir>\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	keys, err := bitcask.ParseKeyList(*keyList)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask-repair: %v\n", err)
+		os.Exit(2)
+	}
+	opts := bitcask.DefaultOptions
+	opts.KeyProvider = keys
+
+	report, err := bitcask.RepairDir(flag.Arg(0), opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "bitcask-repair: %v\n", err)
+		os.Exit(2)
+	}
+	if report.LostFoundDir == "" {
+		fmt.Println("no damaged file is found")
+		return
+	}
+	for _, f := range report.Files {
+		fmt.Printf("data file %d: %d records salvaged\n", f.FileId, f.Records)
+		for _, c := range f.Corruptions {
+			fmt.Printf("  offset %d: %d bytes skipped, %v\n", c.Offset, c.Size, c.Err)
+		}
+	}
+	if report.HintRebuilt {
+		fmt.Printf("hint file is rebuilt: %d old versions kept, %d lost\n", report.VersionsKept, report.VersionsLost)
+	}
+	fmt.Printf("original files are moved to %s\n", report.LostFoundDir)
+}
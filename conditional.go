@@ -0,0 +1,94 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bytes"
+)
+
+// set key to value only if its current value equals to expected, return ErrConditionFailed if not
+func (db *DB) CompareAndSwap(key []byte, expected []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	// check and write under the same lock, so nobody can change the key in between
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	curVal, err := db.getValueLocked(key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return ErrConditionFailed
+		}
+		return err
+	}
+	if !bytes.Equal(curVal, expected) {
+		return ErrConditionFailed
+	}
+
+	return db.writeLocked(key, value, data.LogRecordNormal, 0)
+}
+
+// put kv only if key does not exist, return ErrConditionFailed if it does
+func (db *DB) PutIfAbsent(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	_, err := db.getValueLocked(key)
+	if err == nil {
+		return ErrConditionFailed
+	}
+	if err != ErrKeyNotFound {
+		return err
+	}
+
+	return db.writeLocked(key, value, data.LogRecordNormal, 0)
+}
+
+// delete key only if its current value equals to expected, return ErrConditionFailed if not
+func (db *DB) DeleteIfValue(key []byte, expected []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	curVal, err := db.getValueLocked(key)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return ErrConditionFailed
+		}
+		return err
+	}
+	if !bytes.Equal(curVal, expected) {
+		return ErrConditionFailed
+	}
+
+	return db.writeLocked(key, nil, data.LogRecordDeleted, 0)
+}
+
+// get current value of key, under db.mu
+func (db *DB) getValueLocked(key []byte) ([]byte, error) {
+	logRecordPos := db.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return db.getValueByPosition(logRecordPos)
+}
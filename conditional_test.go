@@ -0,0 +1,122 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_CompareAndSwap(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-cas")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: key does not exist
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Equal(t, ErrConditionFailed, err)
+
+	// case2: value mismatched
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("a")))
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("x"), []byte("b"))
+	assert.Equal(t, ErrConditionFailed, err)
+
+	// case3: swapped
+	err = db.CompareAndSwap(utils.GetTestKey(1), []byte("a"), []byte("b"))
+	assert.Nil(t, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), val)
+
+	// case4: empty key
+	err = db.CompareAndSwap(nil, []byte("a"), []byte("b"))
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// case5: concurrent counter
+	assert.Nil(t, db.Put([]byte("counter"), []byte("0")))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				for {
+					cur, err := db.Get([]byte("counter"))
+					assert.Nil(t, err)
+					n, _ := strconv.Atoi(string(cur))
+					err = db.CompareAndSwap([]byte("counter"), cur, []byte(strconv.Itoa(n+1)))
+					if err == nil {
+						break
+					}
+					assert.Equal(t, ErrConditionFailed, err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1000"), val)
+}
+
+func TestDB_PutIfAbsent(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-if-absent")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.PutIfAbsent(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	err = db.PutIfAbsent(utils.GetTestKey(1), []byte("b"))
+	assert.Equal(t, ErrConditionFailed, err)
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), val)
+
+	// put again after deleting
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	err = db.PutIfAbsent(utils.GetTestKey(1), []byte("c"))
+	assert.Nil(t, err)
+
+	// restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+	db2.Close()
+}
+
+func TestDB_DeleteIfValue(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-if-value")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.DeleteIfValue(utils.GetTestKey(1), []byte("a"))
+	assert.Equal(t, ErrConditionFailed, err)
+
+	assert.Nil(t, db.Put(utils.GetTestKey(1), []byte("a")))
+	err = db.DeleteIfValue(utils.GetTestKey(1), []byte("b"))
+	assert.Equal(t, ErrConditionFailed, err)
+	err = db.DeleteIfValue(utils.GetTestKey(1), []byte("a"))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.True(t, db.Stat().ReclaimSize > 0)
+}
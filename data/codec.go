@@ -0,0 +1,141 @@
+package data
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"sync"
+)
+
+var ErrUnknownCodec = errors.New("unknown codec of value, it must be registered before reading")
+
+// compresses values of log records
+type Codec interface {
+	Compress(src []byte) []byte
+	Decompress(src []byte) ([]byte, error)
+}
+
+// id of codec, persisted in the high 4 bits of record type, so records of different codecs can be in one file
+type CodecId = byte
+
+const (
+	CodecNone CodecId = iota
+	CodecFlate
+	CodecGzip
+	CodecZlib
+)
+
+// the max id of codec, 4 bits
+const MaxCodecId CodecId = 0x0f
+
+var (
+	codecsLock = new(sync.RWMutex)
+	codecs     = map[CodecId]Codec{
+		CodecFlate: flateCodec{},
+		CodecGzip:  gzipCodec{},
+		CodecZlib:  zlibCodec{},
+	}
+)
+
+// register codec with id, the id is persisted with each compressed value,
+// so the codec must be registered before opening a db which contains its values
+func RegisterCodec(id CodecId, codec Codec) {
+	if id == CodecNone || id > MaxCodecId {
+		panic("bitcask-go: codec id must be between 1 and 15")
+	}
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	codecs[id] = codec
+}
+
+// get the codec of id, nil if not registered
+func GetCodec(id CodecId) Codec {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	return codecs[id]
+}
+
+// compress value with codec, return the value itself and CodecNone if it is not smaller
+func compressValue(id CodecId, value []byte) ([]byte, CodecId) {
+	if id == CodecNone || len(value) == 0 {
+		return value, CodecNone
+	}
+	codec := GetCodec(id)
+	if codec == nil {
+		return value, CodecNone
+	}
+	compressed := codec.Compress(value)
+	if len(compressed) >= len(value) {
+		return value, CodecNone
+	}
+	return compressed, id
+}
+
+// decompress value compressed by codec of id
+func Decompress(id CodecId, value []byte) ([]byte, error) {
+	if id == CodecNone {
+		return value, nil
+	}
+	codec := GetCodec(id)
+	if codec == nil {
+		return nil, ErrUnknownCodec
+	}
+	return codec.Decompress(value)
+}
+
+type flateCodec struct{}
+
+func (flateCodec) Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	_, _ = w.Write(src)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func (flateCodec) Decompress(src []byte) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(src))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write(src)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func (gzipCodec) Decompress(src []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+type zlibCodec struct{}
+
+func (zlibCodec) Compress(src []byte) []byte {
+	var buf bytes.Buffer
+	w := zlib.NewWriter(&buf)
+	_, _ = w.Write(src)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func (zlibCodec) Decompress(src []byte) ([]byte, error) {
+	r, err := zlib.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
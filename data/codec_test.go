@@ -0,0 +1,91 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// compresses values made of byte pairs, e.g. "aabb" to "ab"
+type pairCodec struct{}
+
+func (pairCodec) Compress(src []byte) []byte {
+	dst := make([]byte, 0, len(src)/2)
+	for i := 0; i < len(src); i += 2 {
+		dst = append(dst, src[i])
+	}
+	return dst
+}
+
+func (pairCodec) Decompress(src []byte) ([]byte, error) {
+	dst := make([]byte, 0, len(src)*2)
+	for _, b := range src {
+		dst = append(dst, b, b)
+	}
+	return dst, nil
+}
+
+func TestCodec(t *testing.T) {
+	value := bytes.Repeat([]byte(`{"name":"bitcask-go","tags":["kv","log"]}`), 100)
+
+	// case1: built-in codecs
+	for _, id := range []CodecId{CodecFlate, CodecGzip, CodecZlib} {
+		compressed, codec := compressValue(id, value)
+		assert.Equal(t, id, codec)
+		assert.True(t, len(compressed) < len(value))
+		res, err := Decompress(codec, compressed)
+		assert.Nil(t, err)
+		assert.Equal(t, value, res)
+	}
+
+	// case2: value is kept if it does not shrink
+	res, codec := compressValue(CodecFlate, []byte("a"))
+	assert.Equal(t, CodecNone, codec)
+	assert.Equal(t, []byte("a"), res)
+
+	// case3: registered codec
+	RegisterCodec(MaxCodecId, pairCodec{})
+	compressed, codec := compressValue(MaxCodecId, []byte("aabbcc"))
+	assert.Equal(t, MaxCodecId, codec)
+	assert.Equal(t, []byte("abc"), compressed)
+	_, err := Decompress(MaxCodecId-1, compressed)
+	assert.Equal(t, ErrUnknownCodec, err)
+	assert.Panics(t, func() { RegisterCodec(MaxCodecId+1, pairCodec{}) })
+}
+
+func TestDataFile_ReadLogRecord_Codec(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-codec")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	// case1: records of different codecs in one file
+	value := bytes.Repeat([]byte("bitcask-go"), 1000)
+	var offsets []int64
+	for _, id := range []CodecId{CodecNone, CodecGzip, CodecZlib} {
+		rec := &LogRecord{Key: []byte("name"), Value: value, Type: LogRecordMerge, Codec: id}
+		encRecord, size := EncodeLogRecord(rec)
+		if id != CodecNone {
+			assert.True(t, size < int64(len(value)))
+		}
+		offsets = append(offsets, dataFile.WriteOff)
+		assert.Nil(t, dataFile.Write(encRecord))
+	}
+	for i, id := range []CodecId{CodecNone, CodecGzip, CodecZlib} {
+		lr, _, err := dataFile.ReadLogRecord(offsets[i])
+		assert.Nil(t, err)
+		assert.Equal(t, LogRecordMerge, lr.Type)
+		assert.Equal(t, id, lr.Codec)
+		assert.Equal(t, value, lr.Value)
+	}
+
+	// case2: value reader reads the compressed value
+	vr, err := dataFile.NewValueReader(offsets[1])
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordMerge, vr.Type())
+	assert.Equal(t, CodecGzip, vr.Codec())
+}
@@ -2,10 +2,13 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"crypto/cipher"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"hash/crc32"
 	"io"
+	"os"
 	"path/filepath"
 )
 
@@ -15,35 +18,66 @@ var (
 
 const (
 	DataFileNameSuffix    = ".data"
+	BlobFileNameSuffix    = ".blob"
 	HintFileName          = "hint-index"
 	MergeFinishedFileName = "merge-finished"
 	SeqNoFileName         = "seq-no"
+	NamespaceFileName     = "namespaces"
+	DBIdFileName          = "db-uuid"
 )
 
 type DataFile struct {
-	FileId    uint32
-	WriteOff  int64         // offset
-	IOManager fio.IOManager // to read/write/sync/close
+	FileId       uint32
+	WriteOff     int64         // offset
+	IOManager    fio.IOManager // to read/write/sync/close
+	header       *FileHeader   // nil if the file is written before the header
+	headerSize   int64
+	recordFormat byte        // layout of records, see RecordFormatVersion
+	aead         cipher.AEAD // nil if the file is not encrypted
+	sealed       bool        // reopened encrypted file or file without header, which is not appended any more
 }
 
-func OpenDataFile(path_dir string, file_id uint32, ioType fio.FileIOType) (*DataFile, error) {
+// new file starts with the header of opts, nil opts means neither db id nor encryption, files encrypted before still need keys to be opened,
+// the header of existing file is checked with opts, files of another db or a newer version are refused,
+// records of existing file without header are of record format 1
+func OpenDataFile(path_dir string, file_id uint32, ioType fio.FileIOType, opts *FileOptions) (*DataFile, error) {
 	fileName := GetDataFileName(path_dir, file_id)
-	return newDataFile(fileName, file_id, ioType)
+	return newDataFile(fileName, file_id, ioType, opts)
 }
 
-func OpenHintFile(path string) (*DataFile, error) {
+// blob file keeps large values, its records are the same as data file
+func OpenBlobFile(path_dir string, file_id uint32, opts *FileOptions) (*DataFile, error) {
+	fileName := GetBlobFileName(path_dir, file_id)
+	return newDataFile(fileName, file_id, fio.StandardFIO, opts)
+}
+
+func OpenHintFile(path string, opts *FileOptions) (*DataFile, error) {
 	fileName := filepath.Join(path, HintFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, fio.StandardFIO, opts)
 }
 
-func OpenMergeFinishedFile(path string) (*DataFile, error) {
+// merge-finished file is not encrypted, and only ReadOnly of opts is used
+func OpenMergeFinishedFile(path string, opts *FileOptions) (*DataFile, error) {
 	fileName := filepath.Join(path, MergeFinishedFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	if opts != nil {
+		opts = &FileOptions{ReadOnly: opts.ReadOnly}
+	}
+	return newDataFile(fileName, 0, fio.StandardFIO, opts)
 }
 
-func OpenSeqNoFile(path string) (*DataFile, error) {
+func OpenSeqNoFile(path string, opts *FileOptions) (*DataFile, error) {
 	fileName := filepath.Join(path, SeqNoFileName)
-	return newDataFile(fileName, 0, fio.StandardFIO)
+	return newDataFile(fileName, 0, fio.StandardFIO, opts)
+}
+
+// namespace file is reopened to append every creation or drop of namespace, which an encrypted file refuses,
+// so it is not encrypted, and only DBId and ReadOnly of opts are used
+func OpenNamespaceFile(path string, opts *FileOptions) (*DataFile, error) {
+	fileName := filepath.Join(path, NamespaceFileName)
+	if opts != nil {
+		opts = &FileOptions{DBId: opts.DBId, ReadOnly: opts.ReadOnly}
+	}
+	return newDataFile(fileName, 0, fio.StandardFIO, opts)
 }
 
 // params: dir_path, file_id ; return: file_name
@@ -51,7 +85,17 @@ func GetDataFileName(path_dir string, file_id uint32) string {
 	return filepath.Join(path_dir, fmt.Sprintf("%09d", file_id)+DataFileNameSuffix)
 }
 
-func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFile, error) {
+func GetBlobFileName(path_dir string, file_id uint32) string {
+	return filepath.Join(path_dir, fmt.Sprintf("%09d", file_id)+BlobFileNameSuffix)
+}
+
+func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType, opts *FileOptions) (*DataFile, error) {
+	if opts == nil {
+		opts = &FileOptions{}
+	}
+	if opts.ReadOnly {
+		ioType = fio.ReadOnlyFIO
+	}
 	// initialize io_manager
 	io_manager, err := fio.NewIOManager(fileName, ioType)
 	if err != nil {
@@ -64,10 +108,48 @@ func newDataFile(fileName string, fileId uint32, ioType fio.FileIOType) (*DataFi
 		IOManager: io_manager,
 	}
 
+	// new file starts with header, and records of existing file without header start at 0
+	header, headerSize, err := readFileHeader(io_manager)
+	if err == nil {
+		size, sizeErr := io_manager.Size()
+		switch {
+		case sizeErr != nil:
+			err = sizeErr
+		case header != nil:
+			err = dataFile.openHeader(header, headerSize, opts)
+		case size == 0 && opts.ReadOnly:
+			dataFile.recordFormat = RecordFormatVersion
+			dataFile.sealed = true
+		case size == 0:
+			err = dataFile.initHeader(fileName, ioType, opts)
+		default:
+			// written before the header, new records of the current format are never appended to it
+			dataFile.recordFormat = recordFormatV1
+			dataFile.sealed = true
+		}
+	}
+	if err != nil {
+		_ = dataFile.IOManager.Close()
+		return nil, err
+	}
+
 	return dataFile, nil
 }
 
 func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
+	if df.aead != nil {
+		encRecord, size, err := df.readSealed(offset)
+		if err != nil {
+			return nil, 0, err
+		}
+		header, headerSize := df.decodeLogRecordHeader(encRecord)
+		if header == nil || headerSize+int64(header.keySize)+int64(header.valueSize) != int64(len(encRecord)) {
+			return nil, 0, ErrInvalidCRC
+		}
+		logRecord, err := decodeLogRecord(header, encRecord[crc32.Size:headerSize], encRecord[headerSize:])
+		return logRecord, size, err
+	}
+
 	fileSize, err := df.IOManager.Size()
 	if err != nil {
 		return nil, 0, err
@@ -83,7 +165,7 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 		return nil, 0, err
 	}
 	//decode the header
-	header, headerSize := DecodeLogRecordHeader(headBuf)
+	header, headerSize := df.decodeLogRecordHeader(headBuf)
 	//finish reading
 	if header == nil {
 		return nil, 0, io.EOF
@@ -94,28 +176,110 @@ func (df *DataFile) ReadLogRecord(offset int64) (*LogRecord, int64, error) {
 
 	keySize, valueSize := int64(header.keySize), int64(header.valueSize)
 	var recordSize = headerSize + keySize + valueSize
+	// torn record at the end of file
+	if offset+recordSize > fileSize {
+		return nil, 0, io.EOF
+	}
 
-	logRecord := &LogRecord{Type: header.recordType}
 	// read key and value
+	var kvBuf []byte
 	if keySize > 0 || valueSize > 0 {
-		kvBuf, err := df.readNBytes(keySize+valueSize, offset+headerSize)
+		kvBuf, err = df.readNBytes(keySize+valueSize, offset+headerSize)
 		if err != nil {
 			return nil, 0, err
 		}
-		logRecord.Key = kvBuf[:keySize]
-		logRecord.Value = kvBuf[keySize:]
+	}
+	logRecord, err := decodeLogRecord(header, headBuf[crc32.Size:headerSize], kvBuf)
+	return logRecord, recordSize, err
+}
+
+// read ahead when looking for the next valid record
+const resyncWindowSize = 64 * 1024
+
+// offset of the first valid record in file between offset and size, for skipping corrupted data,
+// the header at each offset is checked in the window read ahead first, and only plausible records are read
+func (df *DataFile) NextValidRecord(offset, size int64) (int64, bool) {
+	var window []byte
+	var windowOff int64
+	for ; offset < size; offset++ {
+		windowEnd := windowOff + int64(len(window))
+		if offset+maxLogRecordHeaderSize > windowEnd && windowEnd < size {
+			n := int64(resyncWindowSize)
+			if offset+n > size {
+				n = size - offset
+			}
+			buf, err := df.readNBytes(n, offset)
+			if err != nil && err != io.EOF {
+				return 0, false
+			}
+			window, windowOff = buf, offset
+		}
+		if !df.mayBeRecord(window[offset-windowOff:], size-offset) {
+			continue
+		}
+		if _, _, err := df.ReadLogRecord(offset); err == nil {
+			return offset, true
+		}
+	}
+	return 0, false
+}
+
+// if buf may be the start of a record no larger than remaining, without reading the record
+func (df *DataFile) mayBeRecord(buf []byte, remaining int64) bool {
+	if df.aead != nil {
+		if len(buf) < sealedFrameHead {
+			return false
+		}
+		size := int64(binary.LittleEndian.Uint32(buf[:sealedFrameHead]))
+		return size > int64(df.aead.Overhead()) && sealedFrameHead+size <= remaining
+	}
+	header, headerSize := df.decodeLogRecordHeader(buf)
+	if header == nil || header.keySize == 0 || header.recordType > LogRecordBlobMoved {
+		return false
+	}
+	if header.codec != CodecNone && GetCodec(header.codec) == nil {
+		return false
+	}
+	return headerSize+int64(header.keySize)+int64(header.valueSize) <= remaining
+}
+
+// decode the record of header and kvBuf, and check crc, headBuf is the encoded header without crc
+func decodeLogRecord(header *LogRecordHeader, headBuf []byte, kvBuf []byte) (*LogRecord, error) {
+	logRecord := &LogRecord{Type: header.recordType, Expire: header.expire, SeqNo: header.seqNo, Namespace: header.namespace, Timestamp: header.timestamp, Codec: header.codec}
+	if len(kvBuf) > 0 {
+		logRecord.Key = kvBuf[:header.keySize]
+		logRecord.Value = kvBuf[header.keySize:]
 		// fmt.Printf("key:%s, value:%s", logRecord.Key, logRecord.Value)
 	}
 
 	//check crc
-	crc := getLogRecordCRC(logRecord, headBuf[crc32.Size:headerSize])
+	crc := getLogRecordCRC(logRecord, headBuf)
 	if crc != header.crc {
-		return nil, 0, ErrInvalidCRC
+		return nil, ErrInvalidCRC
+	}
+	var err error
+	if logRecord.Value, err = Decompress(header.codec, logRecord.Value); err != nil {
+		return nil, err
 	}
-	return logRecord, recordSize, nil
+	return logRecord, nil
 }
 
+// decode the record header of the record format of file
+func (df *DataFile) decodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
+	if df.recordFormat == recordFormatV1 {
+		return decodeLogRecordHeaderV1(buf)
+	}
+	return DecodeLogRecordHeader(buf)
+}
+
+// buf of encrypted file must be one whole record, which is sealed
 func (df *DataFile) Write(buf []byte) error {
+	if df.sealed {
+		return ErrNotAppendable
+	}
+	if df.aead != nil {
+		buf = df.seal(buf, df.WriteOff)
+	}
 	n, err := df.IOManager.Write(buf)
 	if err != nil {
 		return err
@@ -124,12 +288,51 @@ func (df *DataFile) Write(buf []byte) error {
 	return nil
 }
 
-func (df *DataFile) WriteHintRecord(key []byte, pos *LogRecordPos) error {
-	lr := &LogRecord{
-		Key:   key,
-		Value: EncodeLogRecordPos(pos),
+// write the record of head and its value read from r, r is read twice, first for crc and then for writing,
+// so the value is never held in memory as a whole
+func (df *DataFile) WriteLogRecordFrom(head []byte, r io.ReadSeeker, valueSize int64) error {
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	crc := crc32.NewIEEE()
+	crc.Write(head[crc32.Size:])
+	if _, err := io.CopyN(crc, r, valueSize); err != nil {
+		return err
+	}
+	binary.LittleEndian.PutUint32(head[:crc32.Size], crc.Sum32())
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	// the record is sealed as a whole
+	if df.aead != nil {
+		encRecord := make([]byte, int64(len(head))+valueSize)
+		copy(encRecord, head)
+		if _, err := io.ReadFull(r, encRecord[len(head):]); err != nil {
+			return err
+		}
+		return df.Write(encRecord)
+	}
+	if err := df.Write(head); err != nil {
+		return err
+	}
+	_, err := io.CopyN(dataFileWriter{df}, r, valueSize)
+	return err
+}
+
+// hint of key carries the namespace, seqNo and timestamp of log record lr at pos
+// typ is LogRecordNormal (LogRecordBlob if value is in blob file) for the newest version,
+// and LogRecordHistory for an old one (pos is nil if deleted)
+func (df *DataFile) WriteHintRecord(typ LogRecordType, key []byte, pos *LogRecordPos, lr *LogRecord) error {
+	hint := &LogRecord{
+		Key:       key,
+		Value:     EncodeLogRecordPos(pos),
+		Type:      typ,
+		SeqNo:     lr.SeqNo,
+		Namespace: lr.Namespace,
+		Timestamp: lr.Timestamp,
 	}
-	encLogRecord, _ := EncodeLogRecord(lr)
+	encLogRecord, _ := EncodeLogRecord(hint)
 
 	return df.Write(encLogRecord)
 }
@@ -151,9 +354,36 @@ func (df *DataFile) SetIOManager(dirPath string, ioType fio.FileIOType) error {
 		return err
 	}
 	df.IOManager = ioManager
+	if df.header != nil {
+		df.IOManager = headerIOManager{IOManager: ioManager, headerSize: df.headerSize}
+	}
+	return nil
+}
+
+// truncate the records after size, e.g. the torn record at the end of file, the file is reopened by standard fio
+func (df *DataFile) Truncate(dirPath string, size int64) error {
+	if err := df.SetIOManager(dirPath, fio.StandardFIO); err != nil {
+		return err
+	}
+	if err := os.Truncate(GetDataFileName(dirPath, df.FileId), df.headerSize+size); err != nil {
+		return err
+	}
+	df.WriteOff = size
 	return nil
 }
 
+// io.Writer of data file, for copying
+type dataFileWriter struct {
+	df *DataFile
+}
+
+func (w dataFileWriter) Write(p []byte) (int, error) {
+	if err := w.df.Write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
 func (df *DataFile) readNBytes(n int64, offset int64) ([]byte, error) {
 	b := make([]byte, n)
 	_, err := df.IOManager.Read(b, offset)
@@ -2,6 +2,7 @@ package data
 
 import (
 	"bitcask-go/fio"
+	"io"
 	"os"
 	"testing"
 
@@ -9,21 +10,21 @@ import (
 )
 
 func TestOpenDataFile(t *testing.T) {
-	dataFile1, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dataFile1, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile1)
 
-	dataFile2, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO)
+	dataFile2, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile2)
 
-	dataFile3, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO)
+	dataFile3, err := OpenDataFile(os.TempDir(), 111, fio.StandardFIO, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile3)
 }
 
 func TestDataFile_Write(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO)
+	dataFile, err := OpenDataFile(os.TempDir(), 0, fio.StandardFIO, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -38,7 +39,7 @@ func TestDataFile_Write(t *testing.T) {
 }
 
 func TestDataFile_Close(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 123, fio.StandardFIO)
+	dataFile, err := OpenDataFile(os.TempDir(), 123, fio.StandardFIO, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -50,7 +51,7 @@ func TestDataFile_Close(t *testing.T) {
 }
 
 func TestDataFile_Sync(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 456, fio.StandardFIO)
+	dataFile, err := OpenDataFile(os.TempDir(), 456, fio.StandardFIO, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -62,7 +63,7 @@ func TestDataFile_Sync(t *testing.T) {
 }
 
 func TestDataFile_ReadLogRecord(t *testing.T) {
-	dataFile, err := OpenDataFile(os.TempDir(), 2, fio.StandardFIO)
+	dataFile, err := OpenDataFile(os.TempDir(), 2, fio.StandardFIO, nil)
 	assert.Nil(t, err)
 	assert.NotNil(t, dataFile)
 
@@ -96,3 +97,63 @@ func TestDataFile_ReadLogRecord(t *testing.T) {
 	assert.Equal(t, rec2, lr2)
 	assert.Equal(t, size2, lrsize2)
 }
+
+func TestDataFile_Truncate(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-truncate")
+	defer os.RemoveAll(dir)
+	id, _ := NewUUID()
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, &FileOptions{DBId: id})
+	assert.Nil(t, err)
+
+	encRecord, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.Nil(t, dataFile.Write(encRecord))
+	// torn record
+	assert.Nil(t, dataFile.Write(encRecord[:len(encRecord)-3]))
+	_, _, err = dataFile.ReadLogRecord(size)
+	assert.Equal(t, io.EOF, err)
+
+	assert.Nil(t, dataFile.Truncate(dir, size))
+	assert.Equal(t, size, dataFile.WriteOff)
+	fileSize, err := dataFile.IOManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, size, fileSize)
+	assert.Nil(t, dataFile.Write(encRecord))
+	lr, _, err := dataFile.ReadLogRecord(size)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), lr.Value)
+	assert.Nil(t, dataFile.Close())
+}
+
+func TestDataFile_NextValidRecord(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-next-valid")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	var offsets []int64
+	for i := 0; i < 3; i++ {
+		offsets = append(offsets, dataFile.WriteOff)
+		encRecord, _ := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+		assert.Nil(t, dataFile.Write(encRecord))
+	}
+	size := dataFile.WriteOff
+	assert.Nil(t, dataFile.Close())
+
+	// case1: the record after the corrupted one
+	fileName := GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[int64(len(content))-size+offsets[1]+1] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+	dataFile, err = OpenDataFile(dir, 0, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+	_, _, err = dataFile.ReadLogRecord(offsets[1])
+	assert.Equal(t, ErrInvalidCRC, err)
+	next, ok := dataFile.NextValidRecord(offsets[1]+1, size)
+	assert.True(t, ok)
+	assert.Equal(t, offsets[2], next)
+
+	// case2: no valid record after the last one
+	_, ok = dataFile.NextValidRecord(offsets[2]+1, size)
+	assert.False(t, ok)
+}
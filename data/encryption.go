@@ -0,0 +1,139 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrKeyRequired          = errors.New("the file is encrypted, but no key is provided")
+	ErrInvalidKeyId         = errors.New("the id of encryption key must be 1 to 255 bytes")
+	ErrNotAppendable        = errors.New("encrypted file or file without header can not be appended after it is reopened")
+	ErrValueReaderEncrypted = errors.New("value of encrypted file can not be read in place")
+)
+
+// provides the keys of encrypted files, a key is 16, 24 or 32 bytes for AES-128, AES-192 or AES-256
+type KeyProvider interface {
+	// the id and key to encrypt new files with, the id is kept in file header
+	CurrentKey() (id string, key []byte, err error)
+	// the key of id, to decrypt the files encrypted by it
+	Key(id string) ([]byte, error)
+}
+
+const (
+	nonceSize       = 12
+	sealedFrameHead = 4 // size of sealed record
+)
+
+// set the current key of keys and a random nonce to the header of new file
+func newFileEncryption(header *FileHeader, keys KeyProvider) (cipher.AEAD, error) {
+	keyId, key, err := keys.CurrentKey()
+	if err != nil {
+		return nil, err
+	}
+	if len(keyId) == 0 || len(keyId) > 255 {
+		return nil, ErrInvalidKeyId
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header.KeyId = keyId
+	header.nonce = make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, header.nonce); err != nil {
+		return nil, err
+	}
+	return aead, nil
+}
+
+// aead of the key the file of header is encrypted by
+func openFileEncryption(header *FileHeader, keys KeyProvider) (cipher.AEAD, error) {
+	key, err := keys.Key(header.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	return newAEAD(key)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// if the file is encrypted
+func (df *DataFile) Encrypted() bool {
+	return df.aead != nil
+}
+
+// id of the key the file is encrypted by, empty if it is not encrypted
+func (df *DataFile) KeyId() string {
+	if df.header == nil {
+		return ""
+	}
+	return df.header.KeyId
+}
+
+// if new records can be written to the file
+func (df *DataFile) Appendable() bool {
+	return !df.sealed
+}
+
+// nonce of the record at offset, the nonce of file xor offset, so it is unique in the file
+func (df *DataFile) nonceAt(offset int64) []byte {
+	nonce := append([]byte(nil), df.header.nonce...)
+	var off [8]byte
+	binary.BigEndian.PutUint64(off[:], uint64(offset))
+	for i := range off {
+		nonce[nonceSize-8+i] ^= off[i]
+	}
+	return nonce
+}
+
+// seal the encoded record to be written at offset
+//
+//	+-------------+-------------------------------+
+//	| size        |  encrypted record and its tag |
+//	+-------------+-------------------------------+
+//	   4 bytes              VarLen
+func (df *DataFile) seal(encRecord []byte, offset int64) []byte {
+	frame := make([]byte, sealedFrameHead, sealedFrameHead+len(encRecord)+df.aead.Overhead())
+	frame = df.aead.Seal(frame, df.nonceAt(offset), encRecord, nil)
+	binary.LittleEndian.PutUint32(frame[:sealedFrameHead], uint32(len(frame)-sealedFrameHead))
+	return frame
+}
+
+// read the sealed record at offset, return the encoded record and the size of frame
+func (df *DataFile) readSealed(offset int64) ([]byte, int64, error) {
+	fileSize, err := df.IOManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	if offset+sealedFrameHead > fileSize {
+		return nil, 0, io.EOF
+	}
+	sizeBuf, err := df.readNBytes(sealedFrameHead, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	size := int64(binary.LittleEndian.Uint32(sizeBuf))
+	// torn record at the end of file
+	if size == 0 || offset+sealedFrameHead+size > fileSize {
+		return nil, 0, io.EOF
+	}
+	sealed, err := df.readNBytes(size, offset+sealedFrameHead)
+	if err != nil {
+		return nil, 0, err
+	}
+	encRecord, err := df.aead.Open(sealed[:0], df.nonceAt(offset), sealed, nil)
+	if err != nil {
+		return nil, 0, ErrInvalidCRC
+	}
+	return encRecord, sealedFrameHead + size, nil
+}
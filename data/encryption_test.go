@@ -0,0 +1,107 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (p *testKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *testKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return key, nil
+}
+
+func TestDataFile_Encryption(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption")
+	defer os.RemoveAll(dir)
+	keys := &testKeyProvider{current: "k1", keys: map[string][]byte{"k1": bytes.Repeat([]byte("k"), 32)}}
+	opts := &FileOptions{Keys: keys}
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, opts)
+	assert.Nil(t, err)
+	assert.True(t, dataFile.Encrypted())
+	assert.Equal(t, "k1", dataFile.KeyId())
+
+	// case1: records are sealed, and offsets do not count the file header
+	value := bytes.Repeat([]byte("bitcask-go"), 100)
+	rec1 := &LogRecord{Key: []byte("name"), Value: value, Type: LogRecordNormal, SeqNo: 1}
+	rec2 := &LogRecord{Key: []byte("name"), Type: LogRecordDeleted, SeqNo: 2}
+	encRecord, _ := EncodeLogRecord(rec1)
+	assert.Nil(t, dataFile.Write(encRecord))
+	offset := dataFile.WriteOff
+	encRecord, _ = EncodeLogRecord(rec2)
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.Nil(t, dataFile.Close())
+
+	content, err := os.ReadFile(GetDataFileName(dir, 0))
+	assert.Nil(t, err)
+	assert.False(t, bytes.Contains(content, []byte("bitcask-go")))
+
+	// case2: reopen, reopened file is not appended
+	dataFile, err = OpenDataFile(dir, 0, fio.StandardFIO, opts)
+	assert.Nil(t, err)
+	lr, size, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, value, lr.Value)
+	assert.Equal(t, offset, size)
+	lr, _, err = dataFile.ReadLogRecord(offset)
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordDeleted, lr.Type)
+	fileSize, _ := dataFile.IOManager.Size()
+	_, _, err = dataFile.ReadLogRecord(fileSize)
+	assert.Equal(t, io.EOF, err)
+	assert.False(t, dataFile.Appendable())
+	assert.Equal(t, ErrNotAppendable, dataFile.Write(encRecord))
+	_, err = dataFile.NewValueReader(0)
+	assert.Equal(t, ErrValueReaderEncrypted, err)
+	assert.Nil(t, dataFile.Close())
+
+	// case3: mmap
+	dataFile, err = OpenDataFile(dir, 0, fio.MemoryMap, opts)
+	assert.Nil(t, err)
+	lr, _, err = dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, value, lr.Value)
+	assert.Nil(t, dataFile.Close())
+
+	// case4: no key, or tampered record
+	_, err = OpenDataFile(dir, 0, fio.StandardFIO, nil)
+	assert.Equal(t, ErrKeyRequired, err)
+	content[len(content)-1] ^= 0xff
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 0), content, 0644))
+	dataFile, err = OpenDataFile(dir, 0, fio.StandardFIO, opts)
+	assert.Nil(t, err)
+	_, _, err = dataFile.ReadLogRecord(offset)
+	assert.Equal(t, ErrInvalidCRC, err)
+	assert.Nil(t, dataFile.Close())
+
+	// case5: plain file is still plain
+	plainFile, err := OpenDataFile(dir, 1, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	encRecord, _ = EncodeLogRecord(rec1)
+	assert.Nil(t, plainFile.Write(encRecord))
+	assert.Nil(t, plainFile.Close())
+	plainFile, err = OpenDataFile(dir, 1, fio.StandardFIO, opts)
+	assert.Nil(t, err)
+	assert.False(t, plainFile.Encrypted())
+	lr, _, err = plainFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, value, lr.Value)
+	assert.Nil(t, plainFile.Close())
+}
@@ -0,0 +1,266 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrInvalidFileHeader = errors.New("invalid file header, the file maybe corrupted")
+	ErrNewerFileVersion  = errors.New("the file is written by a newer version of format, which is not supported")
+	ErrForeignFile       = errors.New("the file belongs to another database")
+	ErrInvalidUUID       = errors.New("invalid uuid")
+)
+
+//	+-------------+-------------+-------------+-------------+-------------+-------------+-------------+
+//	| magic       |   version   | created at  |    db id    | key id size |   key id    |    nonce    |
+//	+-------------+-------------+-------------+-------------+-------------+-------------+-------------+
+//	   4 bytes        1 byte       8 bytes       16 bytes       1 byte        VarLen       12 bytes
+//
+// records start after the header, and their offsets do not count it
+// nonce is only in encrypted files, whose key id is not empty
+// files without header are written before the header, and their records are of record format 1
+const FileFormatVersion = 1
+
+// layouts of log record, see EncodeLogRecord
+const (
+	RecordFormatVersion = 2 // layout of EncodeLogRecord, of files with header
+	recordFormatV1      = 1 // crc | type | key size | value size, of files without header
+)
+
+var fileHeaderMagic = []byte("BCSK")
+
+// the max size of file header
+const maxFileHeaderSize = 4 + 1 + 8 + 16 + 1 + 255 + nonceSize
+
+// id of db, kept in the header of its files
+type UUID [16]byte
+
+// new random (version 4) uuid
+func NewUUID() (UUID, error) {
+	var id UUID
+	if _, err := io.ReadFull(rand.Reader, id[:]); err != nil {
+		return id, err
+	}
+	id[6] = (id[6] & 0x0f) | 0x40
+	id[8] = (id[8] & 0x3f) | 0x80
+	return id, nil
+}
+
+// parse uuid in the form of String
+func ParseUUID(s string) (UUID, error) {
+	var id UUID
+	b := []byte(s)
+	if len(b) != 36 || b[8] != '-' || b[13] != '-' || b[18] != '-' || b[23] != '-' {
+		return id, ErrInvalidUUID
+	}
+	src := make([]byte, 0, 32)
+	src = append(src, b[0:8]...)
+	src = append(src, b[9:13]...)
+	src = append(src, b[14:18]...)
+	src = append(src, b[19:23]...)
+	src = append(src, b[24:]...)
+	if _, err := hex.Decode(id[:], src); err != nil {
+		return id, ErrInvalidUUID
+	}
+	return id, nil
+}
+
+func (id UUID) String() string {
+	s := hex.EncodeToString(id[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:]
+}
+
+func (id UUID) IsZero() bool {
+	return id == UUID{}
+}
+
+// options of the files of a db
+type FileOptions struct {
+	DBId UUID        // new files belong to it, and files of other dbs are refused, zero means not checked
+	Keys KeyProvider // new files are encrypted by its current key, nil means not encrypted
+	// files are opened by fio.ReadOnlyFIO, and empty files are left without header, e.g. when checking files offline
+	ReadOnly bool
+}
+
+type FileHeader struct {
+	Version   byte
+	CreatedAt int64 // unix nano timestamp
+	DBId      UUID
+	KeyId     string // empty if the file is not encrypted
+	nonce     []byte
+}
+
+func encodeFileHeader(header *FileHeader) []byte {
+	buf := make([]byte, 0, maxFileHeaderSize)
+	buf = append(buf, fileHeaderMagic...)
+	buf = append(buf, header.Version)
+	buf = binary.LittleEndian.AppendUint64(buf, uint64(header.CreatedAt))
+	buf = append(buf, header.DBId[:]...)
+	buf = append(buf, byte(len(header.KeyId)))
+	buf = append(buf, header.KeyId...)
+	return append(buf, header.nonce...)
+}
+
+// read the header at the start of file, nil if the file does not have one
+func readFileHeader(ioManager fio.IOManager) (*FileHeader, int64, error) {
+	size, err := ioManager.Size()
+	if err != nil {
+		return nil, 0, err
+	}
+	if size < int64(len(fileHeaderMagic)) {
+		return nil, 0, nil
+	}
+	n := int64(maxFileHeaderSize)
+	if n > size {
+		n = size
+	}
+	buf := make([]byte, n)
+	if _, err := ioManager.Read(buf, 0); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	if !bytes.Equal(buf[:len(fileHeaderMagic)], fileHeaderMagic) {
+		return nil, 0, nil
+	}
+
+	idx := len(fileHeaderMagic)
+	if len(buf) < idx+1 {
+		return nil, 0, ErrInvalidFileHeader
+	}
+	header := &FileHeader{Version: buf[idx]}
+	idx++
+	switch {
+	case header.Version == 0:
+		return nil, 0, ErrInvalidFileHeader
+	case header.Version > FileFormatVersion:
+		return nil, 0, ErrNewerFileVersion
+	}
+
+	if len(buf) < idx+8+len(header.DBId)+1 {
+		return nil, 0, ErrInvalidFileHeader
+	}
+	header.CreatedAt = int64(binary.LittleEndian.Uint64(buf[idx:]))
+	idx += 8
+	idx += copy(header.DBId[:], buf[idx:])
+
+	if len(buf) < idx+1 {
+		return nil, 0, ErrInvalidFileHeader
+	}
+	keyIdSize := int(buf[idx])
+	idx++
+	if keyIdSize == 0 {
+		return header, int64(idx), nil
+	}
+	if len(buf) < idx+keyIdSize+nonceSize {
+		return nil, 0, ErrInvalidFileHeader
+	}
+	header.KeyId = string(buf[idx : idx+keyIdSize])
+	header.nonce = append([]byte(nil), buf[idx+keyIdSize:idx+keyIdSize+nonceSize]...)
+	return header, int64(idx + keyIdSize + nonceSize), nil
+}
+
+// write the header of new file, which is encrypted by the current key of opts.Keys if it is not nil,
+// memory-mapped file can not be written, so the header is written by standard fio and the file is mapped again
+func (df *DataFile) initHeader(fileName string, ioType fio.FileIOType, opts *FileOptions) error {
+	header := &FileHeader{
+		Version:   FileFormatVersion,
+		CreatedAt: time.Now().UnixNano(),
+		DBId:      opts.DBId,
+	}
+	var aead cipher.AEAD
+	if opts.Keys != nil {
+		var err error
+		if aead, err = newFileEncryption(header, opts.Keys); err != nil {
+			return err
+		}
+	}
+	encHeader := encodeFileHeader(header)
+	if ioType == fio.StandardFIO {
+		if _, err := df.IOManager.Write(encHeader); err != nil {
+			return err
+		}
+	} else if err := df.writeHeaderAndRemap(fileName, ioType, encHeader); err != nil {
+		return err
+	}
+	df.setHeader(header, int64(len(encHeader)))
+	df.aead = aead
+	return nil
+}
+
+// check the header of file, and set up its encryption
+func (df *DataFile) openHeader(header *FileHeader, headerSize int64, opts *FileOptions) error {
+	// files created without db id, e.g. the merge-finished file, belong to any db
+	if !opts.DBId.IsZero() && !header.DBId.IsZero() && header.DBId != opts.DBId {
+		return ErrForeignFile
+	}
+	if header.KeyId != "" {
+		if opts.Keys == nil {
+			return ErrKeyRequired
+		}
+		aead, err := openFileEncryption(header, opts.Keys)
+		if err != nil {
+			return err
+		}
+		df.aead = aead
+		// the tail may be a torn record, appending to it would reuse its nonce
+		df.sealed = true
+	}
+	df.setHeader(header, headerSize)
+	return nil
+}
+
+func (df *DataFile) writeHeaderAndRemap(fileName string, ioType fio.FileIOType, encHeader []byte) error {
+	fileIO, err := fio.NewFileIOManager(fileName)
+	if err != nil {
+		return err
+	}
+	if _, err := fileIO.Write(encHeader); err != nil {
+		_ = fileIO.Close()
+		return err
+	}
+	if err := fileIO.Close(); err != nil {
+		return err
+	}
+	if err := df.IOManager.Close(); err != nil {
+		return err
+	}
+	df.IOManager, err = fio.NewIOManager(fileName, ioType)
+	return err
+}
+
+func (df *DataFile) setHeader(header *FileHeader, headerSize int64) {
+	df.header = header
+	df.recordFormat = RecordFormatVersion
+	df.headerSize = headerSize
+	df.IOManager = headerIOManager{IOManager: df.IOManager, headerSize: headerSize}
+}
+
+// header of file, nil if the file is written before the header, whose records are of record format 1
+func (df *DataFile) Header() *FileHeader {
+	return df.header
+}
+
+// io manager of the records after file header, offsets and size do not count the header
+type headerIOManager struct {
+	fio.IOManager
+	headerSize int64
+}
+
+func (m headerIOManager) Read(b []byte, offset int64) (int, error) {
+	return m.IOManager.Read(b, offset+m.headerSize)
+}
+
+func (m headerIOManager) Size() (int64, error) {
+	size, err := m.IOManager.Size()
+	if err != nil {
+		return 0, err
+	}
+	return size - m.headerSize, nil
+}
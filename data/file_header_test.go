@@ -0,0 +1,121 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUUID(t *testing.T) {
+	id, err := NewUUID()
+	assert.Nil(t, err)
+	assert.False(t, id.IsZero())
+	parsed, err := ParseUUID(id.String())
+	assert.Nil(t, err)
+	assert.Equal(t, id, parsed)
+	_, err = ParseUUID("not-a-uuid")
+	assert.Equal(t, ErrInvalidUUID, err)
+}
+
+func TestDataFile_Header(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-header")
+	defer os.RemoveAll(dir)
+	id, _ := NewUUID()
+	opts := &FileOptions{DBId: id}
+
+	// case1: new file starts with header
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, opts)
+	assert.Nil(t, err)
+	header := dataFile.Header()
+	assert.NotNil(t, header)
+	assert.Equal(t, byte(FileFormatVersion), header.Version)
+	assert.Equal(t, id, header.DBId)
+	assert.True(t, header.CreatedAt > 0)
+	encRecord, size := EncodeLogRecord(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go")})
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.Equal(t, size, dataFile.WriteOff)
+	assert.Nil(t, dataFile.Close())
+
+	// case2: reopen, records start after header
+	dataFile, err = OpenDataFile(dir, 0, fio.MemoryMap, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, header, dataFile.Header())
+	lr, _, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), lr.Value)
+	fileSize, err := dataFile.IOManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, size, fileSize)
+	assert.Nil(t, dataFile.Close())
+
+	// case3: file of another db, files are not checked without id
+	otherId, _ := NewUUID()
+	_, err = OpenDataFile(dir, 0, fio.StandardFIO, &FileOptions{DBId: otherId})
+	assert.Equal(t, ErrForeignFile, err)
+	dataFile, err = OpenDataFile(dir, 0, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+
+	// case4: newer version of format
+	newer := encodeFileHeader(&FileHeader{Version: FileFormatVersion + 1, DBId: id})
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 1), newer, 0644))
+	_, err = OpenDataFile(dir, 1, fio.StandardFIO, opts)
+	assert.Equal(t, ErrNewerFileVersion, err)
+
+	// case5: file without header is of record format 1, and it is not appended
+	legacyRecord := encodeLogRecordV1(&LogRecord{Key: []byte("name"), Value: []byte("bitcask-go"), Type: LogRecordDeleted})
+	assert.Nil(t, os.WriteFile(GetDataFileName(dir, 3), legacyRecord, 0644))
+	legacy, err := OpenDataFile(dir, 3, fio.StandardFIO, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, legacy.Header())
+	assert.False(t, legacy.Appendable())
+	lr, recordSize, err := legacy.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), lr.Value)
+	assert.Equal(t, LogRecordDeleted, lr.Type)
+	assert.Equal(t, int64(len(legacyRecord)), recordSize)
+	assert.Equal(t, ErrNotAppendable, legacy.Write(encRecord))
+	assert.Nil(t, legacy.Close())
+
+	// case6: new file without options has header of no db, and new memory-mapped file has header too
+	dataFile, err = OpenDataFile(dir, 4, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	assert.True(t, dataFile.Header().DBId.IsZero())
+	assert.Nil(t, dataFile.Close())
+	dataFile, err = OpenDataFile(dir, 4, fio.StandardFIO, opts)
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+	dataFile, err = OpenDataFile(dir, 5, fio.MemoryMap, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, id, dataFile.Header().DBId)
+	fileSize, err = dataFile.IOManager.Size()
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), fileSize)
+	assert.Nil(t, dataFile.Close())
+
+	// case7: files of other kinds
+	hintFile, err := OpenHintFile(dir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, id, hintFile.Header().DBId)
+	assert.Nil(t, hintFile.Close())
+	_, err = os.Stat(filepath.Join(dir, HintFileName))
+	assert.Nil(t, err)
+}
+
+// record of format 1, written by the code before the file header
+func encodeLogRecordV1(lr *LogRecord) []byte {
+	buf := make([]byte, 5+binary.MaxVarintLen32*2)
+	buf[4] = lr.Type
+	index := 5
+	index += binary.PutVarint(buf[index:], int64(len(lr.Key)))
+	index += binary.PutVarint(buf[index:], int64(len(lr.Value)))
+	buf = append(buf[:index], lr.Key...)
+	buf = append(buf, lr.Value...)
+	binary.LittleEndian.PutUint32(buf[:4], crc32.ChecksumIEEE(buf[4:]))
+	return buf
+}
@@ -3,6 +3,7 @@ package data
 import (
 	"encoding/binary"
 	"hash/crc32"
+	"time"
 )
 
 type LogRecordType = byte
@@ -11,23 +12,46 @@ const (
 	LogRecordNormal LogRecordType = iota
 	LogRecordDeleted
 	LogRecordTxnFinish
+	LogRecordMerge        // merge operand, resolved with the previous value when reading
+	LogRecordRangeDeleted // range tombstone, key is the start and value is the end (empty means unbounded)
+	LogRecordHistory      // only in hint file, an old version kept by merge, value is empty if the version is deleted
+	LogRecordBlob         // normal record whose value is in blob file, value is the encoded BlobPos
+	LogRecordBlobMoved    // written by blob gc, the same version as the blob record before it with the moved BlobPos
 )
 
-// crc type key-sz value-sz
-// 4  + 1   +  5   + 5
-const maxLogRecordHeaderSize = binary.MaxVarintLen32*2 + 5
+// the low 4 bits of type byte in header is the record type, and the high 4 bits is the codec of value
+const (
+	recordTypeMask = 0x0f
+	codecShift     = 4
+)
+
+// crc type key-sz value-sz expire seq-no namespace timestamp
+// 4  + 1   +  5   + 5     +  10   + 10   +  5       +  10
+const maxLogRecordHeaderSize = binary.MaxVarintLen32*3 + binary.MaxVarintLen64*3 + 5
 
 type LogRecord struct {
-	Key   []byte
-	Value []byte
-	Type  LogRecordType
+	Key       []byte
+	Value     []byte
+	Type      LogRecordType
+	Expire    int64  // unix nano timestamp, 0 means never expire
+	SeqNo     uint64 // commit seqNo, records of one batch share the same one
+	Namespace uint32 // id of the namespace the key belongs to, 0 is the default one
+	Timestamp int64  // unix nano timestamp of writing
+	// codec to compress value with when encoding, value is kept as it is if it does not shrink,
+	// after reading it is the codec of value on disk, and Value is always decompressed
+	Codec CodecId
 }
 
 type LogRecordHeader struct {
 	crc        uint32
 	recordType LogRecordType
+	codec      CodecId
 	keySize    uint32
 	valueSize  uint32
+	expire     int64
+	seqNo      uint64
+	namespace  uint32
+	timestamp  int64
 }
 
 // memory index, to describe the postion of log_record on disk
@@ -35,6 +59,29 @@ type LogRecordPos struct {
 	Fid    uint32 //which file
 	Offset int64  //where in the file
 	Size   uint32
+	Expire int64         // same as LogRecord.Expire, so expired keys can be judged without reading disk
+	Prev   *LogRecordPos // for merge operand, pos of the previous record it applies to
+}
+
+// if the record pointed by pos has expired
+func (pos *LogRecordPos) IsExpired() bool {
+	return pos.Expire > 0 && pos.Expire <= time.Now().UnixNano()
+}
+
+// size of the record together with the merge operands chain it depends on
+func (pos *LogRecordPos) TotalSize() int64 {
+	var size int64
+	for p := pos; p != nil; p = p.Prev {
+		size += int64(p.Size)
+	}
+	return size
+}
+
+// position of a large value in blob file
+type BlobPos struct {
+	Fid    uint32
+	Offset int64
+	Size   uint32 // size of the record in blob file
 }
 
 // for write batch
@@ -43,63 +90,101 @@ type TransactionRecord struct {
 	Pos    *LogRecordPos
 }
 
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	| crc         |  type       |    key size |   value size |      key    |      value   |
-//	+-------------+-------------+-------------+--------------+-------------+--------------+
-//	    4 bytes       1 byte     VarLen（max:5）VarLen（max:5）  VarLen         VarLen
+//	+-------------+-------------+-------------+--------------+---------------+---------------+---------------+---------------+-------------+--------------+
+//	| crc         |  type       |    key size |   value size |    expire     |    seq no     |   namespace   |   timestamp   |      key    |      value   |
+//	+-------------+-------------+-------------+--------------+---------------+---------------+---------------+---------------+-------------+--------------+
+//	    4 bytes       1 byte     VarLen（max:5）VarLen（max:5） VarLen（max:10） VarLen（max:10） VarLen（max:5） VarLen（max:10）   VarLen         VarLen
 
-// encode, from log_record(struct) to []byte
+// encode, from log_record(struct) to []byte, value is compressed with log_record.Codec
 // return encode log record and the length of that
 func EncodeLogRecord(log_record *LogRecord) ([]byte, int64) {
+	value, codec := compressValue(log_record.Codec, log_record.Value)
+	encBytes := encodeLogRecordHead(log_record, codec, int64(len(value)), len(value))
+	encBytes = append(encBytes, value...)
+
+	//set crc
+	crc := crc32.ChecksumIEEE(encBytes[4:])
+	//copy crc to encBytes array, use little endian
+	binary.LittleEndian.PutUint32(encBytes[:4], crc)
+
+	// fmt.Printf("header length:%d, crc:%d", index, crc)
+
+	return encBytes, int64(len(encBytes))
+}
+
+// encode header and key of log record whose value has valueSize bytes and is written after them,
+// the value is not compressed, and crc is set when writing, see DataFile.WriteLogRecordFrom
+func EncodeLogRecordHead(log_record *LogRecord, valueSize int64) []byte {
+	return encodeLogRecordHead(log_record, CodecNone, valueSize, 0)
+}
+
+// extra is the capacity left for value
+func encodeLogRecordHead(log_record *LogRecord, codec CodecId, valueSize int64, extra int) []byte {
 	//initialize the header
 	header := make([]byte, maxLogRecordHeaderSize)
 
 	//quit crc
-	//type
-	header[4] = log_record.Type
+	//type and codec
+	header[4] = log_record.Type | codec<<codecShift
 
 	//key_sz use variable length arry
 	var index = 5
 	index += binary.PutVarint(header[index:], int64(len(log_record.Key)))
 
 	//value_sz
-	index += binary.PutVarint(header[index:], int64(len(log_record.Value)))
+	index += binary.PutVarint(header[index:], valueSize)
+
+	//expire
+	index += binary.PutVarint(header[index:], log_record.Expire)
+
+	//seq no
+	index += binary.PutUvarint(header[index:], log_record.SeqNo)
+
+	//namespace
+	index += binary.PutUvarint(header[index:], uint64(log_record.Namespace))
+
+	//timestamp
+	index += binary.PutVarint(header[index:], log_record.Timestamp)
 
-	//as we need to return the length of the record
-	var recordSize = index + len(log_record.Key) + len(log_record.Value)
-	//as we need to return the obj encoded log record
-	//initialize the space, according to recordSize
-	encBytes := make([]byte, recordSize)
+	//initialize the space of header and key, value is appended later
+	encBytes := make([]byte, index+len(log_record.Key), index+len(log_record.Key)+extra)
 	//copy header array to encBytes array
 	copy(encBytes[:index], header[:index])
 	//copy key to encBytes array
 	copy(encBytes[index:], log_record.Key)
-	//copy value to encBytes array
-	copy(encBytes[index+len(log_record.Key):], log_record.Value)
-
-	//set crc
-	crc := crc32.ChecksumIEEE(encBytes[4:])
-	//copy crc to encBytes array, use little endian
-	binary.LittleEndian.PutUint32(encBytes[:4], crc)
 
-	// fmt.Printf("header length:%d, crc:%d", index, crc)
-
-	return encBytes, int64(recordSize)
+	return encBytes
 }
 
 // param: *logRecordPos, return []byte
+// chain of merge operands is encoded one by one after the head pos
 func EncodeLogRecordPos(pos *LogRecordPos) []byte {
-	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
-	var idx = 0
-	idx += binary.PutVarint(buf[idx:], int64(pos.Fid))
-	idx += binary.PutVarint(buf[idx:], pos.Offset)
-	idx += binary.PutVarint(buf[idx:], int64(pos.Size))
+	var buf []byte
+	for p := pos; p != nil; p = p.Prev {
+		b := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64*2)
+		var idx = 0
+		idx += binary.PutVarint(b[idx:], int64(p.Fid))
+		idx += binary.PutVarint(b[idx:], p.Offset)
+		idx += binary.PutVarint(b[idx:], int64(p.Size))
+		idx += binary.PutVarint(b[idx:], p.Expire)
+		buf = append(buf, b[:idx]...)
+	}
 
-	return buf[:idx]
+	return buf
 }
 
 // param: []byte, return *LogRecordPos
 func DeCodeLogRecordPos(buf []byte) *LogRecordPos {
+	pos, idx := decodeOneLogRecordPos(buf)
+	for p := pos; idx < len(buf); p = p.Prev {
+		prev, n := decodeOneLogRecordPos(buf[idx:])
+		idx += n
+		p.Prev = prev
+	}
+	return pos
+}
+
+func decodeOneLogRecordPos(buf []byte) (*LogRecordPos, int) {
 	var idx = 0
 	fid, n := binary.Varint(buf[idx:])
 	idx += n
@@ -107,12 +192,38 @@ func DeCodeLogRecordPos(buf []byte) *LogRecordPos {
 	idx += n
 	size, n := binary.Varint(buf[idx:])
 	idx += n
+	// pos encoded before ttl was supported does not have expire
+	var expire int64
+	if idx < len(buf) {
+		expire, n = binary.Varint(buf[idx:])
+		idx += n
+	}
 
 	return &LogRecordPos{
 		Fid:    uint32(fid),
 		Offset: offset,
 		Size:   uint32(size),
-	}
+		Expire: expire,
+	}, idx
+}
+
+func EncodeBlobPos(pos *BlobPos) []byte {
+	buf := make([]byte, binary.MaxVarintLen32*2+binary.MaxVarintLen64)
+	var idx = 0
+	idx += binary.PutVarint(buf[idx:], int64(pos.Fid))
+	idx += binary.PutVarint(buf[idx:], pos.Offset)
+	idx += binary.PutVarint(buf[idx:], int64(pos.Size))
+	return buf[:idx]
+}
+
+func DecodeBlobPos(buf []byte) *BlobPos {
+	var idx = 0
+	fid, n := binary.Varint(buf[idx:])
+	idx += n
+	offset, n := binary.Varint(buf[idx:])
+	idx += n
+	size, _ := binary.Varint(buf[idx:])
+	return &BlobPos{Fid: uint32(fid), Offset: offset, Size: uint32(size)}
 }
 
 // return header and the length of header
@@ -125,7 +236,8 @@ func DecodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	// get crc and type
 	header := &LogRecordHeader{
 		crc:        binary.LittleEndian.Uint32(buf[:4]),
-		recordType: buf[4],
+		recordType: buf[4] & recordTypeMask,
+		codec:      buf[4] >> codecShift,
 	}
 	//get key_sz
 	var index = 5
@@ -136,7 +248,42 @@ func DecodeLogRecordHeader(buf []byte) (*LogRecordHeader, int64) {
 	valueSize, n := binary.Varint(buf[index:])
 	index += n
 	header.valueSize = uint32(valueSize)
+	//get expire
+	expire, n := binary.Varint(buf[index:])
+	index += n
+	header.expire = expire
+	//get seq no
+	seqNo, n := binary.Uvarint(buf[index:])
+	index += n
+	header.seqNo = seqNo
+	//get namespace
+	namespace, n := binary.Uvarint(buf[index:])
+	index += n
+	header.namespace = uint32(namespace)
+	//get timestamp
+	timestamp, n := binary.Varint(buf[index:])
+	index += n
+	header.timestamp = timestamp
+
+	return header, int64(index)
+}
 
+// decode the header of record format 1, crc | type | key size | value size
+func decodeLogRecordHeaderV1(buf []byte) (*LogRecordHeader, int64) {
+	if len(buf) <= 4 {
+		return nil, 0
+	}
+	header := &LogRecordHeader{
+		crc:        binary.LittleEndian.Uint32(buf[:4]),
+		recordType: buf[4],
+	}
+	var index = 5
+	keySize, n := binary.Varint(buf[index:])
+	index += n
+	header.keySize = uint32(keySize)
+	valueSize, n := binary.Varint(buf[index:])
+	index += n
+	header.valueSize = uint32(valueSize)
 	return header, int64(index)
 }
 
@@ -3,6 +3,7 @@ package data
 import (
 	"hash/crc32"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -16,7 +17,7 @@ func TestEncodeLogRecord(t *testing.T) {
 	}
 	res1, n := EncodeLogRecord(record1)
 	// t.Log(res1)
-	//header length:7, crc:4128294378
+	//header length:11, crc:2751952101
 	assert.NotNil(t, res1)
 	assert.Greater(t, n, int64(5))
 
@@ -27,7 +28,7 @@ func TestEncodeLogRecord(t *testing.T) {
 	}
 	res2, n := EncodeLogRecord(record2)
 	// t.Log(res2)
-	// header length:7, crc:240712713
+	// header length:11, crc:121065474
 	assert.NotNil(t, res2)
 	assert.Greater(t, n, int64(5))
 
@@ -39,39 +40,91 @@ func TestEncodeLogRecord(t *testing.T) {
 	}
 	res3, n := EncodeLogRecord(record3)
 	// t.Log(res3)
-	//header length:7, crc:1907756713
+	//header length:11, crc:1004402555
 	assert.NotNil(t, res3)
 	assert.Greater(t, n, int64(5))
+
+	// with expire
+	record4 := &LogRecord{
+		Key:    []byte("name"),
+		Value:  []byte("bitcack-go"),
+		Type:   LogRecordNormal,
+		Expire: time.Now().UnixNano(),
+	}
+	res4, n := EncodeLogRecord(record4)
+	assert.NotNil(t, res4)
+	h4, size4 := DecodeLogRecordHeader(res4)
+	assert.Equal(t, record4.Expire, h4.expire)
+	assert.Equal(t, int64(len(record4.Key)+len(record4.Value))+size4, n)
+
+	// with seq no
+	record5 := &LogRecord{
+		Key:   []byte("name"),
+		Value: []byte("bitcack-go"),
+		Type:  LogRecordNormal,
+		SeqNo: 1 << 40,
+	}
+	res5, n := EncodeLogRecord(record5)
+	assert.NotNil(t, res5)
+	h5, size5 := DecodeLogRecordHeader(res5)
+	assert.Equal(t, record5.SeqNo, h5.seqNo)
+	assert.Equal(t, int64(len(record5.Key)+len(record5.Value))+size5, n)
+
+	// with namespace
+	record6 := &LogRecord{
+		Key:       []byte("name"),
+		Value:     []byte("bitcack-go"),
+		Type:      LogRecordNormal,
+		Namespace: 300,
+	}
+	res6, n := EncodeLogRecord(record6)
+	assert.NotNil(t, res6)
+	h6, size6 := DecodeLogRecordHeader(res6)
+	assert.Equal(t, record6.Namespace, h6.namespace)
+	assert.Equal(t, int64(len(record6.Key)+len(record6.Value))+size6, n)
+
+	// with timestamp
+	record7 := &LogRecord{
+		Key:       []byte("name"),
+		Value:     []byte("bitcack-go"),
+		Type:      LogRecordNormal,
+		Timestamp: time.Now().UnixNano(),
+	}
+	res7, n := EncodeLogRecord(record7)
+	assert.NotNil(t, res7)
+	h7, size7 := DecodeLogRecordHeader(res7)
+	assert.Equal(t, record7.Timestamp, h7.timestamp)
+	assert.Equal(t, int64(len(record7.Key)+len(record7.Value))+size7, n)
 }
 
 func TestDecodeLogRecordHeader(t *testing.T) {
 	// normal
-	headBuf1 := []byte{234, 197, 16, 246, 0, 8, 20}
+	headBuf1 := []byte{229, 116, 7, 164, 0, 8, 20, 0, 0, 0, 0}
 	h1, size1 := DecodeLogRecordHeader(headBuf1)
 	assert.NotNil(t, h1)
-	assert.Equal(t, int64(7), size1)
-	assert.Equal(t, uint32(4128294378), h1.crc)
+	assert.Equal(t, int64(11), size1)
+	assert.Equal(t, uint32(2751952101), h1.crc)
 	assert.Equal(t, LogRecordNormal, h1.recordType)
 	assert.Equal(t, uint32(4), h1.keySize)
 	assert.Equal(t, uint32(10), h1.valueSize)
 
 	// value = nil
-	headBuf2 := []byte{9, 252, 88, 14, 0, 8, 0}
+	headBuf2 := []byte{2, 80, 55, 7, 0, 8, 0, 0, 0, 0, 0}
 	h2, size2 := DecodeLogRecordHeader(headBuf2)
 	assert.NotNil(t, h2)
 	// t.Log(h2, size2)
-	assert.Equal(t, int64(7), size2)
-	assert.Equal(t, uint32(240712713), h2.crc)
+	assert.Equal(t, int64(11), size2)
+	assert.Equal(t, uint32(121065474), h2.crc)
 	assert.Equal(t, LogRecordNormal, h2.recordType)
 	assert.Equal(t, uint32(4), h2.keySize)
 	assert.Equal(t, uint32(0), h2.valueSize)
 
 	// type = deleted
-	headBuf3 := []byte{169, 14, 182, 113, 1, 8, 20}
+	headBuf3 := []byte{123, 247, 221, 59, 1, 8, 20, 0, 0, 0, 0}
 	h3, size3 := DecodeLogRecordHeader(headBuf3)
 	assert.NotNil(t, h3)
-	assert.Equal(t, int64(7), size3)
-	assert.Equal(t, uint32(1907756713), h3.crc)
+	assert.Equal(t, int64(11), size3)
+	assert.Equal(t, uint32(1004402555), h3.crc)
 	assert.Equal(t, LogRecordDeleted, h3.recordType)
 	assert.Equal(t, uint32(4), h3.keySize)
 	assert.Equal(t, uint32(10), h3.valueSize)
@@ -84,19 +137,19 @@ func TestGetLogRecordCRC(t *testing.T) {
 		Value: []byte("bitcack-go"),
 		Type:  LogRecordNormal,
 	}
-	headerBuf1 := []byte{234, 197, 16, 246, 0, 8, 20}
+	headerBuf1 := []byte{229, 116, 7, 164, 0, 8, 20, 0, 0, 0, 0}
 
 	crc1 := getLogRecordCRC(record1, headerBuf1[crc32.Size:])
-	assert.Equal(t, uint32(4128294378), crc1)
+	assert.Equal(t, uint32(2751952101), crc1)
 
 	// value = nil
 	record2 := &LogRecord{
 		Key:  []byte("name"),
 		Type: LogRecordNormal,
 	}
-	headBuf2 := []byte{9, 225, 88, 14, 0, 8, 0}
+	headBuf2 := []byte{2, 80, 55, 7, 0, 8, 0, 0, 0, 0, 0}
 	crc2 := getLogRecordCRC(record2, headBuf2[crc32.Size:])
-	assert.Equal(t, uint32(240712713), crc2)
+	assert.Equal(t, uint32(121065474), crc2)
 
 	// type = deleted
 	record3 := &LogRecord{
@@ -104,8 +157,30 @@ func TestGetLogRecordCRC(t *testing.T) {
 		Value: []byte("bitcack-go"),
 		Type:  LogRecordDeleted,
 	}
-	headerBuf3 := []byte{169, 14, 182, 113, 1, 8, 20}
+	headerBuf3 := []byte{123, 247, 221, 59, 1, 8, 20, 0, 0, 0, 0}
 
 	crc3 := getLogRecordCRC(record3, headerBuf3[crc32.Size:])
-	assert.Equal(t, uint32(1907756713), crc3)
+	assert.Equal(t, uint32(1004402555), crc3)
+}
+
+func TestLogRecordPos_Encode(t *testing.T) {
+	pos1 := &LogRecordPos{Fid: 1, Offset: 100, Size: 20}
+	res1 := DeCodeLogRecordPos(EncodeLogRecordPos(pos1))
+	assert.Equal(t, pos1, res1)
+	assert.False(t, res1.IsExpired())
+
+	// expired
+	pos2 := &LogRecordPos{Fid: 2, Offset: 200, Size: 30, Expire: time.Now().Add(-time.Second).UnixNano()}
+	res2 := DeCodeLogRecordPos(EncodeLogRecordPos(pos2))
+	assert.Equal(t, pos2, res2)
+	assert.True(t, res2.IsExpired())
+
+	// not expired yet
+	pos3 := &LogRecordPos{Fid: 3, Offset: 300, Size: 40, Expire: time.Now().Add(time.Hour).UnixNano()}
+	assert.False(t, pos3.IsExpired())
+}
+
+func TestBlobPos_Encode(t *testing.T) {
+	pos := &BlobPos{Fid: 3, Offset: 1 << 40, Size: 50 * 1024 * 1024}
+	assert.Equal(t, pos, DecodeBlobPos(EncodeBlobPos(pos)))
 }
@@ -0,0 +1,107 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// reader of the value of a log record, which reads from the file without holding the whole value
+// crc is checked when the value has been read in order from the start to the end
+type ValueReader struct {
+	*io.SectionReader
+	typ    LogRecordType
+	codec  CodecId
+	crc    hash.Hash32
+	want   uint32
+	hashed int64 // the value before it has been added to crc
+	err    error // ErrInvalidCRC, returned by all later reads
+}
+
+// read header and key of the record at offset, value is read by the returned reader
+func (df *DataFile) NewValueReader(offset int64) (*ValueReader, error) {
+	if df.aead != nil {
+		return nil, ErrValueReaderEncrypted
+	}
+	fileSize, err := df.IOManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	var headerBytes int64 = maxLogRecordHeaderSize
+	if headerBytes+offset > fileSize {
+		headerBytes = fileSize - offset
+	}
+	headBuf, err := df.readNBytes(headerBytes, offset)
+	if err != nil {
+		return nil, err
+	}
+	header, headerSize := df.decodeLogRecordHeader(headBuf)
+	if header == nil {
+		return nil, io.EOF
+	}
+	key, err := df.readNBytes(int64(header.keySize), offset+headerSize)
+	if err != nil {
+		return nil, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(headBuf[crc32.Size:headerSize])
+	crc.Write(key)
+	vr := &ValueReader{
+		SectionReader: io.NewSectionReader(ioManagerReaderAt{df.IOManager}, offset+headerSize+int64(header.keySize), int64(header.valueSize)),
+		typ:           header.recordType,
+		codec:         header.codec,
+		crc:           crc,
+		want:          header.crc,
+	}
+	if header.valueSize == 0 {
+		vr.checkCRC()
+	}
+	return vr, vr.err
+}
+
+func (vr *ValueReader) Type() LogRecordType {
+	return vr.typ
+}
+
+// codec of value, the reader reads the compressed value if it is not CodecNone
+func (vr *ValueReader) Codec() CodecId {
+	return vr.codec
+}
+
+func (vr *ValueReader) Read(p []byte) (int, error) {
+	if vr.err != nil {
+		return 0, vr.err
+	}
+	off, _ := vr.SectionReader.Seek(0, io.SeekCurrent)
+	n, err := vr.SectionReader.Read(p)
+
+	// only the value read in order from the start is added to crc
+	if off <= vr.hashed && off+int64(n) > vr.hashed {
+		vr.crc.Write(p[vr.hashed-off : n])
+		vr.hashed = off + int64(n)
+		if vr.hashed == vr.Size() {
+			vr.checkCRC()
+		}
+	}
+	if vr.err != nil {
+		return n, vr.err
+	}
+	return n, err
+}
+
+func (vr *ValueReader) checkCRC() {
+	if vr.crc.Sum32() != vr.want {
+		vr.err = ErrInvalidCRC
+	}
+}
+
+// io.ReaderAt of io manager, for io.SectionReader
+type ioManagerReaderAt struct {
+	fio.IOManager
+}
+
+func (r ioManagerReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	return r.IOManager.Read(p, off)
+}
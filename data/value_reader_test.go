@@ -0,0 +1,59 @@
+package data
+
+import (
+	"bitcask-go/fio"
+	"bytes"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDataFile_ValueReader(t *testing.T) {
+	dir, _ := os.MkdirTemp("", "bitcask-go-value-reader")
+	defer os.RemoveAll(dir)
+	dataFile, err := OpenDataFile(dir, 0, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	defer dataFile.Close()
+
+	// case1: value written from reader is the same as the encoded one
+	value := bytes.Repeat([]byte("bitcask-go"), 10000)
+	rec := &LogRecord{Key: []byte("name"), Type: LogRecordNormal, SeqNo: 3}
+	err = dataFile.WriteLogRecordFrom(EncodeLogRecordHead(rec, int64(len(value))), bytes.NewReader(value), int64(len(value)))
+	assert.Nil(t, err)
+	rec.Value = value
+	lr, size, err := dataFile.ReadLogRecord(0)
+	assert.Nil(t, err)
+	assert.Equal(t, rec, lr)
+	assert.Equal(t, dataFile.WriteOff, size)
+
+	// case2: read in order, and seek
+	vr, err := dataFile.NewValueReader(0)
+	assert.Nil(t, err)
+	assert.Equal(t, LogRecordNormal, vr.Type())
+	_, err = vr.Seek(10, io.SeekStart)
+	assert.Nil(t, err)
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(vr, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, value[10:20], buf)
+	_, err = vr.Seek(0, io.SeekStart)
+	assert.Nil(t, err)
+	res, err := io.ReadAll(vr)
+	assert.Nil(t, err)
+	assert.Equal(t, value, res)
+
+	// case3: corrupted value is found at the end
+	corrupted := append([]byte(nil), value...)
+	corrupted[len(corrupted)-1] = 'x'
+	head := EncodeLogRecordHead(rec, int64(len(value)))
+	encRecord, _ := EncodeLogRecord(rec)
+	copy(head[:4], encRecord[:4])
+	err = dataFile.Write(append(head, corrupted...))
+	assert.Nil(t, err)
+	vr, err = dataFile.NewValueReader(size)
+	assert.Nil(t, err)
+	_, err = io.ReadAll(vr)
+	assert.Equal(t, ErrInvalidCRC, err)
+}
@@ -5,6 +5,8 @@ import (
 	"bitcask-go/fio"
 	"bitcask-go/index"
 	"bitcask-go/utils"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -13,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/gofrs/flock"
 )
@@ -23,31 +26,63 @@ const (
 )
 
 type DB struct {
-	options         Options
-	mu              *sync.RWMutex
-	fileIds         []int                     // only for loading index from data files
-	activeFile      *data.DataFile            //current active file, append log_record
-	olderFiles      map[uint32]*data.DataFile //order files, read only
-	index           index.Indexer
-	seqNo           uint64 // id for transaction, global variable,  ++
-	isMerging       bool   // if db is merging
-	seqNoFileExists bool
-	isInitial       bool         // first time to set up
-	flock           *flock.Flock // ensure mutual exclusion between multiple processes
-	bytesWrite      uint         //total number of bytes written
-	reclaimSize     int64        // count invalid log record (for merge)
+	options           Options
+	mu                *sync.RWMutex
+	fileIds           []int                     // only for loading index from data files
+	activeFile        *data.DataFile            //current active file, append log_record
+	olderFiles        map[uint32]*data.DataFile //order files, read only
+	index             *expiryIndex
+	seqNo             uint64 // id for transaction, global variable,  ++
+	isMerging         bool   // if db is merging
+	seqNoFileExists   bool
+	isInitial         bool              // first time to set up
+	flock             *flock.Flock      // ensure mutual exclusion between multiple processes
+	bytesWrite        uint              //total number of bytes written
+	reclaimSize       int64             // count invalid log record (for merge)
+	activeTxns        int               // number of running transactions
+	keyCommitSeqNos   map[string]uint64 // key -> seqNo of its last commit, for conflict detection of transactions
+	commitSeqNo       uint64            // seqNo of the last committed write or batch, persisted in log records, under mu
+	changesFloor      uint64            // changes after this seqNo are all kept in data files, see ChangesSince
+	watchLock         *sync.Mutex
+	watchers          map[*watcher]struct{}                // subscribers of Watch
+	namespaces        map[string]*Namespace                // name -> namespace, under mu
+	namespaceIds      map[uint32]*Namespace                // id -> namespace, under mu
+	nextNamespaceId   uint32                               // id of the next created namespace, 0 is the default one
+	closed            bool                                 // under mu, set by Close
+	inflight          sync.WaitGroup                       // running iterators and Compact, Close waits for them
+	secondaryIndexes  map[string]*secondaryIndex           // name -> secondary index, under mu
+	unfinishedTxns    map[uint64][]*data.TransactionRecord // batches without finish record, kept by read-only db for Refresh
+	histories         map[string]*keyHistory               // key -> retained versions, nil if version retention is disabled, under mu
+	activeBlobFile    *data.DataFile                       // large values are appended to it, see Options.ValueThreshold
+	blobFiles         map[uint32]*data.DataFile            // all blob files, including the active one
+	blobRefs          map[recordPosition]*data.BlobPos     // pos of valid blob record -> pos of its value, under mu
+	blobReclaimSize   map[uint32]int64                     // blob file id -> size of invalid values in it, under mu
+	droppedIndexes    []index.Indexer                      // indexes of dropped namespaces, whose blobs are released by CompactBlobs, under mu
+	isCompactingBlobs bool                                 // if CompactBlobs is running
+	blobPins          map[uint32]int                       // blob file id -> number of readers from GetReader, snapshots and iterators, under mu
+	keys              data.KeyProvider                     // keys of encrypted files, nil if encryption is disabled
+	fileOptions       *data.FileOptions                    // header of new files, and files are checked with it
+	recovery          RecoveryReport                       // corrupted data discarded when db opens
 }
 
 // statistics of db
 type Stat struct {
-	KeyNum      uint
-	DataFileNum uint
-	ReclaimSize int64 //invalid log record pos size
-	DiskSize    int64 //x-disk capacity is occupied
+	KeyNum          uint
+	DataFileNum     uint
+	ReclaimSize     int64 //invalid log record pos size
+	DiskSize        int64 //x-disk capacity is occupied
+	BlobFileNum     uint
+	BlobReclaimSize int64 // invalid values in blob files, reclaimed by CompactBlobs
 }
 
 // open the bitcask-db instance
 func Open(options Options) (*DB, error) {
+	return OpenContext(context.Background(), options)
+}
+
+// open the bitcask-db instance, loading index stops when ctx is done,
+// then the opened files are closed and the directory is unlocked
+func OpenContext(ctx context.Context, options Options) (*DB, error) {
 	// check the input_options
 	if err := CheckOptions(options); err != nil {
 		return nil, err
@@ -56,15 +91,19 @@ func Open(options Options) (*DB, error) {
 	var isInitial bool
 	// if option.dir does not exist, then create it
 	if _, err := os.Stat(options.DirPath); os.IsNotExist(err) {
+		// read-only db never creates anything
+		if options.ReadOnly {
+			return nil, err
+		}
 		isInitial = true
 		if err = os.MkdirAll(options.DirPath, os.ModePerm); err != nil {
 			return nil, err
 		}
 	}
 
-	// try to get flock, cz only one process can use the db at one time
-	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
-	hold, err := fileLock.TryLock()
+	// try to get flock, cz only one process can write the db at one time,
+	// and read-only processes share the reader lock with each other and with the writer
+	fileLock, hold, err := lockDir(options)
 	if err != nil {
 		return nil, err
 	}
@@ -84,63 +123,152 @@ func Open(options Options) (*DB, error) {
 
 	// initialize DB struct
 	db := &DB{
-		options:    options,
-		mu:         new(sync.RWMutex),
-		olderFiles: make(map[uint32]*data.DataFile),
-		index:      index.NewIndexer(int8(options.IndexType), options.DirPath, options.SyncWrites),
-		isInitial:  isInitial,
-		flock:      fileLock,
+		options:          options,
+		mu:               new(sync.RWMutex),
+		olderFiles:       make(map[uint32]*data.DataFile),
+		index:            newExpiryIndex(index.NewIndexer(int8(options.IndexType), options.DirPath, options.SyncWrites)),
+		isInitial:        isInitial,
+		flock:            fileLock,
+		keyCommitSeqNos:  make(map[string]uint64),
+		watchLock:        new(sync.Mutex),
+		watchers:         make(map[*watcher]struct{}),
+		namespaces:       make(map[string]*Namespace),
+		namespaceIds:     make(map[uint32]*Namespace),
+		nextNamespaceId:  defaultNamespaceId + 1,
+		secondaryIndexes: make(map[string]*secondaryIndex),
+		blobFiles:        make(map[uint32]*data.DataFile),
+		blobRefs:         make(map[recordPosition]*data.BlobPos),
+		blobReclaimSize:  make(map[uint32]int64),
+		blobPins:         make(map[uint32]int),
+		keys:             keyProviderOf(options),
+		recovery:         RecoveryReport{Policy: options.Recovery},
+	}
+	if db.versionRetentionEnabled() {
+		db.histories = make(map[string]*keyHistory)
+	}
+
+	if err := db.load(ctx); err != nil {
+		db.abortOpen()
+		return nil, err
 	}
+	return db, nil
+}
 
+// load merge files, data files and index when db opens
+func (db *DB) load(ctx context.Context) error {
 	// load merge files
 	// if merge-finished-file exists, replace related old data files with merged ones
-	if err := db.loadMergeFiles(); err != nil {
-		return nil, err
+	// merge files are not loaded while read-only db is using the data files
+	if !db.options.ReadOnly {
+		if err := db.withoutReaders(db.loadMergeFiles); err != nil {
+			return err
+		}
+	}
+
+	// temp files of PutStream are useless after a crash
+	if !db.options.ReadOnly {
+		if err := db.removeStreamSpools(); err != nil {
+			return err
+		}
+	}
+
+	// files of other dbs are refused
+	if err := db.loadDBId(); err != nil {
+		return err
 	}
 
 	// load data files
 	if err := db.LoadDataFiles(); err != nil {
-		return nil, err
+		return err
+	}
+
+	// large values are in blob files
+	if err := db.loadBlobFiles(); err != nil {
+		return err
+	}
+
+	// load namespaces, their indexes are loaded together with the default one
+	if err := db.loadNamespaces(); err != nil {
+		return err
+	}
+
+	// load seqNos saved by merge, for ChangesSince
+	if err := db.loadMergedSeqNos(); err != nil {
+		return err
 	}
 
 	// B+ Tree in the disk, do not need to load index from data files
-	if options.IndexType != BPtree {
+	if db.options.IndexType != BPtree {
 		// load index from hint file
-		if err := db.loadIndexFromHintFile(); err != nil {
-			return nil, err
+		if err := db.loadIndexFromHintFile(ctx); err != nil {
+			return err
 		}
 
 		// load index of the datafiles
-		if err := db.LoadIndexFromDataFiles(); err != nil {
-			return nil, err
+		if err := db.loadIndexFromDataFiles(ctx); err != nil {
+			return err
 		}
+		db.loadBlobReclaimSize()
 
 		// reset ioType from mmap to standard-fio
 		if db.options.MMapAtStartUp {
 			if err := db.resetIOType(); err != nil {
-				return nil, err
+				return err
 			}
 		}
 
 	} else { // B+ Tree
 		if err := db.loadSeqNo(); err != nil {
-			return nil, err
+			return err
 		}
 
 		if db.activeFile != nil {
 			size, err := db.activeFile.IOManager.Size()
 			if err != nil {
-				return nil, err
+				return err
 			}
 			db.activeFile.WriteOff = size
 		}
+
+		if err := db.loadCommitSeqNoFromLastFile(); err != nil {
+			return err
+		}
 	}
 
-	return db, nil
+	// build secondary indexes from the valid records
+	for name, extract := range db.options.SecondaryIndexes {
+		if err := db.registerIndexLocked(name, extract); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// release the resources of db which fails to open
+func (db *DB) abortOpen() {
+	_ = db.index.Close()
+	for _, ns := range db.namespaces {
+		_ = ns.index.Close()
+	}
+	if db.activeFile != nil {
+		_ = db.closeDataFiles()
+	}
+	_ = db.closeBlobFiles()
+	_ = db.flock.Unlock()
+	_ = db.flock.Close()
 }
 
 // load index from data files, iterate the records in files, and update memory index
 func (db *DB) LoadIndexFromDataFiles() error {
+	return db.loadIndexFromDataFiles(context.Background())
+}
+
+// check ctx between records
+func (db *DB) loadIndexFromDataFiles(ctx context.Context) error {
+	// cache for transaction record
+	db.unfinishedTxns = make(map[uint64][]*data.TransactionRecord)
+
 	// if database is empty
 	if len(db.fileIds) == 0 {
 		return nil
@@ -158,23 +286,6 @@ func (db *DB) LoadIndexFromDataFiles() error {
 		nonMergeFileId = nonMergeFid
 	}
 
-	updateIndex := func(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
-		var oldPos *data.LogRecordPos
-		if typ == data.LogRecordDeleted {
-			oldPos, _ = db.index.Delete(key)
-			db.reclaimSize += int64(oldPos.Size)
-		} else {
-			oldPos = db.index.Put(key, pos)
-		}
-		if oldPos != nil {
-			db.reclaimSize += int64(oldPos.Size)
-		}
-	}
-
-	// cache for transaction record
-	transactionRecords := make(map[uint64][]*data.TransactionRecord)
-	var currentSeqNo = noTransactionSeqNo
-
 	for i, fid := range db.fileIds {
 		// get data file
 		var fileId = uint32(fid)
@@ -192,86 +303,161 @@ func (db *DB) LoadIndexFromDataFiles() error {
 			dataFile = db.olderFiles[fileId]
 		}
 
-		// get contents of current data file
-		var offset int64 = 0
-		for {
-			logRecord, size, err := dataFile.ReadLogRecord(offset)
-			if err != nil {
-				// situation 1 : finish read
-				if err == io.EOF {
-					break
-				}
-				// others
-				return err
+		offset, err := db.recoverDataFile(ctx, dataFile, i == len(db.fileIds)-1)
+		if err != nil {
+			return err
+		}
+
+		// if current datafile is active file, update write offset
+		if i == len(db.fileIds)-1 {
+			db.activeFile.WriteOff = offset
+		}
+	}
+
+	// read-only db keeps the unfinished batches for Refresh, as the writer may be writing them
+	if !db.options.ReadOnly {
+		db.unfinishedTxns = nil
+	}
+	return nil
+}
+
+// load the records of data file from offset into index, return the offset where loading stops
+func (db *DB) loadIndexFromDataFile(ctx context.Context, dataFile *data.DataFile, offset int64) (int64, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return offset, err
+		}
+
+		logRecord, size, err := dataFile.ReadLogRecord(offset)
+		if err != nil {
+			// situation 1 : finish read
+			if err == io.EOF {
+				return offset, nil
 			}
+			// others
+			return offset, err
+		}
 
-			//  construct memory index and save
-			logRecordPos := &data.LogRecordPos{Fid: fileId, Offset: offset, Size: uint32(size)}
+		//  construct memory index and save
+		logRecordPos := &data.LogRecordPos{Fid: dataFile.FileId, Offset: offset, Size: uint32(size), Expire: logRecord.Expire}
 
-			// parse log key, that includes seqNo and real key
-			realKey, seqNo := parseLogRecordKey(logRecord.Key)
-			if seqNo == noTransactionSeqNo { // not transaction
-				updateIndex(realKey, logRecord.Type, logRecordPos)
-			} else {
-				// using write batch
-				if logRecord.Type == data.LogRecordTxnFinish {
-					// if transaction finish perfectly, update index
-					for _, tranRecord := range transactionRecords[seqNo] {
-						updateIndex(tranRecord.Record.Key, tranRecord.Record.Type, tranRecord.Pos)
+		// the newest commit seqNo
+		if logRecord.SeqNo > db.commitSeqNo {
+			db.commitSeqNo = logRecord.SeqNo
+		}
+
+		// parse log key, that includes seqNo and real key
+		realKey, seqNo := parseLogRecordKey(logRecord.Key)
+		if seqNo == noTransactionSeqNo && logRecord.Type == data.LogRecordRangeDeleted {
+			// range tombstone, delete keys written before it
+			for _, key := range db.deleteIndexRange(realKey, logRecord.Value) {
+				db.addVersion(key, logRecord.SeqNo, logRecord.Timestamp, logRecordPos, true)
+			}
+			db.reclaimSize += size
+		} else if seqNo == noTransactionSeqNo { // not transaction
+			if err := db.loadLogRecordIntoIndex(logRecord, realKey, logRecordPos); err != nil {
+				return offset, err
+			}
+		} else {
+			// using write batch
+			if logRecord.Type == data.LogRecordTxnFinish {
+				// if transaction finish perfectly, update index
+				for _, tranRecord := range db.unfinishedTxns[seqNo] {
+					if err := db.loadLogRecordIntoIndex(tranRecord.Record, tranRecord.Record.Key, tranRecord.Pos); err != nil {
+						return offset, err
 					}
-					delete(transactionRecords, seqNo)
-				} else {
-					// add log record to transactionRecords[seqNo]
-					logRecord.Key = realKey
-					transactionRecords[seqNo] = append(transactionRecords[seqNo], &data.TransactionRecord{
-						Record: logRecord,
-						Pos:    logRecordPos,
-					})
 				}
+				delete(db.unfinishedTxns, seqNo)
+			} else {
+				// add log record to unfinishedTxns[seqNo]
+				logRecord.Key = realKey
+				db.unfinishedTxns[seqNo] = append(db.unfinishedTxns[seqNo], &data.TransactionRecord{
+					Record: logRecord,
+					Pos:    logRecordPos,
+				})
 			}
+		}
 
-			// update seqNo
-			if seqNo > currentSeqNo {
-				currentSeqNo = seqNo
-			}
-
-			offset += size
+		// update seqNo
+		if seqNo > db.seqNo {
+			db.seqNo = seqNo
 		}
 
-		// if current datafile is active file, update write offset
-		if i == len(db.fileIds)-1 {
-			db.activeFile.WriteOff = offset
+		offset += size
+	}
+}
+
+// update index with a committed record when loading
+func (db *DB) loadLogRecordIntoIndex(lr *data.LogRecord, key []byte, pos *data.LogRecordPos) error {
+	typ := lr.Type
+	if lr.Namespace != defaultNamespaceId {
+		// records of dropped namespace are invalid
+		if ns, ok := db.namespaceIds[lr.Namespace]; ok {
+			ns.updateIndex(key, typ, pos)
+			if isBlobRecord(typ) && !pos.IsExpired() {
+				db.addBlobRef(pos, lr.Value)
+			}
+		} else {
+			db.reclaimSize += int64(pos.Size)
 		}
+		return nil
 	}
 
-	// update db.seqNo
-	db.seqNo = currentSeqNo
+	var oldPos *data.LogRecordPos
+	// expired record is the same as deleted one, both of them are invalid
+	if typ == data.LogRecordDeleted || pos.IsExpired() {
+		oldPos, _ = db.index.Delete(key)
+		db.reclaimSize += int64(pos.Size)
+	} else if typ == data.LogRecordMerge {
+		// merge operand applies to the previous value, which is still valid
+		pos.Prev = db.mergeOperandPrev(key)
+		db.index.Put(key, pos)
+	} else {
+		oldPos = db.index.Put(key, pos)
+		if isBlobRecord(typ) {
+			db.addBlobRef(pos, lr.Value)
+		}
+	}
+	if oldPos != nil {
+		db.reclaimSize += oldPos.TotalSize()
+		db.releaseBlobs(oldPos)
+	}
+	if typ == data.LogRecordBlobMoved {
+		// value of the current version is moved by CompactBlobs
+		db.moveVersion(key, pos)
+	} else {
+		db.addVersion(key, lr.SeqNo, lr.Timestamp, pos, typ == data.LogRecordDeleted)
+	}
 
+	// secondary indexes are registered when refreshing a read-only db
+	if len(db.secondaryIndexes) > 0 {
+		if typ == data.LogRecordDeleted || pos.IsExpired() {
+			db.updateSecondaryIndexes(key, nil, nil)
+			return nil
+		}
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return err
+		}
+		db.updateSecondaryIndexes(key, value, pos)
+	}
 	return nil
 }
 
 func (db *DB) LoadDataFiles() error {
-	dirEntries, err := os.ReadDir(db.options.DirPath)
+	fileIds, err := db.dataFileIds()
 	if err != nil {
-		return nil
+		return err
 	}
-
-	var fileIds []int
-	//specify that the data file end with .data
-	for _, entry := range dirEntries {
-		if strings.HasSuffix(entry.Name(), data.DataFileNameSuffix) { // if find the file ends with .data
-			// get the file id by split filename  eg. 000001.data
-			splitNames := strings.Split(entry.Name(), ".")
-			fileId, err := strconv.Atoi(splitNames[0])
-			if err != nil {
-				return ErrDataDirCorrupted
-			}
-			fileIds = append(fileIds, fileId)
+	if len(fileIds) > 0 {
+		isNew, err := db.isNewFile(data.GetDataFileName(db.options.DirPath, uint32(fileIds[len(fileIds)-1])))
+		if err != nil {
+			return err
+		}
+		if isNew {
+			fileIds = fileIds[:len(fileIds)-1]
 		}
 	}
-
-	// to load files from small id to large, we need sort
-	sort.Ints(fileIds)
 	db.fileIds = fileIds
 
 	// iterate the file id, and open them
@@ -280,9 +466,9 @@ func (db *DB) LoadDataFiles() error {
 		if db.options.MMapAtStartUp {
 			ioType = fio.MemoryMap
 		}
-		datafile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType)
+		datafile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), ioType, db.fileOptions)
 		if err != nil {
-			return err
+			return fileHeaderError(err, data.GetDataFileName(db.options.DirPath, uint32(fid)))
 		}
 
 		// file with the largest id is the active file, others are older files
@@ -296,6 +482,36 @@ func (db *DB) LoadDataFiles() error {
 	return nil
 }
 
+// ids of data files in the directory, from small to large
+func (db *DB) dataFileIds() ([]int, error) {
+	dirEntries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return nil, nil
+	}
+	return fileIdsOf(dirEntries, data.DataFileNameSuffix)
+}
+
+// ids of the files with suffix in dir entries, from small to large
+func fileIdsOf(dirEntries []os.DirEntry, suffix string) ([]int, error) {
+	var fileIds []int
+	//specify that the data file end with .data
+	for _, entry := range dirEntries {
+		if strings.HasSuffix(entry.Name(), suffix) { // if find the file ends with .data
+			// get the file id by split filename  eg. 000001.data
+			splitNames := strings.Split(entry.Name(), ".")
+			fileId, err := strconv.Atoi(splitNames[0])
+			if err != nil {
+				return nil, ErrDataDirCorrupted
+			}
+			fileIds = append(fileIds, fileId)
+		}
+	}
+
+	// to load files from small id to large, we need sort
+	sort.Ints(fileIds)
+	return fileIds, nil
+}
+
 // check input options
 func CheckOptions(options Options) error {
 	if options.DirPath == "" {
@@ -310,43 +526,141 @@ func CheckOptions(options Options) error {
 		return ErrInvalidMergeRatio
 	}
 
+	if options.MergeOperator != "" && getMergeOperator(options.MergeOperator) == nil {
+		return ErrMergeOperatorNotFound
+	}
+
+	// versions are loaded from data files, but B+ Tree is not
+	if options.IndexType == BPtree && (options.VersionRetention > 0 || options.VersionRetentionDuration > 0) {
+		return ErrVersionRetentionUnsupported
+	}
+
+	// B+ Tree index file is locked and written by the writer
+	if options.IndexType == BPtree && options.ReadOnly {
+		return ErrReadOnlyUnsupported
+	}
+
+	if options.BlobFileGCRatio < 0 || options.BlobFileGCRatio > 1 {
+		return ErrInvalidBlobGCRatio
+	}
+
+	if options.Compression != NoCompression && data.GetCodec(options.Compression) == nil {
+		return ErrInvalidCompression
+	}
+
+	if options.Recovery < RecoveryTruncate || options.Recovery > RecoverySkip {
+		return ErrInvalidRecoveryPolicy
+	}
+
+	// valid blobs are known when loading index from data files, but B+ Tree is not loaded
+	if options.IndexType == BPtree && options.ValueThreshold > 0 {
+		return ErrBlobUnsupported
+	}
+
+	if len(options.EncryptionKey) > 0 {
+		if options.KeyProvider != nil {
+			return ErrInvalidEncryptionKey
+		}
+		if n := len(options.EncryptionKey); n != 16 && n != 24 && n != 32 {
+			return ErrInvalidEncryptionKey
+		}
+	}
+
+	// B+ Tree index file keeps keys in plain text
+	if options.IndexType == BPtree && keyProviderOf(options) != nil {
+		return ErrEncryptionUnsupported
+	}
+
 	return nil
 }
 
 // storage engine instance_put
 func (db *DB) Put(key []byte, value []byte) error {
+	return db.PutWithTTL(key, value, 0)
+}
+
+// put kv which will expire after ttl, ttl = 0 means never expire
+func (db *DB) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
 
-	//if the k&v is valid, than create LogRecord
-	log_record := &data.LogRecord{
-		Key:   logRecordKeyWithSeq(key, noTransactionSeqNo),
-		Value: value,
-		Type:  data.LogRecordNormal,
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
 	}
 
-	// append log to current active data file
-	pos, err := db.AppendLogRecordWithLock(log_record)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+	return db.writeLocked(key, value, data.LogRecordNormal, expire)
+}
+
+// append a normal or deleted log record, update index and notify watchers, under db.mu
+func (db *DB) writeLocked(key []byte, value []byte, typ data.LogRecordType, expire int64) error {
+	logRecord := &data.LogRecord{
+		Key:    logRecordKeyWithSeq(key, noTransactionSeqNo),
+		Value:  value,
+		Type:   typ,
+		Expire: expire,
+		SeqNo:  db.commitSeqNo + 1,
+	}
+	pos, err := db.AppendLogRecord(logRecord)
 	if err != nil {
 		return err
 	}
+	db.applyWriteLocked(key, value, logRecord, pos)
+	return nil
+}
 
-	if oldPos := db.index.Put(key, pos); oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+// update index, versions and watchers with the appended record of key, under db.mu
+func (db *DB) applyWriteLocked(key []byte, value []byte, logRecord *data.LogRecord, pos *data.LogRecordPos) {
+	var oldPos *data.LogRecordPos
+	eventType := EventPut
+	deleted := logRecord.Type == data.LogRecordDeleted
+	if deleted {
+		// the deleted record itself is invalid
+		db.reclaimSize += int64(pos.Size)
+		oldPos, _ = db.index.Delete(key)
+		eventType = EventDelete
+		db.updateSecondaryIndexes(key, nil, nil)
+	} else {
+		oldPos = db.index.Put(key, pos)
+		db.updateSecondaryIndexes(key, value, pos)
+	}
+	if oldPos != nil {
+		db.reclaimSize += oldPos.TotalSize()
+		db.releaseBlobs(oldPos)
 	}
 
-	return nil
+	db.addVersion(key, logRecord.SeqNo, logRecord.Timestamp, pos, deleted)
+	db.markSingleWriteCommitted(key)
+	db.commitSeqNo = logRecord.SeqNo
+	db.notifyWatchers(eventType, key, value, db.commitSeqNo)
 }
 
 func (db *DB) AppendLogRecordWithLock(log_record *data.LogRecord) (*data.LogRecordPos, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
-
 	return db.AppendLogRecord(log_record)
 }
 
 func (db *DB) AppendLogRecord(log_record *data.LogRecord) (*data.LogRecordPos, error) {
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	if db.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
 	//judge whether active data file exists
 	// if nil, than initialize the active file
 	if db.activeFile == nil {
@@ -356,13 +670,60 @@ func (db *DB) AppendLogRecord(log_record *data.LogRecord) (*data.LogRecordPos, e
 		}
 	}
 
+	// records rewritten by merge keep their timestamps
+	if log_record.Timestamp == 0 {
+		log_record.Timestamp = time.Now().UnixNano()
+	}
+
+	// large value is written to blob file first, and the record keeps the pos of it
+	var blobPos *data.BlobPos
+	if log_record.Type == data.LogRecordNormal && db.options.ValueThreshold > 0 && int64(len(log_record.Value)) > db.options.ValueThreshold {
+		realKey, _ := parseLogRecordKey(log_record.Key)
+		var err error
+		blobPos, err = db.appendBlob(&data.LogRecord{
+			Key:       realKey,
+			Value:     log_record.Value,
+			SeqNo:     log_record.SeqNo,
+			Namespace: log_record.Namespace,
+			Timestamp: log_record.Timestamp,
+		})
+		if err != nil {
+			return nil, err
+		}
+		log_record.Value = data.EncodeBlobPos(blobPos)
+		log_record.Type = data.LogRecordBlob
+	}
+	log_record.Codec = db.codecOf(log_record.Value)
 	encRecord, size := data.EncodeLogRecord(log_record)
+	writeOff, size, err := db.writeActiveFile(size, func(dataFile *data.DataFile) error {
+		return dataFile.Write(encRecord)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size), Expire: log_record.Expire}
+	if blobPos != nil {
+		db.blobRefs[recordPosition{fid: pos.Fid, offset: pos.Offset}] = blobPos
+	}
+	return pos, nil
+}
+
+// codec to compress value with, see Options.Compression
+func (db *DB) codecOf(value []byte) data.CodecId {
+	if int64(len(value)) > db.options.CompressionThreshold {
+		return db.options.Compression
+	}
+	return data.CodecNone
+}
 
-	// if size is up to limit, change the file state
-	if db.activeFile.WriteOff+size > db.options.DataFileSize {
+// write a record of size to the active data file with write, return the offset of it and its size on disk, under lock
+func (db *DB) writeActiveFile(size int64, write func(dataFile *data.DataFile) error) (int64, int64, error) {
+	// if size is up to limit, or the file can not be appended, change the file state
+	if db.activeFile.WriteOff+size > db.options.DataFileSize || !db.canAppend(db.activeFile) {
 		//persist current data file to disk
 		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
+			return 0, 0, err
 		}
 
 		//active -> order files
@@ -370,14 +731,16 @@ func (db *DB) AppendLogRecord(log_record *data.LogRecord) (*data.LogRecordPos, e
 
 		// set new active data file
 		if err := db.SetActiveDataFile(); err != nil {
-			return nil, err
+			return 0, 0, err
 		}
 	}
 
 	writeOff := db.activeFile.WriteOff
-	if err := db.activeFile.Write(encRecord); err != nil {
-		return nil, err
+	if err := write(db.activeFile); err != nil {
+		return 0, 0, err
 	}
+	// encrypted record is bigger than the encoded one
+	size = db.activeFile.WriteOff - writeOff
 
 	db.bytesWrite += uint(size)
 
@@ -387,14 +750,18 @@ func (db *DB) AppendLogRecord(log_record *data.LogRecord) (*data.LogRecordPos, e
 	}
 
 	if needSync {
+		// blobs are persisted before the records pointing to them
+		if db.activeBlobFile != nil {
+			if err := db.activeBlobFile.Sync(); err != nil {
+				return 0, 0, err
+			}
+		}
 		if err := db.activeFile.Sync(); err != nil {
-			return nil, err
+			return 0, 0, err
 		}
 		db.bytesWrite = 0 //clear bytesWrite
 	}
-
-	pos := &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size)}
-	return pos, nil
+	return writeOff, size, nil
 }
 
 // under lock
@@ -405,7 +772,7 @@ func (db *DB) SetActiveDataFile() error {
 	}
 
 	// open new data file
-	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO)
+	dataFile, err := data.OpenDataFile(db.options.DirPath, initialFileId, fio.StandardFIO, db.fileOptions)
 
 	if err != nil {
 		return err
@@ -419,6 +786,10 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
 	if len(key) == 0 {
 		return nil, ErrKeyIsEmpty
 	}
@@ -426,57 +797,137 @@ func (db *DB) Get(key []byte) ([]byte, error) {
 	// get the pos value corresponding to key from memory
 	logRecordPos := db.index.Get(key)
 
-	// key not found
-	if logRecordPos == nil {
+	// key not found or expired
+	if logRecordPos == nil || logRecordPos.IsExpired() {
 		return nil, ErrKeyNotFound
 	}
 
 	return db.getValueByPosition(logRecordPos)
 }
 
+// get values of keys with one lock acquisition, values[i] and errs[i] belong to keys[i]
+// records are read in the order of (Fid, Offset), and different data files are read in parallel
+func (db *DB) MultiGet(keys [][]byte) ([][]byte, []error) {
+	values := make([][]byte, len(keys))
+	errs := make([]error, len(keys))
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		for i := range errs {
+			errs[i] = ErrDBClosed
+		}
+		return values, errs
+	}
+
+	// get pos of all keys from memory in one pass
+	type keyPos struct {
+		idx int
+		pos *data.LogRecordPos
+	}
+	keyPoses := make([]keyPos, 0, len(keys))
+	for i, key := range keys {
+		if len(key) == 0 {
+			errs[i] = ErrKeyIsEmpty
+			continue
+		}
+		pos := db.index.Get(key)
+		if pos == nil || pos.IsExpired() {
+			errs[i] = ErrKeyNotFound
+			continue
+		}
+		keyPoses = append(keyPoses, keyPos{idx: i, pos: pos})
+	}
+
+	// sort by file and offset, for sequential io
+	sort.Slice(keyPoses, func(i, j int) bool {
+		if keyPoses[i].pos.Fid != keyPoses[j].pos.Fid {
+			return keyPoses[i].pos.Fid < keyPoses[j].pos.Fid
+		}
+		return keyPoses[i].pos.Offset < keyPoses[j].pos.Offset
+	})
+
+	// one goroutine per data file
+	var wg sync.WaitGroup
+	for start := 0; start < len(keyPoses); {
+		end := start + 1
+		for end < len(keyPoses) && keyPoses[end].pos.Fid == keyPoses[start].pos.Fid {
+			end++
+		}
+		wg.Add(1)
+		go func(group []keyPos) {
+			defer wg.Done()
+			for _, kp := range group {
+				values[kp.idx], errs[kp.idx] = db.getValueByPosition(kp.pos)
+			}
+		}(keyPoses[start:end])
+		start = end
+	}
+	wg.Wait()
+
+	return values, errs
+}
+
 func (db *DB) Delete(key []byte) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
 	if len(key) == 0 {
 		return ErrKeyIsEmpty
 	}
 
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
 	// whether the key exists
 	if pos := db.index.Get(key); pos == nil {
 		return nil
 	}
 
-	// if exists, create a delete record
-	logRecord := &data.LogRecord{
-		Key:  logRecordKeyWithSeq(key, noTransactionSeqNo),
-		Type: data.LogRecordDeleted,
-	}
-	// append to log record
-	pos, err := db.AppendLogRecordWithLock(logRecord)
+	// if exists, append a delete record and delete the key from memory index
+	return db.writeLocked(key, nil, data.LogRecordDeleted, 0)
+}
+
+// according to the logrecordPos, get the related value
+func (db *DB) getValueByPosition(lrp *data.LogRecordPos) ([]byte, error) {
+	logRecord, err := db.readLogRecord(lrp)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	db.reclaimSize += int64(pos.Size)
 
-	// delete the key from memory index
-	oldPos, ok := db.index.Delete(key)
-	if !ok {
-		return ErrUpdateIndexFailed
+	// log record already been deleted
+	if logRecord.Type == data.LogRecordDeleted {
+		return nil, ErrKeyNotFound
 	}
-	if oldPos != nil {
-		db.reclaimSize += int64(oldPos.Size)
+
+	// merge operand, apply it to the previous value
+	if logRecord.Type == data.LogRecordMerge {
+		return db.resolveMergeOperand(logRecord, lrp.Prev)
 	}
-	return nil
+
+	// large value is in blob file
+	if isBlobRecord(logRecord.Type) {
+		return db.readBlob(logRecord.Value)
+	}
+
+	return logRecord.Value, nil
 }
 
-// according to the logrecordPos, get the related value
-func (db *DB) getValueByPosition(lrp *data.LogRecordPos) ([]byte, error) {
-	// get the datafile according to the file id
-	var dataFile *data.DataFile
-	if db.activeFile.FileId == lrp.Fid {
-		dataFile = db.activeFile
-	} else {
-		dataFile = db.olderFiles[lrp.Fid]
+// read the log record at lrp from data files
+func (db *DB) readLogRecord(lrp *data.LogRecordPos) (*data.LogRecord, error) {
+	// data files are closed
+	if db.closed {
+		return nil, ErrDBClosed
 	}
 
+	// get the datafile according to the file id
+	dataFile := db.dataFileOf(lrp.Fid)
+
 	// datafile not found
 	if dataFile == nil {
 		return nil, ErrDataFileNotFound
@@ -484,44 +935,79 @@ func (db *DB) getValueByPosition(lrp *data.LogRecordPos) ([]byte, error) {
 
 	// error when reading log record
 	logRecord, _, err := dataFile.ReadLogRecord(lrp.Offset)
-	if err != nil {
-		return nil, err
-	}
+	return logRecord, err
+}
 
-	// log record already been deleted
-	if logRecord.Type == data.LogRecordDeleted {
-		return nil, ErrKeyNotFound
+// get the data file of fid, nil if not found
+func (db *DB) dataFileOf(fid uint32) *data.DataFile {
+	if db.activeFile != nil && db.activeFile.FileId == fid {
+		return db.activeFile
 	}
-
-	return logRecord.Value, nil
+	return db.olderFiles[fid]
 }
 
 // get all keys in index
 func (db *DB) ListKeys() [][]byte {
+	keys, _ := db.ListKeysContext(context.Background())
+	return keys
+}
+
+// get all keys, stop when ctx is done
+func (db *DB) ListKeysContext(ctx context.Context) ([][]byte, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	it := db.index.Iterator(false)
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	return listKeys(ctx, db.index)
+}
+
+func listKeys(ctx context.Context, idx index.Indexer) ([][]byte, error) {
+	it := idx.Iterator(false)
 	defer it.Close()
 
-	keys := make([][]byte, db.index.Size())
-	var idx int
+	keys := make([][]byte, 0, idx.Size())
 	for it.Rewind(); it.Valid(); it.Next() {
-		keys[idx] = it.Key()
-		idx++
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if it.Value().IsExpired() {
+			continue
+		}
+		keys = append(keys, it.Key())
 	}
-	return keys
+	return keys, nil
 }
 
 // get all kv and perform user's specified actions(by param-fn)
 func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
+	return db.FoldContext(context.Background(), fn)
+}
+
+// fold kvs until fn returns false or ctx is done
+func (db *DB) FoldContext(ctx context.Context, fn func(key []byte, value []byte) bool) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	it := db.index.Iterator(false)
+	if db.closed {
+		return ErrDBClosed
+	}
+	return db.fold(ctx, db.index, fn)
+}
+
+// under lock
+func (db *DB) fold(ctx context.Context, idx index.Indexer, fn func(key []byte, value []byte) bool) error {
+	it := idx.Iterator(false)
 	defer it.Close()
 
 	for it.Rewind(); it.Valid(); it.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if it.Value().IsExpired() {
+			continue
+		}
 		val, err := db.getValueByPosition(it.Value())
 		if err != nil {
 			return err
@@ -533,31 +1019,70 @@ func (db *DB) Fold(fn func(key []byte, value []byte) bool) error {
 	return nil
 }
 
-// close database
+func (db *DB) isClosed() bool {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.closed
+}
+
+// close database, it waits for running iterators and Compact, and can be called repeatedly
 func (db *DB) Close() error {
-	// unlock flock
-	defer func() {
-		if err := db.flock.Unlock(); err != nil {
-			panic(fmt.Sprintf("failed to unlock the directory, %v", err))
-		}
-		if err := db.flock.Close(); err != nil {
-			panic("failed to close flock")
-		}
-	}()
+	db.mu.Lock()
+	if db.closed {
+		db.mu.Unlock()
+		return nil
+	}
+	db.closed = true
+	db.mu.Unlock()
+
+	// no iterator or Compact starts after closed is set, wait for the running ones
+	db.inflight.Wait()
+
+	db.closeWatchers()
+
+	err := db.closeFiles()
+	// unlock flock at last, even if closing files failed
+	if unlockErr := db.flock.Unlock(); unlockErr != nil && err == nil {
+		err = fmt.Errorf("failed to unlock the directory, %w", unlockErr)
+	}
+	if closeErr := db.flock.Close(); closeErr != nil && err == nil {
+		err = fmt.Errorf("failed to close flock, %w", closeErr)
+	}
+	return err
+}
+
+// close indexes and data files, and save seqNo
+func (db *DB) closeFiles() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
 
 	// close index (B+ tree, as we capsulates a db instance)
 	if err := db.index.Close(); err != nil {
 		return err
 	}
+	for _, ns := range db.namespaces {
+		if err := ns.index.Close(); err != nil {
+			return err
+		}
+	}
+
+	if err := db.closeBlobFiles(); err != nil {
+		return err
+	}
 
 	if db.activeFile == nil {
 		return nil
 	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
 
-	// save seqNo
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	if db.options.ReadOnly {
+		return db.closeDataFiles()
+	}
+
+	// save seqNo, the file only keeps the last one
+	if err := os.Remove(filepath.Join(db.options.DirPath, data.SeqNoFileName)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath, db.fileOptions)
 	if err != nil {
 		return err
 	}
@@ -580,6 +1105,10 @@ func (db *DB) Close() error {
 		return err
 	}
 
+	return db.closeDataFiles()
+}
+
+func (db *DB) closeDataFiles() error {
 	// close active file
 	if err := db.activeFile.Close(); err != nil {
 		return err
@@ -595,22 +1124,70 @@ func (db *DB) Close() error {
 
 // sync
 func (db *DB) Sync() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
 	if db.activeFile == nil {
 		return nil
 	}
-	db.mu.Lock()
-	defer db.mu.Unlock()
 
 	return db.activeFile.Sync()
 }
 
+// load the id of db, the writer creates it if the db is new or written by old versions
+func (db *DB) loadDBId() error {
+	// read-only db never creates or writes files, so it works on a read-only file system
+	db.fileOptions = &data.FileOptions{Keys: db.keys, ReadOnly: db.options.ReadOnly}
+	content, err := os.ReadFile(filepath.Join(db.options.DirPath, data.DBIdFileName))
+	if err == nil {
+		db.fileOptions.DBId, err = data.ParseUUID(strings.TrimSpace(string(content)))
+		return err
+	}
+	if !os.IsNotExist(err) {
+		return err
+	}
+	// read-only db does not check the files until the writer creates the id
+	if db.options.ReadOnly {
+		return nil
+	}
+	id, err := data.NewUUID()
+	if err != nil {
+		return err
+	}
+	if err := writeDBId(db.options.DirPath, id); err != nil {
+		return err
+	}
+	db.fileOptions.DBId = id
+	return nil
+}
+
+// write id of db to dir, it is renamed from a temp file, so it is never partially written
+func writeDBId(dir string, id data.UUID) error {
+	tmpName := filepath.Join(dir, data.DBIdFileName+".tmp")
+	if err := os.WriteFile(tmpName, []byte(id.String()), fio.DataFilePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(dir, data.DBIdFileName))
+}
+
+// error of opening the file, with its name if its header is refused
+func fileHeaderError(err error, fileName string) error {
+	if errors.Is(err, data.ErrForeignFile) || errors.Is(err, data.ErrNewerFileVersion) || errors.Is(err, data.ErrInvalidFileHeader) {
+		return fmt.Errorf("%w, file: %s", err, fileName)
+	}
+	return err
+}
+
 func (db *DB) loadSeqNo() error {
 	fileName := filepath.Join(db.options.DirPath, data.SeqNoFileName)
 	if _, err := os.Stat(fileName); os.IsNotExist(err) {
 		return nil
 	}
 
-	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath)
+	seqNoFile, err := data.OpenSeqNoFile(db.options.DirPath, db.fileOptions)
 	if err != nil {
 		return err
 	}
@@ -655,6 +1232,18 @@ func (db *DB) Stat() *Stat {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
+	if db.closed {
+		return &Stat{}
+	}
+
+	// expired keys are garbage as well, they are counted here and removed by Compact
+	expiredSize, expiredNum := db.index.expired()
+	reclaimSize := db.reclaimSize + expiredSize
+	for _, ns := range db.namespaces {
+		size, _ := ns.index.expired()
+		reclaimSize += size
+	}
+
 	var dataFileNum = uint(len(db.olderFiles))
 	if db.activeFile != nil {
 		dataFileNum += 1
@@ -665,17 +1254,53 @@ func (db *DB) Stat() *Stat {
 		panic(fmt.Sprintf("failed to get dir size, error: %v", err))
 	}
 
+	var blobReclaimSize int64
+	for _, size := range db.blobReclaimSize {
+		blobReclaimSize += size
+	}
+
 	return &Stat{
-		KeyNum:      uint(db.index.Size()),
-		DataFileNum: dataFileNum,
-		ReclaimSize: db.reclaimSize,
-		DiskSize:    diskSize,
+		KeyNum:          uint(db.index.Size() - expiredNum),
+		DataFileNum:     dataFileNum,
+		ReclaimSize:     reclaimSize,
+		DiskSize:        diskSize,
+		BlobFileNum:     uint(len(db.blobFiles)),
+		BlobReclaimSize: blobReclaimSize,
 	}
 }
 
+// remove expired keys from indexes and count them as invalid records, under lock
+func (db *DB) removeExpiredKeys() {
+	db.reclaimSize += db.removeExpiredKeysOf(db.index)
+	for _, ns := range db.namespaces {
+		ns.removeExpiredKeys()
+	}
+}
+
+// remove expired keys from idx, return the size of them, under lock
+func (db *DB) removeExpiredKeysOf(idx *expiryIndex) int64 {
+	var size int64
+	for _, key := range idx.expiredKeys() {
+		if oldPos, ok := idx.Delete(key); ok {
+			size += oldPos.TotalSize()
+			db.releaseBlobs(oldPos)
+		}
+	}
+	return size
+}
+
 // back up
 func (db *DB) BackUp(dir string) error {
+	return db.BackUpContext(context.Background(), dir)
+}
+
+// back up db to dir, stop when ctx is done, and the partial backup is removed
+func (db *DB) BackUpContext(ctx context.Context, dir string) error {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-	return utils.CopyDir(db.options.DirPath, dir, []string{fileLockName})
+
+	if db.closed {
+		return ErrDBClosed
+	}
+	return utils.CopyDirContext(ctx, db.options.DirPath, dir, []string{fileLockName, readerLockName})
 }
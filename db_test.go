@@ -1,9 +1,18 @@
 package bitcaskminidb
 
 import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
 	"bitcask-go/utils"
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -87,6 +96,71 @@ func TestDB_Put(t *testing.T) {
 	db2.Close() // under windows, we have to do this
 }
 
+func TestDB_PutWithTTL(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-put-ttl")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: get before expired
+	err = db.PutWithTTL(utils.GetTestKey(1), utils.RandomValue(24), 100*time.Millisecond)
+	assert.Nil(t, err)
+	err = db.PutWithTTL(utils.GetTestKey(2), utils.RandomValue(24), time.Hour)
+	assert.Nil(t, err)
+	err = db.Put(utils.GetTestKey(3), utils.RandomValue(24))
+	assert.Nil(t, err)
+	val1, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val1)
+
+	// case2: negative ttl
+	err = db.PutWithTTL(utils.GetTestKey(4), utils.RandomValue(24), -time.Second)
+	assert.Equal(t, ErrInvalidTTL, err)
+
+	// case3: get after expired
+	time.Sleep(150 * time.Millisecond)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 2, len(db.ListKeys()))
+	it := db.NewIterator(DefaultIteratorOptions)
+	assert.Equal(t, utils.GetTestKey(2), it.Key())
+	it.Close()
+	stat := db.Stat()
+	assert.Equal(t, uint(2), stat.KeyNum)
+	assert.True(t, stat.ReclaimSize > 0)
+	// stat does not remove expired key from index, nor count it twice
+	assert.Equal(t, 3, db.index.Size())
+	assert.Equal(t, stat, db.Stat())
+
+	// case4: put again after expired
+	err = db.Put(utils.GetTestKey(1), []byte("val"))
+	assert.Nil(t, err)
+	val2, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("val"), val2)
+	err = db.PutWithTTL(utils.GetTestKey(3), utils.RandomValue(24), 50*time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	// case5: restart db, expired keys are skipped
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.NotNil(t, db2)
+	_, err = db2.Get(utils.GetTestKey(3))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(db2.ListKeys()))
+	assert.True(t, db2.Stat().ReclaimSize > 0)
+
+	db2.Close() // under windows, we have to do this
+}
+
 func TestDB_Get(t *testing.T) {
 	opt := DefaultOptions
 	// opt.MMapAtStartUp = false
@@ -163,6 +237,58 @@ func TestDB_Get(t *testing.T) {
 	db2.Close() // under windows, we have to do this
 }
 
+func TestDB_MultiGet(t *testing.T) {
+	opt := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-multi-get")
+	opt.DirPath = dir
+	opt.DataFileSize = 16 * 1024
+	db, err := Open(opt)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// write keys into several data files
+	for i := 0; i < 3000; i++ {
+		err = db.Put(utils.GetTestKey(i), []byte(fmt.Sprintf("value-%d", i)))
+		assert.Nil(t, err)
+	}
+	assert.True(t, len(db.olderFiles) > 1)
+	err = db.PutWithTTL([]byte("expired"), []byte("value"), time.Millisecond)
+	assert.Nil(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	// case1: keys in different order and files, missing, empty and expired keys
+	keys := [][]byte{utils.GetTestKey(2999), utils.GetTestKey(0), []byte("unknown"), nil, utils.GetTestKey(1500), []byte("expired"), utils.GetTestKey(0)}
+	values, errs := db.MultiGet(keys)
+	assert.Equal(t, len(keys), len(values))
+	assert.Equal(t, len(keys), len(errs))
+	assert.Equal(t, []byte("value-2999"), values[0])
+	assert.Equal(t, []byte("value-0"), values[1])
+	assert.Equal(t, ErrKeyNotFound, errs[2])
+	assert.Equal(t, ErrKeyIsEmpty, errs[3])
+	assert.Equal(t, []byte("value-1500"), values[4])
+	assert.Equal(t, ErrKeyNotFound, errs[5])
+	assert.Equal(t, []byte("value-0"), values[6])
+	for _, i := range []int{0, 1, 4, 6} {
+		assert.Nil(t, errs[i])
+	}
+
+	// case2: no keys
+	values, errs = db.MultiGet(nil)
+	assert.Equal(t, 0, len(values))
+	assert.Equal(t, 0, len(errs))
+
+	// case3: after restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opt)
+	assert.Nil(t, err)
+	values, errs = db2.MultiGet([][]byte{utils.GetTestKey(10), utils.GetTestKey(20)})
+	assert.Equal(t, [][]byte{[]byte("value-10"), []byte("value-20")}, values)
+	assert.Equal(t, []error{nil, nil}, errs)
+	db2.Close()
+}
+
 func TestDB_Delete(t *testing.T) {
 	opts := DefaultOptions
 	dir, _ := os.MkdirTemp("", "bitcask-go-delete")
@@ -289,6 +415,44 @@ func TestDB_Close(t *testing.T) {
 
 	err = db.Put(utils.GetTestKey(11), utils.RandomValue(20))
 	assert.Nil(t, err)
+
+	// case1: close waits for running iterator
+	it := db.NewIterator(DefaultIteratorOptions)
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("db is closed before iterator")
+	case <-time.After(100 * time.Millisecond):
+	}
+	_, err = it.Value()
+	assert.Equal(t, ErrDBClosed, err)
+	it.Close()
+	it.Close()
+	assert.Nil(t, <-closed)
+
+	// case2: every method returns ErrDBClosed after close
+	assert.Nil(t, db.Close())
+	_, err = db.Get(utils.GetTestKey(11))
+	assert.Equal(t, ErrDBClosed, err)
+	assert.Equal(t, ErrDBClosed, db.Put(utils.GetTestKey(11), utils.RandomValue(20)))
+	assert.Equal(t, ErrDBClosed, db.Delete(utils.GetTestKey(11)))
+	assert.Equal(t, ErrDBClosed, db.Sync())
+	assert.Equal(t, ErrDBClosed, db.Compact())
+	assert.Nil(t, db.ListKeys())
+
+	it = db.NewIterator(DefaultIteratorOptions)
+	assert.False(t, it.Valid())
+	_, err = it.Value()
+	assert.Equal(t, ErrDBClosed, err)
+	it.Close()
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Equal(t, ErrDBClosed, wb.Put(utils.GetTestKey(11), utils.RandomValue(20)))
+	assert.Equal(t, ErrDBClosed, wb.Delete(utils.GetTestKey(11)))
+	assert.Equal(t, ErrDBClosed, wb.Commit())
 }
 
 func TestDB_Sync(t *testing.T) {
@@ -394,3 +558,241 @@ func TestDB_Backup(t *testing.T) {
 // 	assert.Nil(t, err)
 // 	assert.NotNil(t, db)
 // }
+
+func TestDB_OpenBaseline(t *testing.T) {
+	// testdata/baseline is written by the code before the file header, with merge, batch and deletes
+	dir, _ := os.MkdirTemp("", "bitcask-go-baseline")
+	assert.Nil(t, utils.CopyDir("testdata/baseline", dir, nil))
+	opts := DefaultOptions
+	opts.DirPath = dir
+	opts.DataFileSize = 1024
+	opts.DataFileMergeRatio = 0
+
+	check := func(db *DB, keys int) {
+		for i := 0; i < 70; i++ {
+			val, err := db.Get([]byte(fmt.Sprintf("key-%03d", i)))
+			switch {
+			case i < 10 || i == 20 || i == 21:
+				assert.Equal(t, ErrKeyNotFound, err)
+			case i < 20:
+				assert.Equal(t, fmt.Sprintf("new-value-%03d", i), string(val))
+			case i < 60:
+				assert.Equal(t, fmt.Sprintf("value-%03d", i), string(val))
+			default:
+				assert.Equal(t, fmt.Sprintf("batch-value-%03d", i), string(val))
+			}
+		}
+		assert.Equal(t, keys, len(db.ListKeys()))
+	}
+
+	// case1: files without header are read, and nothing is truncated
+	report, err := VerifyDir(dir, nil)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	check(db, 58)
+	assert.Equal(t, 0, len(db.RecoveryReport().Corruptions))
+	assert.Nil(t, db.activeFile.Header())
+
+	// case2: new records are written to a new file with header
+	assert.Nil(t, db.Put([]byte("name"), []byte("bitcask-go")))
+	assert.NotNil(t, db.activeFile.Header())
+	assert.Equal(t, db.fileOptions.DBId, db.activeFile.Header().DBId)
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db, 59)
+	val, err := db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), val)
+
+	// case3: compact rewrites the records with header
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	check(db, 59)
+	assert.Equal(t, 0, len(db.RecoveryReport().Corruptions))
+	for _, df := range db.olderFiles {
+		assert.NotNil(t, df.Header())
+	}
+}
+
+func TestDB_Context(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-context")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// case1: fold and list keys stop when ctx is done
+	var n int
+	err = db.FoldContext(canceled, func(key []byte, value []byte) bool {
+		n++
+		return true
+	})
+	assert.Equal(t, context.Canceled, err)
+	assert.Equal(t, 0, n)
+	_, err = db.ListKeysContext(canceled)
+	assert.Equal(t, context.Canceled, err)
+	keys, err := db.ListKeysContext(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 100, len(keys))
+
+	// case2: partial backup is removed
+	backupDir := filepath.Join(os.TempDir(), "bitcask-go-context-backup")
+	err = db.BackUpContext(canceled, backupDir)
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(backupDir)
+	assert.True(t, os.IsNotExist(err))
+
+	// case3: canceled open releases the directory
+	assert.Nil(t, db.Close())
+	_, err = OpenContext(canceled, opts)
+	assert.Equal(t, context.Canceled, err)
+	db2, err := OpenContext(context.Background(), opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), db2.Stat().KeyNum)
+	assert.Nil(t, db2.Close())
+}
+
+func TestDB_Compression(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-compression")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.Compression = GzipCompression
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := []byte(strings.Repeat(`{"name":"bitcask-go","tags":["kv","log"]}`, 100))
+
+	// case1: values are compressed on disk
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), value))
+	}
+	assert.Nil(t, db.Put([]byte("small"), []byte("value")))
+	assert.True(t, db.Stat().DiskSize < int64(len(value))*10)
+	val, err := db.Get(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	r, err := db.GetReader(utils.GetTestKey(10))
+	assert.Nil(t, err)
+	res, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, value, res)
+	assert.Nil(t, r.Close())
+
+	// case2: another codec reads old values, and merge rewrites them with it
+	assert.Nil(t, db.Close())
+	opts.Compression = ZlibCompression
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db2.Put(utils.GetTestKey(i), value))
+	}
+	for i := 0; i < 200; i++ {
+		val, err := db2.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	assert.Nil(t, db2.Compact())
+	assert.Nil(t, db2.Close())
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 200; i++ {
+		val, err := db3.Get(utils.GetTestKey(i))
+		assert.Nil(t, err)
+		assert.Equal(t, value, val)
+	}
+	val, err = db3.Get([]byte("small"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	lr, err := db3.readLogRecord(db3.index.Get(utils.GetTestKey(0)))
+	assert.Nil(t, err)
+	assert.Equal(t, ZlibCompression, lr.Codec)
+	assert.Nil(t, db3.Close())
+
+	opts.Compression = data.MaxCodecId
+	_, err = Open(opts)
+	assert.Equal(t, ErrInvalidCompression, err)
+}
+
+func TestDB_FileHeader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-file-header")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+
+	// case1: new db gets an id, which is in the header of its files
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	val, err := db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	assert.False(t, db.fileOptions.DBId.IsZero())
+	assert.Equal(t, db.fileOptions.DBId, db.activeFile.Header().DBId)
+	content, err := os.ReadFile(filepath.Join(dir, data.DBIdFileName))
+	assert.Nil(t, err)
+	assert.Equal(t, db.fileOptions.DBId.String(), string(content))
+	id := db.fileOptions.DBId
+	assert.Nil(t, db.Put([]byte("name"), []byte("bitcask-go")))
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Close())
+
+	// case2: id is kept by restart, and merged files have the header
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, id, db.fileOptions.DBId)
+	assert.Equal(t, id, db.activeFile.Header().DBId)
+	for _, df := range db.olderFiles {
+		assert.Equal(t, id, df.Header().DBId)
+	}
+	val, err = db.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.Equal(t, utils.GetTestKey(1), val)
+	assert.Nil(t, db.Close())
+
+	// case3: data file of another db is refused
+	otherDir, _ := os.MkdirTemp("", "bitcask-go-file-header-other")
+	otherOpts := opts
+	otherOpts.DirPath = otherDir
+	other, err := Open(otherOpts)
+	defer destroyDB(other)
+	assert.Nil(t, err)
+	assert.Nil(t, other.Put([]byte("name"), []byte("other")))
+	assert.Nil(t, other.Sync())
+	content, err = os.ReadFile(data.GetDataFileName(otherDir, 0))
+	assert.Nil(t, err)
+	foreignFile := data.GetDataFileName(dir, 100)
+	assert.Nil(t, os.WriteFile(foreignFile, content, fio.DataFilePerm))
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrForeignFile))
+	assert.True(t, strings.Contains(err.Error(), foreignFile))
+
+	// case4: data file of newer format is refused
+	assert.Nil(t, os.WriteFile(foreignFile, []byte{'B', 'C', 'S', 'K', data.FileFormatVersion + 1}, fio.DataFilePerm))
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrNewerFileVersion))
+	assert.Nil(t, os.Remove(foreignFile))
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
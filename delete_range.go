@@ -0,0 +1,100 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sync/atomic"
+)
+
+// delete keys in [start, end) with a single range tombstone record
+// nil start means from the first key, nil end means to the last key
+func (db *DB) DeleteRange(start []byte, end []byte) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(end) > 0 && bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(start, noTransactionSeqNo),
+		Value: end,
+		Type:  data.LogRecordRangeDeleted,
+		SeqNo: db.commitSeqNo + 1,
+	}
+	pos, err := db.AppendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	// the tombstone itself is invalid
+	db.reclaimSize += int64(pos.Size)
+
+	keys := db.deleteIndexRange(start, end)
+
+	// conflict with transactions which have read these keys
+	if db.activeTxns > 0 && len(keys) > 0 {
+		seqNo := atomic.AddUint64(&db.seqNo, 1)
+		for _, key := range keys {
+			db.markKeyCommitted(key, seqNo)
+		}
+	}
+
+	db.commitSeqNo = logRecord.SeqNo
+	for _, key := range keys {
+		db.addVersion(key, logRecord.SeqNo, logRecord.Timestamp, pos, true)
+		db.notifyWatchers(EventDelete, key, nil, db.commitSeqNo)
+	}
+	return nil
+}
+
+// delete all keys with the prefix
+func (db *DB) DeletePrefix(prefix []byte) error {
+	if len(prefix) == 0 {
+		return ErrKeyIsEmpty
+	}
+	return db.DeleteRange(prefix, prefixEnd(prefix))
+}
+
+// remove keys in [start, end) from index, return the removed keys
+func (db *DB) deleteIndexRange(start []byte, end []byte) [][]byte {
+	if len(end) == 0 {
+		end = nil
+	}
+	it := db.index.RangeIterator(false, start, end)
+	var keys [][]byte
+	for ; it.Valid(); it.Next() {
+		// copy key, bptree key is only valid in its read transaction
+		keys = append(keys, append([]byte(nil), it.Key()...))
+	}
+	// close iterator first, cz bptree iterator holds a read transaction
+	it.Close()
+
+	for _, key := range keys {
+		if oldPos, ok := db.index.Delete(key); ok && oldPos != nil {
+			db.reclaimSize += oldPos.TotalSize()
+			db.releaseBlobs(oldPos)
+		}
+		db.updateSecondaryIndexes(key, nil, nil)
+	}
+	return keys
+}
+
+// the smallest key greater than all keys with the prefix, nil if there is none
+func prefixEnd(prefix []byte) []byte {
+	end := make([]byte, len(prefix))
+	copy(end, prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_DeleteRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+
+	// case1: invalid range
+	err = db.DeleteRange(utils.GetTestKey(20), utils.GetTestKey(10))
+	assert.Equal(t, ErrInvalidRange, err)
+
+	// case2: delete [10, 20)
+	err = db.DeleteRange(utils.GetTestKey(10), utils.GetTestKey(20))
+	assert.Nil(t, err)
+	_, err = db.Get(utils.GetTestKey(10))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(19))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(20))
+	assert.Nil(t, err)
+	assert.Equal(t, 90, len(db.ListKeys()))
+
+	// case3: iterator skips deleted keys
+	it := db.NewIterator(DefaultIteratorOptions)
+	it.Seek(utils.GetTestKey(10))
+	assert.Equal(t, utils.GetTestKey(20), it.Key())
+	it.Close()
+
+	// case4: put after range deletion
+	assert.Nil(t, db.Put(utils.GetTestKey(15), []byte("new")))
+
+	// case5: delete to the end
+	err = db.DeleteRange(utils.GetTestKey(90), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 81, len(db.ListKeys()))
+
+	// case6: restart, range tombstones are replayed in order
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 81, len(db2.ListKeys()))
+	val, err := db2.Get(utils.GetTestKey(15))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = db2.Get(utils.GetTestKey(11))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db2.Get(utils.GetTestKey(95))
+	assert.Equal(t, ErrKeyNotFound, err)
+	db2.Close()
+}
+
+func TestDB_DeletePrefix(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-prefix")
+	opts.DirPath = dir
+	opts.IndexType = BPtree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("tenant-a:1"), []byte("1")))
+	assert.Nil(t, db.Put([]byte("tenant-a:2"), []byte("2")))
+	assert.Nil(t, db.Put([]byte("tenant-b:1"), []byte("3")))
+	assert.Nil(t, db.Put([]byte("tenant-a"), []byte("4")))
+	assert.Nil(t, db.Put([]byte{0xff, 0xff}, []byte("5")))
+
+	// case1: empty prefix
+	err = db.DeletePrefix(nil)
+	assert.Equal(t, ErrKeyIsEmpty, err)
+
+	// case2: delete prefix
+	err = db.DeletePrefix([]byte("tenant-a:"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("tenant-a"), []byte("tenant-b:1"), {0xff, 0xff}}, db.ListKeys())
+
+	// case3: prefix without end
+	err = db.DeletePrefix([]byte{0xff})
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("tenant-a"), []byte("tenant-b:1")}, db.ListKeys())
+
+	// case4: restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("tenant-a"), []byte("tenant-b:1")}, db2.ListKeys())
+	db2.Close()
+}
+
+func TestDB_DeleteRange_Compact(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-delete-range-compact")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.DeleteRange(utils.GetTestKey(0), utils.GetTestKey(500)))
+	assert.Nil(t, db.Put(utils.GetTestKey(0), []byte("new")))
+	assert.True(t, db.Stat().ReclaimSize > 0)
+
+	err = db.Compact()
+	assert.Nil(t, err)
+
+	// restart, keys covered by the tombstone are dropped
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 501, len(db2.ListKeys()))
+	val, err := db2.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("new"), val)
+	_, err = db2.Get(utils.GetTestKey(499))
+	assert.Equal(t, ErrKeyNotFound, err)
+	db2.Close()
+}
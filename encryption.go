@@ -0,0 +1,111 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// provides the keys to encrypt data, blob, hint and seq-no files with, see Options.KeyProvider
+type KeyProvider = data.KeyProvider
+
+// key provider of Options.EncryptionKey or a key list, the id of Options.EncryptionKey is derived from the key,
+// so files encrypted by another key are found when opening them
+type staticKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+func newStaticKeyProvider(key []byte) *staticKeyProvider {
+	id := staticKeyId(key)
+	return &staticKeyProvider{current: id, keys: map[string][]byte{id: key}}
+}
+
+func staticKeyId(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+func (p *staticKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *staticKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, ErrEncryptionKeyMismatch
+	}
+	return key, nil
+}
+
+// parse the key list of command line tools, e.g. the keys rotated by a KeyProvider.
+// keys are separated by commas, each one is "id=hex" with the id kept in file header,
+// or a hex encoded key whose id is derived as Options.EncryptionKey does.
+// the first key is the current one, and nil is returned for an empty list
+func ParseKeyList(list string) (KeyProvider, error) {
+	if list == "" {
+		return nil, nil
+	}
+	p := &staticKeyProvider{keys: make(map[string][]byte)}
+	for i, item := range strings.Split(list, ",") {
+		id, hexKey, hasId := strings.Cut(item, "=")
+		if !hasId {
+			hexKey = id
+		}
+		key, err := hex.DecodeString(hexKey)
+		if err != nil || len(key) == 0 {
+			return nil, fmt.Errorf("invalid key #%d, it should be hex encoded", i+1)
+		}
+		if !hasId {
+			id = staticKeyId(key)
+		}
+		if _, ok := p.keys[id]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", id)
+		}
+		p.keys[id] = key
+		if i == 0 {
+			p.current = id
+		}
+	}
+	return p, nil
+}
+
+// keys of options, nil if encryption is disabled
+func keyProviderOf(options Options) KeyProvider {
+	if options.KeyProvider != nil {
+		return options.KeyProvider
+	}
+	if len(options.EncryptionKey) > 0 {
+		return newStaticKeyProvider(options.EncryptionKey)
+	}
+	return nil
+}
+
+// if new records can be appended to df, new records of encrypted db are never appended to plain files
+func (db *DB) canAppend(df *data.DataFile) bool {
+	return df.Appendable() && (db.keys == nil || df.Encrypted())
+}
+
+// if df is not encrypted by the current key, then it is rewritten by Compact or CompactBlobs
+func (db *DB) isStaleEncryption(df *data.DataFile) bool {
+	if db.keys == nil {
+		return false
+	}
+	keyId, _, err := db.keys.CurrentKey()
+	return err == nil && df.KeyId() != keyId
+}
+
+// if some data files are not encrypted by the current key, then Compact re-encrypts them regardless of DataFileMergeRatio
+func (db *DB) hasStaleEncryption() bool {
+	if db.isStaleEncryption(db.activeFile) {
+		return true
+	}
+	for _, df := range db.olderFiles {
+		if db.isStaleEncryption(df) {
+			return true
+		}
+	}
+	return false
+}
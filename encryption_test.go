@@ -0,0 +1,227 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mapKeyProvider struct {
+	current string
+	keys    map[string][]byte
+}
+
+func (p *mapKeyProvider) CurrentKey() (string, []byte, error) {
+	return p.current, p.keys[p.current], nil
+}
+
+func (p *mapKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, errors.New("key not found")
+	}
+	return key, nil
+}
+
+// if any file in dir contains b
+func dirContains(t *testing.T, dir string, b []byte) bool {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		if bytes.Contains(content, b) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDB_Encryption(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-1")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 512
+	opts.EncryptionKey = bytes.Repeat([]byte("k"), 32)
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: data and blob files are encrypted
+	secret := []byte("customer-secret-")
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), append(secret, utils.RandomValue(16)...)))
+	}
+	large := bytes.Repeat(secret, 100)
+	assert.Nil(t, db.Put([]byte("large"), large))
+	assert.Nil(t, db.PutStream([]byte("stream"), bytes.NewReader(secret), int64(len(secret))))
+	assert.False(t, dirContains(t, dir, secret))
+	r, err := db.GetReader([]byte("large"))
+	assert.Nil(t, err)
+	res, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, large, res)
+	assert.Nil(t, r.Close())
+
+	// case2: backup stays encrypted
+	backupDir, _ := os.MkdirTemp("", "bitcask-go-encryption-backup")
+	defer os.RemoveAll(backupDir)
+	assert.Nil(t, db.BackUp(backupDir))
+	assert.False(t, dirContains(t, backupDir, secret))
+
+	// case3: restart, merge and the hint file
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db2.Put([]byte("after-restart"), secret))
+	assert.Nil(t, db2.Compact())
+	assert.Nil(t, db2.Close())
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.False(t, dirContains(t, dir, secret))
+	val, err := db3.Get([]byte("after-restart"))
+	assert.Nil(t, err)
+	assert.Equal(t, secret, val)
+	val, err = db3.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+	val, err = db3.Get([]byte("stream"))
+	assert.Nil(t, err)
+	assert.Equal(t, secret, val)
+	assert.Equal(t, uint(102+1), db3.Stat().KeyNum)
+	assert.Nil(t, db3.Close())
+
+	// case4: backup is opened with the key
+	backupOpts := opts
+	backupOpts.DirPath = backupDir
+	db4, err := Open(backupOpts)
+	assert.Nil(t, err)
+	val, err = db4.Get([]byte("large"))
+	assert.Nil(t, err)
+	assert.Equal(t, large, val)
+	assert.Nil(t, db4.Close())
+
+	// case5: wrong key or no key
+	opts.EncryptionKey = bytes.Repeat([]byte("x"), 32)
+	_, err = Open(opts)
+	assert.Equal(t, ErrEncryptionKeyMismatch, err)
+	opts.EncryptionKey = nil
+	_, err = Open(opts)
+	assert.Equal(t, data.ErrKeyRequired, err)
+	opts.EncryptionKey = []byte("short")
+	_, err = Open(opts)
+	assert.Equal(t, ErrInvalidEncryptionKey, err)
+}
+
+func TestDB_Encryption_KeyRotation(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-encryption-2")
+	opts.DirPath = dir
+	opts.ValueThreshold = 512
+	opts.BlobFileGCRatio = 1
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// plain db written before encryption is enabled
+	values := make(map[int][]byte)
+	for i := 0; i < 100; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	values[100] = utils.RandomValue(1024)
+	assert.Nil(t, db.Put(utils.GetTestKey(100), values[100]))
+	assert.Nil(t, db.Close())
+
+	keys := &mapKeyProvider{current: "k1", keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte("1"), 16),
+		"k2": bytes.Repeat([]byte("2"), 32),
+	}}
+	opts.KeyProvider = keys
+	check := func(db *DB) {
+		for i, v := range values {
+			val, err := db.Get(utils.GetTestKey(i))
+			assert.Nil(t, err)
+			assert.Equal(t, v, val)
+		}
+	}
+
+	// case1: plain files are read, and new records are encrypted
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	check(db2)
+	values[101] = utils.RandomValue(64)
+	assert.Nil(t, db2.Put(utils.GetTestKey(101), values[101]))
+	assert.True(t, db2.activeFile.Encrypted())
+	assert.Equal(t, "k1", db2.activeFile.KeyId())
+	assert.Nil(t, db2.Close())
+
+	// case2: merge and blob gc re-encrypt the files of the old keys, regardless of the ratios
+	keys.current = "k2"
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	check(db3)
+	assert.Nil(t, db3.Compact())
+	assert.Nil(t, db3.CompactBlobs())
+	assert.Nil(t, db3.Close())
+	db4, err := Open(opts)
+	assert.Nil(t, err)
+	check(db4)
+	for _, df := range db4.olderFiles {
+		assert.Equal(t, "k2", df.KeyId())
+	}
+	assert.Equal(t, "k2", db4.activeFile.KeyId())
+	for _, df := range db4.blobFiles {
+		assert.Equal(t, "k2", df.KeyId())
+	}
+	assert.Nil(t, db4.Close())
+
+	// case3: the old key is not needed any more
+	delete(keys.keys, "k1")
+	db5, err := Open(opts)
+	assert.Nil(t, err)
+	check(db5)
+	assert.Nil(t, db5.Close())
+}
+
+func TestParseKeyList(t *testing.T) {
+	key1, key2 := bytes.Repeat([]byte("a"), 16), bytes.Repeat([]byte("b"), 16)
+
+	// case1: empty list
+	keys, err := ParseKeyList("")
+	assert.Nil(t, err)
+	assert.Nil(t, keys)
+
+	// case2: the first key is the current one, id of hex key is the one of Options.EncryptionKey
+	keys, err = ParseKeyList(hex.EncodeToString(key1) + ",k2=" + hex.EncodeToString(key2))
+	assert.Nil(t, err)
+	id, key, err := keys.CurrentKey()
+	assert.Nil(t, err)
+	assert.Equal(t, key1, key)
+	currentId, _, _ := newStaticKeyProvider(key1).CurrentKey()
+	assert.Equal(t, currentId, id)
+	key, err = keys.Key("k2")
+	assert.Nil(t, err)
+	assert.Equal(t, key2, key)
+	_, err = keys.Key("k3")
+	assert.Equal(t, ErrEncryptionKeyMismatch, err)
+
+	// case3: invalid keys
+	_, err = ParseKeyList("k1=xyz")
+	assert.NotNil(t, err)
+	_, err = ParseKeyList("k1=")
+	assert.NotNil(t, err)
+	_, err = ParseKeyList("k1=" + hex.EncodeToString(key1) + ",k1=" + hex.EncodeToString(key2))
+	assert.NotNil(t, err)
+}
@@ -8,6 +8,8 @@ var (
 	ErrUpdateIndexFailed = errors.New("fail to update index")
 	ErrKeyNotFound       = errors.New("key is not found in database")
 	ErrDataFileNotFound  = errors.New("data file is not found")
+	ErrInvalidTTL        = errors.New("ttl must not be negative")
+	ErrConditionFailed   = errors.New("condition of the write is not satisfied")
 	// options
 	ErrDBDirIsEmpty    = errors.New("database dir is empty")
 	ErrInvalidFileSize = errors.New("database file size must be greater than 0")
@@ -20,6 +22,51 @@ var (
 	ErrInvalidMergeRatio     = errors.New("invalid merge ratio, must between 0 and 1")
 	ErrMergeRatioUnreached   = errors.New("current radio does not reach the option.mergeRadio")
 	ErrNoEnoughSpaceForMerge = errors.New("no enough disk space for merge")
+	// transaction
+	ErrTxnConflict    = errors.New("transaction conflicts with others, plz retry it")
+	ErrTxnFinished    = errors.New("transaction has been committed or discarded")
+	ErrTxnUnsupported = errors.New("cannot use transaction in bptree, seq no file does not exist")
+	// snapshot
+	ErrSnapshotReleased = errors.New("snapshot has been released")
+	// merge operator
+	ErrMergeOperatorNotSet   = errors.New("merge operator is not set in options")
+	ErrMergeOperatorNotFound = errors.New("merge operator is not registered")
+	// iterator
+	ErrIteratorKeysOnly = errors.New("the iterator is keys only, value is not available")
+	// change log
+	ErrChangesCompacted = errors.New("the changes since the seqNo have been dropped by compaction")
+	// namespace
+	ErrNamespaceNameIsEmpty = errors.New("the namespace name is empty")
+	ErrNamespaceNotFound    = errors.New("namespace is not found in database")
+	ErrNamespaceDropped     = errors.New("namespace has been dropped")
+	// secondary index
+	ErrIndexNameIsEmpty = errors.New("the index name is empty")
+	ErrIndexExists      = errors.New("index with the same name already exists")
+	ErrIndexNotFound    = errors.New("index is not found in database")
+	// version history
+	ErrVersionNotRetained          = errors.New("the version of key is not retained, see Options.VersionRetention")
+	ErrVersionRetentionUnsupported = errors.New("version retention is not supported by B+ Tree index")
+	// lifecycle
+	ErrDBClosed = errors.New("the database has been closed")
+	// read-only mode
+	ErrReadOnly            = errors.New("the database is opened in read-only mode")
+	ErrReadOnlyUnsupported = errors.New("read-only mode is not supported by B+ Tree index")
+	// blob file
+	ErrInvalidBlobGCRatio = errors.New("invalid blob file gc ratio, must between 0 and 1")
+	ErrBlobUnsupported    = errors.New("blob files are not supported by B+ Tree index")
+	// compression
+	ErrInvalidCompression = errors.New("codec of compression is not registered")
+	// encryption
+	ErrInvalidEncryptionKey  = errors.New("invalid encryption key, must be 16, 24 or 32 bytes, and KeyProvider can not be set together")
+	ErrEncryptionKeyMismatch = errors.New("the file is encrypted by another key")
+	ErrEncryptionUnsupported = errors.New("encryption is not supported by B+ Tree index")
+	// recovery
+	ErrInvalidRecoveryPolicy = errors.New("invalid recovery policy")
+	ErrRepairUnsupported     = errors.New("repair is not supported by B+ Tree index")
+	// streaming
+	ErrInvalidValueSize = errors.New("the size of value can not be negative")
+	// range deletion
+	ErrInvalidRange = errors.New("the start of range must be less than the end")
 	//flock
 	ErrDatabaseIsBeingUsed = errors.New("the database directory is used by another process")
 )
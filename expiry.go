@@ -0,0 +1,148 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// index which keeps its keys with ttl in a heap ordered by expire time,
+// so the expired keys are found without walking the whole index
+type expiryIndex struct {
+	index.Indexer
+	lock    *sync.Mutex
+	entries map[string]*expiryEntry
+	heap    expiryHeap
+}
+
+type expiryEntry struct {
+	key   []byte
+	pos   *data.LogRecordPos
+	index int // position in heap
+}
+
+// B+ Tree index is kept on disk, so its keys with ttl are loaded here, which walks it once
+func newExpiryIndex(idx index.Indexer) *expiryIndex {
+	ei := &expiryIndex{
+		Indexer: idx,
+		lock:    new(sync.Mutex),
+		entries: make(map[string]*expiryEntry),
+	}
+	if idx.Size() == 0 {
+		return ei
+	}
+	it := idx.Iterator(false)
+	for it.Rewind(); it.Valid(); it.Next() {
+		if pos := it.Value(); pos.Expire > 0 {
+			// copy key, bptree key is only valid in its read transaction
+			key := make([]byte, len(it.Key()))
+			copy(key, it.Key())
+			ei.track(key, pos)
+		}
+	}
+	it.Close()
+	return ei
+}
+
+func (ei *expiryIndex) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	oldPos := ei.Indexer.Put(key, pos)
+	ei.track(key, pos)
+	return oldPos
+}
+
+func (ei *expiryIndex) Delete(key []byte) (*data.LogRecordPos, bool) {
+	oldPos, ok := ei.Indexer.Delete(key)
+	ei.track(key, nil)
+	return oldPos, ok
+}
+
+// update the entry of key, pos without ttl or nil removes it
+func (ei *expiryIndex) track(key []byte, pos *data.LogRecordPos) {
+	ei.lock.Lock()
+	defer ei.lock.Unlock()
+
+	entry, ok := ei.entries[string(key)]
+	switch {
+	case pos == nil || pos.Expire == 0:
+		if ok {
+			heap.Remove(&ei.heap, entry.index)
+			delete(ei.entries, string(key))
+		}
+	case ok:
+		entry.pos = pos
+		heap.Fix(&ei.heap, entry.index)
+	default:
+		entry = &expiryEntry{key: key, pos: pos}
+		heap.Push(&ei.heap, entry)
+		ei.entries[string(key)] = entry
+	}
+}
+
+// size and number of the expired keys which are not removed yet, it only visits the expired entries of heap
+func (ei *expiryIndex) expired() (int64, int) {
+	ei.lock.Lock()
+	defer ei.lock.Unlock()
+
+	var size int64
+	var num int
+	ei.visitExpired(time.Now().UnixNano(), func(entry *expiryEntry) {
+		size += entry.pos.TotalSize()
+		num++
+	})
+	return size, num
+}
+
+// keys expired, they are removed by the caller
+func (ei *expiryIndex) expiredKeys() [][]byte {
+	ei.lock.Lock()
+	defer ei.lock.Unlock()
+
+	var keys [][]byte
+	ei.visitExpired(time.Now().UnixNano(), func(entry *expiryEntry) {
+		keys = append(keys, entry.key)
+	})
+	return keys
+}
+
+// children of an entry never expire earlier than it, so the subtrees not expired are skipped
+func (ei *expiryIndex) visitExpired(now int64, fn func(entry *expiryEntry)) {
+	var walk func(i int)
+	walk = func(i int) {
+		if i >= len(ei.heap) || ei.heap[i].pos.Expire > now {
+			return
+		}
+		fn(ei.heap[i])
+		walk(2*i + 1)
+		walk(2*i + 2)
+	}
+	walk(0)
+}
+
+// min-heap of entries by expire time
+type expiryHeap []*expiryEntry
+
+func (h expiryHeap) Len() int { return len(h) }
+
+func (h expiryHeap) Less(i, j int) bool { return h[i].pos.Expire < h[j].pos.Expire }
+
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x any) {
+	entry := x.(*expiryEntry)
+	entry.index = len(*h)
+	*h = append(*h, entry)
+}
+
+func (h *expiryHeap) Pop() any {
+	old := *h
+	entry := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return entry
+}
@@ -0,0 +1,88 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"bitcask-go/utils"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryIndex_Expired(t *testing.T) {
+	ei := newExpiryIndex(index.NewBtree())
+	now := time.Now().UnixNano()
+
+	// keys expired an hour ago, not expired or without ttl, some of them are overwritten or deleted
+	for i := 0; i < 2000; i++ {
+		var expire int64
+		switch rand.Intn(3) {
+		case 0:
+			expire = now - int64(rand.Intn(3600))*int64(time.Second)
+		case 1:
+			expire = now + int64(rand.Intn(3600)+60)*int64(time.Second)
+		}
+		key := utils.GetTestKey(rand.Intn(500))
+		if rand.Intn(10) == 0 {
+			ei.Delete(key)
+			continue
+		}
+		ei.Put(key, &data.LogRecordPos{Fid: 1, Offset: int64(i), Size: uint32(i%7 + 1), Expire: expire})
+	}
+
+	var wantSize int64
+	var wantNum int
+	it := ei.Iterator(false)
+	for it.Rewind(); it.Valid(); it.Next() {
+		if pos := it.Value(); pos.IsExpired() {
+			wantSize += pos.TotalSize()
+			wantNum++
+		}
+	}
+	it.Close()
+
+	size, num := ei.expired()
+	assert.Equal(t, wantSize, size)
+	assert.Equal(t, wantNum, num)
+	assert.Equal(t, wantNum, len(ei.expiredKeys()))
+
+	// case1: removed keys are not tracked any more
+	for _, key := range ei.expiredKeys() {
+		ei.Delete(key)
+	}
+	size, num = ei.expired()
+	assert.Equal(t, int64(0), size)
+	assert.Equal(t, 0, num)
+	assert.Equal(t, len(ei.heap), len(ei.entries))
+}
+
+func TestDB_Stat_Expired_BPlusTree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stat-expired")
+	opts.DirPath = dir
+	opts.IndexType = BPtree
+	db, err := Open(opts)
+	assert.Nil(t, err)
+
+	for i := 0; i < 10; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(24), 50*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	err = db.Put(utils.GetTestKey(10), utils.RandomValue(24))
+	assert.Nil(t, err)
+	time.Sleep(100 * time.Millisecond)
+	stat := db.Stat()
+	assert.Equal(t, uint(1), stat.KeyNum)
+	assert.True(t, stat.ReclaimSize > 0)
+
+	// case1: keys with ttl are loaded from B+ Tree index on restart
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	defer destroyDB(db)
+	assert.Equal(t, 10, len(db.index.entries))
+	assert.Equal(t, uint(1), db.Stat().KeyNum)
+}
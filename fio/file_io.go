@@ -21,6 +21,15 @@ func NewFileIOManager(fileName string) (*FileIO, error) {
 	return &FileIO{fd: fd}, nil
 }
 
+// open an existing file read-only, Write of it fails
+func NewReadOnlyFileIOManager(fileName string) (*FileIO, error) {
+	fd, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return &FileIO{fd: fd}, nil
+}
+
 func (fio *FileIO) Read(b []byte, offset int64) (int, error) {
 	return fio.fd.ReadAt(b, offset)
 }
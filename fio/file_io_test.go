@@ -91,3 +91,25 @@ func TestFileIO_Close(t *testing.T) {
 	err = fio.Close()
 	assert.Nil(t, err)
 }
+
+func TestNewReadOnlyFileIOManager(t *testing.T) {
+	path := filepath.Join("/tmp", "readonly.data")
+	defer destoryFile(path)
+
+	// file is not created
+	_, err := NewReadOnlyFileIOManager(path)
+	assert.True(t, os.IsNotExist(err))
+
+	assert.Nil(t, os.WriteFile(path, []byte("hello"), DataFilePerm))
+	fio, err := NewReadOnlyFileIOManager(path)
+	assert.Nil(t, err)
+	defer fio.Close()
+
+	_, err = fio.Write([]byte("world"))
+	assert.NotNil(t, err)
+	b := make([]byte, 5)
+	n, err := fio.Read(b, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	assert.Equal(t, []byte("hello"), b)
+}
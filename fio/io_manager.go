@@ -7,6 +7,7 @@ type FileIOType byte
 const (
 	StandardFIO FileIOType = iota
 	MemoryMap
+	ReadOnlyFIO // standard file io without creating or writing the file
 )
 
 type IOManager interface {
@@ -24,6 +25,8 @@ func NewIOManager(fileName string, ioType FileIOType) (IOManager, error) {
 		return NewFileIOManager(fileName)
 	case MemoryMap:
 		return NewMMapIOManager(fileName)
+	case ReadOnlyFIO:
+		return NewReadOnlyFileIOManager(fileName)
 	default:
 		panic("unsupported io type")
 	}
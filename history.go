@@ -0,0 +1,234 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"sort"
+	"time"
+)
+
+// a version of key, returned by History
+type KeyVersion struct {
+	SeqNo     uint64    // commit seqNo of the version
+	Timestamp time.Time // time of writing
+	Value     []byte    // nil if the version is a deletion
+	Deleted   bool
+}
+
+// version of key in memory, pos of a deletion is its tombstone, or nil if it is loaded from hint file
+type keyVersion struct {
+	seqNo     uint64
+	timestamp int64
+	pos       *data.LogRecordPos
+	deleted   bool
+}
+
+// retained versions of key in the order of seqNo, the last one is the current version
+type keyHistory struct {
+	versions []*keyVersion
+	pruned   bool // older versions have been dropped, so reads before the oldest one fail
+}
+
+// position of a record in data files
+type recordPosition struct {
+	fid    uint32
+	offset int64
+}
+
+// retained versions in merge files, which are kept by Compact and saved in hint file
+type mergedVersions struct {
+	positions map[recordPosition]*keyVersion // old values, rewritten with their records
+	deleted   map[string][]*keyVersion       // deletions, only saved in hint file
+}
+
+// get the value of key as of seqNo, that is the newest version committed at or before seqNo
+// only retained versions can be read, see Options.VersionRetention
+func (db *DB) GetAt(key []byte, seqNo uint64) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if db.histories == nil {
+		return nil, ErrVersionNotRetained
+	}
+	h, ok := db.histories[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+
+	// the first version newer than seqNo
+	i := sort.Search(len(h.versions), func(i int) bool {
+		return h.versions[i].seqNo > seqNo
+	})
+	if i == 0 {
+		if h.pruned {
+			return nil, ErrVersionNotRetained
+		}
+		return nil, ErrKeyNotFound
+	}
+	v := h.versions[i-1]
+	if v.deleted || v.pos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return db.getValueByPosition(v.pos)
+}
+
+// get the retained versions of key, from old to new
+func (db *DB) History(key []byte) ([]*KeyVersion, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if db.histories == nil {
+		return nil, ErrVersionNotRetained
+	}
+	h, ok := db.histories[string(key)]
+	if !ok {
+		return nil, nil
+	}
+
+	versions := make([]*KeyVersion, 0, len(h.versions))
+	for _, v := range h.versions {
+		kv := &KeyVersion{
+			SeqNo:     v.seqNo,
+			Timestamp: time.Unix(0, v.timestamp),
+			Deleted:   v.deleted,
+		}
+		if !v.deleted {
+			value, err := db.getValueByPosition(v.pos)
+			if err != nil {
+				return nil, err
+			}
+			kv.Value = value
+		}
+		versions = append(versions, kv)
+	}
+	return versions, nil
+}
+
+func (db *DB) versionRetentionEnabled() bool {
+	return db.options.VersionRetention > 0 || db.options.VersionRetentionDuration > 0
+}
+
+// add a committed version of key in the default namespace, under db.mu
+func (db *DB) addVersion(key []byte, seqNo uint64, timestamp int64, pos *data.LogRecordPos, deleted bool) {
+	if h := db.insertVersion(key, seqNo, timestamp, pos, deleted); h != nil {
+		db.dropDeletedHistory(string(key), h)
+	}
+}
+
+// insert a version in the order of seqNo and prune the history, under db.mu
+func (db *DB) insertVersion(key []byte, seqNo uint64, timestamp int64, pos *data.LogRecordPos, deleted bool) *keyHistory {
+	if db.histories == nil {
+		return nil
+	}
+	h, ok := db.histories[string(key)]
+	if !ok {
+		h = &keyHistory{}
+		db.histories[string(key)] = h
+	}
+
+	// versions from hint file are not in order
+	v := &keyVersion{seqNo: seqNo, timestamp: timestamp, pos: pos, deleted: deleted}
+	i := sort.Search(len(h.versions), func(i int) bool {
+		return h.versions[i].seqNo > seqNo
+	})
+	h.versions = append(h.versions, nil)
+	copy(h.versions[i+1:], h.versions[i:])
+	h.versions[i] = v
+
+	db.pruneVersions(h, time.Now().UnixNano())
+	return h
+}
+
+// history of deleted key is dropped once its older versions are pruned, so histories does not
+// keep every key ever written, return true if it is dropped, under db.mu
+func (db *DB) dropDeletedHistory(key string, h *keyHistory) bool {
+	if len(h.versions) == 1 && h.versions[0].deleted {
+		delete(db.histories, key)
+		return true
+	}
+	return false
+}
+
+// the value of the current version of key is moved to pos, under db.mu
+func (db *DB) moveVersion(key []byte, pos *data.LogRecordPos) {
+	if db.histories == nil {
+		return
+	}
+	if h, ok := db.histories[string(key)]; ok && len(h.versions) > 0 {
+		h.versions[len(h.versions)-1].pos = pos
+	}
+}
+
+// add a version loaded from hint file, the older versions of key may have been dropped by merge
+// versions in hint file are not in order, so the history of deleted key is not dropped here
+func (db *DB) addMergedVersion(key []byte, seqNo uint64, timestamp int64, pos *data.LogRecordPos, deleted bool) {
+	if h := db.insertVersion(key, seqNo, timestamp, pos, deleted); h != nil {
+		h.pruned = true
+	}
+}
+
+// drop the versions out of retention, the current version is always kept, under db.mu
+// a version is kept if it is one of the last VersionRetention versions, or newer than VersionRetentionDuration
+func (db *DB) pruneVersions(h *keyHistory, now int64) {
+	var cutoff int64
+	if db.options.VersionRetentionDuration > 0 {
+		cutoff = now - int64(db.options.VersionRetentionDuration)
+	}
+	n := len(h.versions)
+	first := n - 1
+	for first > 0 {
+		v := h.versions[first-1]
+		byCount := uint(n-first) < db.options.VersionRetention
+		byTime := cutoff > 0 && v.timestamp >= cutoff
+		if !byCount && !byTime {
+			break
+		}
+		first--
+	}
+	if first > 0 {
+		h.versions = append([]*keyVersion(nil), h.versions[first:]...)
+		h.pruned = true
+	}
+}
+
+// retained versions in the files before nonMergeFileId, under db.mu
+func (db *DB) retainedVersions(nonMergeFileId uint32) *mergedVersions {
+	mv := &mergedVersions{
+		positions: make(map[recordPosition]*keyVersion),
+		deleted:   make(map[string][]*keyVersion),
+	}
+	now := time.Now().UnixNano()
+	for key, h := range db.histories {
+		db.pruneVersions(h, now)
+		// the tombstone is not saved in hint file either
+		if db.dropDeletedHistory(key, h) {
+			continue
+		}
+		for _, v := range h.versions {
+			if v.pos != nil && v.pos.Fid >= nonMergeFileId {
+				continue
+			}
+			if v.deleted {
+				mv.deleted[key] = append(mv.deleted[key], v)
+			} else {
+				mv.positions[recordPosition{fid: v.pos.Fid, offset: v.pos.Offset}] = v
+			}
+		}
+	}
+	return mv
+}
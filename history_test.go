@@ -0,0 +1,201 @@
+package bitcaskminidb
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_History(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-1")
+	opts.DirPath = dir
+	opts.MergeOperator = MergeOperatorAppend
+	opts.VersionRetention = 3
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for _, v := range []string{"v1", "v2", "v3", "v4"} {
+		assert.Nil(t, db.Put([]byte("k"), []byte(v)))
+	}
+
+	// case1: only the last 3 versions are kept
+	versions, err := db.History([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(versions))
+	assert.Equal(t, []byte("v2"), versions[0].Value)
+	assert.Equal(t, []byte("v4"), versions[2].Value)
+	assert.True(t, versions[0].SeqNo < versions[1].SeqNo)
+	assert.False(t, versions[0].Timestamp.IsZero())
+
+	val, err := db.GetAt([]byte("k"), versions[1].SeqNo)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v3"), val)
+	_, err = db.GetAt([]byte("k"), versions[0].SeqNo-1)
+	assert.Equal(t, ErrVersionNotRetained, err)
+	_, err = db.GetAt([]byte("unknown"), versions[2].SeqNo)
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// case2: deletion, write batch, merge operand and range deletion are versions too
+	assert.Nil(t, db.Delete([]byte("k")))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k"), []byte("batch")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Merge([]byte("k"), []byte("+op")))
+	versions, _ = db.History([]byte("k"))
+	assert.Equal(t, 3, len(versions))
+	assert.True(t, versions[0].Deleted)
+	assert.Nil(t, versions[0].Value)
+	assert.Equal(t, []byte("batch"), versions[1].Value)
+	assert.Equal(t, []byte("batch+op"), versions[2].Value)
+
+	_, err = db.GetAt([]byte("k"), versions[0].SeqNo)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, db.DeletePrefix([]byte("k")))
+	versions, _ = db.History([]byte("k"))
+	assert.True(t, versions[2].Deleted)
+	val, err = db.GetAt([]byte("k"), versions[1].SeqNo)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("batch+op"), val)
+
+	// case3: restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	versions2, err := db2.History([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, versions, versions2)
+	db2.Close()
+}
+
+func TestDB_History_Compact(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-2")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.MergeOperator = MergeOperatorAppend
+	opts.VersionRetention = 2
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("a1")))
+	assert.Nil(t, db.Put([]byte("a"), []byte("a2")))
+	assert.Nil(t, db.Merge([]byte("a"), []byte("+3")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("b1")))
+	assert.Nil(t, db.Delete([]byte("b")))
+	versionsA, _ := db.History([]byte("a"))
+	versionsB, _ := db.History([]byte("b"))
+
+	// case1: retained versions survive compaction and restart
+	assert.Nil(t, db.Compact())
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+
+	versions, err := db2.History([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, versionsA, versions)
+	assert.Equal(t, []byte("a2"), versions[0].Value)
+	assert.Equal(t, []byte("a2+3"), versions[1].Value)
+	versions, err = db2.History([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, versionsB, versions)
+	val, err := db2.GetAt([]byte("b"), versions[0].SeqNo)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b1"), val)
+
+	// case2: versions dropped by compaction can not be read
+	_, err = db2.GetAt([]byte("a"), versionsA[0].SeqNo-1)
+	assert.Equal(t, ErrVersionNotRetained, err)
+	db2.Close()
+}
+
+func TestDB_History_Duration(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-3")
+	opts.DirPath = dir
+	opts.VersionRetentionDuration = time.Hour
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: all versions newer than the duration are kept
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, db.Put([]byte("k"), []byte{byte(i)}))
+	}
+	versions, err := db.History([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, 10, len(versions))
+
+	// case2: disabled or not supported
+	opts2 := DefaultOptions
+	dir2, _ := os.MkdirTemp("", "bitcask-go-history-4")
+	opts2.DirPath = dir2
+	db2, err := Open(opts2)
+	defer destroyDB(db2)
+	assert.Nil(t, err)
+	_, err = db2.GetAt([]byte("k"), 1)
+	assert.Equal(t, ErrVersionNotRetained, err)
+	_, err = db2.History([]byte("k"))
+	assert.Equal(t, ErrVersionNotRetained, err)
+
+	opts2.IndexType = BPtree
+	opts2.VersionRetention = 1
+	_, err = Open(opts2)
+	assert.Equal(t, ErrVersionRetentionUnsupported, err)
+}
+
+func TestDB_History_Deleted(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-history-5")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.VersionRetentionDuration = 100 * time.Millisecond
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("a"), []byte("a1")))
+	assert.Nil(t, db.Delete([]byte("a")))
+	assert.Nil(t, db.Put([]byte("b"), []byte("b1")))
+
+	// case1: versions of deleted key are kept before they expire
+	versions, err := db.History([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+
+	// case2: history of deleted key is dropped once its versions expire
+	time.Sleep(200 * time.Millisecond)
+	assert.Nil(t, db.Compact())
+	versions, err = db.History([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, versions)
+	_, err = db.GetAt([]byte("a"), 1)
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 1, len(db.histories))
+
+	// case3: so is a deleted key with no older version kept
+	db.options.VersionRetentionDuration = 0
+	db.options.VersionRetention = 1
+	assert.Nil(t, db.Delete([]byte("b")))
+	assert.Equal(t, 0, len(db.histories))
+
+	// case4: restart
+	assert.Nil(t, db.Close())
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	versions, err = db2.History([]byte("a"))
+	assert.Nil(t, err)
+	assert.Nil(t, versions)
+	assert.Nil(t, db2.Close())
+}
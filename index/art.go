@@ -3,9 +3,9 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-	"sort"
 	"sync"
 
+	"github.com/google/btree"
 	goART "github.com/plar/go-adaptive-radix-tree"
 )
 
@@ -13,19 +13,22 @@ import (
 // encapsulate goART, implement interface Indexer (index.go)
 
 type AdaptiveRadixTree struct {
-	tree goART.Tree
-	lock *sync.RWMutex
+	tree  goART.Tree
+	lock  *sync.RWMutex
+	views map[*artView]struct{} // views of open iterators, under lock
 }
 
 func NewART() *AdaptiveRadixTree {
 	return &AdaptiveRadixTree{
-		tree: goART.New(),
-		lock: new(sync.RWMutex),
+		tree:  goART.New(),
+		lock:  new(sync.RWMutex),
+		views: make(map[*artView]struct{}),
 	}
 }
 
 func (art *AdaptiveRadixTree) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
 	art.lock.Lock()
+	art.saveForViews(key)
 	oldValue, _ := art.tree.Insert(key, pos)
 	art.lock.Unlock()
 	if oldValue == nil {
@@ -48,6 +51,7 @@ func (art *AdaptiveRadixTree) Get(key []byte) *data.LogRecordPos {
 
 func (art *AdaptiveRadixTree) Delete(key []byte) (*data.LogRecordPos, bool) {
 	art.lock.Lock()
+	art.saveForViews(key)
 	oldValue, isDeleted := art.tree.Delete(key)
 	art.lock.Unlock()
 
@@ -70,72 +74,240 @@ func (art *AdaptiveRadixTree) Close() error {
 	return nil
 }
 
+// goART does not support copy-on-write, so we have to copy all the nodes,
+// which costs O(n) time and memory and blocks writers meanwhile
+func (art *AdaptiveRadixTree) Snapshot() (Indexer, error) {
+	art.lock.RLock()
+	defer art.lock.RUnlock()
+
+	tree := goART.New()
+	art.tree.ForEach(func(node goART.Node) bool {
+		tree.Insert(node.Key(), node.Value())
+		return true
+	})
+	return &AdaptiveRadixTree{
+		tree:  tree,
+		lock:  new(sync.RWMutex),
+		views: make(map[*artView]struct{}),
+	}, nil
+}
+
 func (art *AdaptiveRadixTree) Iterator(reverse bool) Iterator {
+	return art.RangeIterator(reverse, nil, nil)
+}
+
+// the iterator sees the tree at the time it is created, like the btree one iterating on a clone
+func (art *AdaptiveRadixTree) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
 	if art == nil {
 		return nil
 	}
-	art.lock.RLock()
-	defer art.lock.RUnlock()
-	return NewARTIterator(art.tree, reverse)
+	return newARTRangeIterator(art, reverse, lowerBound, upperBound)
 }
 
-type artIterator struct {
-	curIndex int     // current index number
-	reverse  bool    // support reverse traversal
-	values   []*Item // snapshot for the index, one Item includes key + pos
+// point-in-time view of an open iterator, goART does not support copy-on-write,
+// so the old items of the keys written after the view is created are saved in it
+type artView struct {
+	lowerBound []byte
+	upperBound []byte
+	saved      *btree.BTree // old items, pos is nil if the key did not exist
 }
 
-func NewARTIterator(tree goART.Tree, reverse bool) *artIterator {
-	var idx int
-	if reverse {
-		idx = tree.Size() - 1
+// save the current item of key for the views before it is written, under lock
+func (art *AdaptiveRadixTree) saveForViews(key []byte) {
+	if len(art.views) == 0 {
+		return
+	}
+	var pos *data.LogRecordPos
+	if value, found := art.tree.Search(key); found {
+		pos = value.(*data.LogRecordPos)
 	}
-	values := make([]*Item, tree.Size())
+	for view := range art.views {
+		if !inRange(key, view.lowerBound, view.upperBound) || view.saved.Has(&Item{key: key}) {
+			continue
+		}
+		view.saved.ReplaceOrInsert(&Item{key: append([]byte(nil), key...), pos: pos})
+	}
+}
 
-	saveValues := func(node goART.Node) bool {
-		item := &Item{
-			key: node.Key(),
-			pos: node.Value().(*data.LogRecordPos),
+// visit the items from key (nil means the first one) in ascending order until fn returns false,
+// key itself is skipped if not inclusive. goART only walks forward from the first key, so the walk
+// starts from the keys of prefix key, then goes on with the prefixes of the next bytes, level by level,
+// it does not read the keys before key, under lock
+func (art *AdaptiveRadixTree) ascendFrom(key []byte, inclusive bool, fn func(item *Item) bool) {
+	stopped := false
+	visitPrefix := func(prefix []byte) {
+		art.forEachPrefix(prefix, func(node goART.Node) bool {
+			nodeKey := node.Key()
+			if !isPrefixLeaf(node, prefix) || (!inclusive && bytes.Equal(nodeKey, key)) {
+				return true
+			}
+			stopped = !fn(&Item{key: nodeKey, pos: node.Value().(*data.LogRecordPos)})
+			return !stopped
+		})
+	}
+	visitPrefix(key)
+	for i := len(key) - 1; i >= 0 && !stopped; i-- {
+		for b := int(key[i]) + 1; b <= 0xff && !stopped; b++ {
+			visitPrefix(append(key[:i:i], byte(b)))
 		}
-		values[idx] = item
-		if reverse {
-			idx--
-		} else {
-			idx++
+	}
+}
+
+// the max number of items read forward from a subtree when descending, larger subtree is split by the next byte
+const artDescendWalkSize = 2 * iteratorBatchSize
+
+// visit the items before key (nil means the last one) in descending order until fn returns false,
+// key itself is visited if inclusive. the subtrees of the prefixes of the previous bytes are visited
+// level by level from key, it does not read the keys after key, under lock
+func (art *AdaptiveRadixTree) descendFrom(key []byte, inclusive bool, fn func(item *Item) bool) {
+	if key == nil {
+		art.descendPrefix(nil, fn)
+		return
+	}
+	if inclusive && !art.visitKey(key, fn) {
+		return
+	}
+	for i := len(key) - 1; i >= 0; i-- {
+		for b := int(key[i]) - 1; b >= 0; b-- {
+			if !art.descendPrefix(append(key[:i:i], byte(b)), fn) {
+				return
+			}
+		}
+		// the key of the prefix is before the longer keys of it
+		if i > 0 && !art.visitKey(key[:i], fn) {
+			return
+		}
+	}
+}
+
+// visit the items of prefix in descending order, return false if fn stops it.
+// goART only walks forward, so a small subtree is read forward and visited backward,
+// and a large one is split by the next byte, the items read are bounded by artDescendWalkSize for each subtree
+func (art *AdaptiveRadixTree) descendPrefix(prefix []byte, fn func(item *Item) bool) bool {
+	items := make([]*Item, 0, artDescendWalkSize)
+	large := false
+	art.forEachPrefix(prefix, func(node goART.Node) bool {
+		if !isPrefixLeaf(node, prefix) {
+			return true
+		}
+		if len(items) == artDescendWalkSize {
+			large = true
+			return false
+		}
+		items = append(items, &Item{key: node.Key(), pos: node.Value().(*data.LogRecordPos)})
+		return true
+	})
+	if !large {
+		for i := len(items) - 1; i >= 0; i-- {
+			if !fn(items[i]) {
+				return false
+			}
 		}
 		return true
 	}
+	for b := 0xff; b >= 0; b-- {
+		if !art.descendPrefix(append(prefix[:len(prefix):len(prefix)], byte(b)), fn) {
+			return false
+		}
+	}
+	return len(prefix) == 0 || art.visitKey(prefix, fn)
+}
 
-	tree.ForEach(saveValues)
+// walk the subtree of prefix, goART does not match any key with empty prefix, so the whole tree is walked for it
+func (art *AdaptiveRadixTree) forEachPrefix(prefix []byte, fn goART.Callback) {
+	if len(prefix) == 0 {
+		art.tree.ForEach(fn)
+		return
+	}
+	art.tree.ForEachPrefix(prefix, fn)
+}
+
+// ForEachPrefix of goART also visits the inner nodes of the subtree, and may visit the leaves
+// of a partially matched compressed prefix, so only the leaves of prefix are taken
+func isPrefixLeaf(node goART.Node, prefix []byte) bool {
+	return node.Kind() == goART.Leaf && bytes.HasPrefix(node.Key(), prefix)
+}
 
-	return &artIterator{
-		curIndex: 0,
-		reverse:  reverse,
-		values:   values,
+// visit key if it is in tree, return false if fn stops
+func (art *AdaptiveRadixTree) visitKey(key []byte, fn func(item *Item) bool) bool {
+	value, found := art.tree.Search(key)
+	if !found {
+		return true
 	}
+	return fn(&Item{key: append([]byte(nil), key...), pos: value.(*data.LogRecordPos)})
+}
+
+// art's index iterator
+// it reads iteratorBatchSize items at a time by walking the tree from the last key read,
+// so Seek and the next batches never walk the keys before them
+type artIterator struct {
+	art        *AdaptiveRadixTree
+	view       *artView
+	reverse    bool    // support reverse traversal
+	lowerBound []byte  // inclusive, nil means unbounded
+	upperBound []byte  // exclusive, nil means unbounded
+	values     []*Item // current batch of items, one Item includes key + pos
+	curIndex   int     // current index number in values
+	scanned    []byte  // the items of tree are read to it, the next batch starts after it
+	exhausted  bool    // no more items after values
+}
+
+// new art iterator, the tree should not be modified during iteration
+func NewARTIterator(tree goART.Tree, reverse bool) *artIterator {
+	art := &AdaptiveRadixTree{tree: tree, lock: new(sync.RWMutex), views: make(map[*artView]struct{})}
+	return newARTRangeIterator(art, reverse, nil, nil)
+}
+
+func newARTRangeIterator(art *AdaptiveRadixTree, reverse bool, lowerBound []byte, upperBound []byte) *artIterator {
+	arti := &artIterator{
+		art:        art,
+		view:       &artView{lowerBound: lowerBound, upperBound: upperBound, saved: btree.New(32)},
+		reverse:    reverse,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
+	}
+	art.lock.Lock()
+	art.views[arti.view] = struct{}{}
+	art.lock.Unlock()
+	arti.Rewind()
+	return arti
 }
 
 // go back to the first data of iterator
 func (arti *artIterator) Rewind() {
-	arti.curIndex = 0
+	if arti.reverse {
+		arti.fillReverse(arti.upperBound, false)
+		return
+	}
+	arti.fill(arti.lowerBound, true)
 }
 
 // find the first target key which is >= or <=(reverse) params-key, and start traversing from target key
 func (arti *artIterator) Seek(key []byte) {
 	if arti.reverse {
-		arti.curIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) <= 0
-		})
-	} else {
-		arti.curIndex = sort.Search(len(arti.values), func(i int) bool {
-			return bytes.Compare(arti.values[i].key, key) >= 0
-		})
+		if arti.upperBound != nil && bytes.Compare(key, arti.upperBound) >= 0 {
+			arti.fillReverse(arti.upperBound, false)
+		} else {
+			arti.fillReverse(key, true)
+		}
+		return
 	}
+	if arti.lowerBound != nil && bytes.Compare(key, arti.lowerBound) < 0 {
+		key = arti.lowerBound
+	}
+	arti.fill(key, true)
 }
 
 // jump to the next key
 func (arti *artIterator) Next() {
 	arti.curIndex += 1
+	if arti.curIndex == len(arti.values) && !arti.exhausted {
+		if arti.reverse {
+			arti.fillReverse(arti.scanned, false)
+		} else {
+			arti.fill(arti.scanned, false)
+		}
+	}
 }
 
 // used to determine whether the traversal has been completed
@@ -153,7 +325,157 @@ func (arti *artIterator) Value() *data.LogRecordPos {
 	return arti.values[arti.curIndex].pos
 }
 
-// close iterator and release resources
+// close iterator and release resources, the tree stops saving old items for it
 func (arti *artIterator) Close() {
+	if arti.view != nil {
+		arti.art.lock.Lock()
+		delete(arti.art.views, arti.view)
+		arti.art.lock.Unlock()
+		arti.view = nil
+	}
 	arti.values = nil
+	arti.exhausted = true
+}
+
+// read the next batch of items after key (nil means the first one), key itself is skipped if not inclusive,
+// batches of the keys which did not exist at the time of view are skipped
+func (arti *artIterator) fill(key []byte, inclusive bool) {
+	for arti.fillBatch(key, inclusive); len(arti.values) == 0 && !arti.exhausted; arti.fillBatch(arti.scanned, false) {
+	}
+}
+
+func (arti *artIterator) fillBatch(key []byte, inclusive bool) {
+	arti.values = make([]*Item, 0, iteratorBatchSize)
+	arti.curIndex = 0
+	if arti.view == nil {
+		arti.exhausted = true
+		return
+	}
+	arti.art.lock.RLock()
+	defer arti.art.lock.RUnlock()
+
+	// the items of tree in the batch
+	items := make([]*Item, 0, iteratorBatchSize)
+	arti.art.ascendFrom(key, inclusive, func(item *Item) bool {
+		if arti.upperBound != nil && bytes.Compare(item.key, arti.upperBound) >= 0 {
+			return false
+		}
+		items = append(items, item)
+		return len(items) < iteratorBatchSize
+	})
+	// the saved items up to the last item of tree, or to the end
+	var end []byte
+	arti.exhausted = true
+	if len(items) == iteratorBatchSize {
+		end = items[len(items)-1].key
+		arti.exhausted = false
+	}
+	var saved []*Item
+	visit := func(it btree.Item) bool {
+		item := it.(*Item)
+		if !inclusive && bytes.Equal(item.key, key) {
+			return true
+		}
+		if end != nil && bytes.Compare(item.key, end) > 0 {
+			return false
+		}
+		saved = append(saved, item)
+		return true
+	}
+	if key != nil {
+		arti.view.saved.AscendGreaterOrEqual(&Item{key: key}, visit)
+	} else {
+		arti.view.saved.Ascend(visit)
+	}
+	arti.scanned = end
+
+	arti.values = mergeViewItems(items, saved, false)
+}
+
+// read the next batch of items before key (nil means the last one), key itself is skipped if not inclusive
+func (arti *artIterator) fillReverse(key []byte, inclusive bool) {
+	for arti.fillReverseBatch(key, inclusive); len(arti.values) == 0 && !arti.exhausted; arti.fillReverseBatch(arti.scanned, false) {
+	}
+}
+
+func (arti *artIterator) fillReverseBatch(key []byte, inclusive bool) {
+	arti.values = make([]*Item, 0, iteratorBatchSize)
+	arti.curIndex = 0
+	if arti.view == nil {
+		arti.exhausted = true
+		return
+	}
+	arti.art.lock.RLock()
+	defer arti.art.lock.RUnlock()
+
+	// the items of tree in the batch
+	items := make([]*Item, 0, iteratorBatchSize)
+	arti.art.descendFrom(key, inclusive, func(item *Item) bool {
+		if arti.lowerBound != nil && bytes.Compare(item.key, arti.lowerBound) < 0 {
+			return false
+		}
+		items = append(items, item)
+		return len(items) < iteratorBatchSize
+	})
+
+	// the saved items down to the first item of tree, or to the start
+	var end []byte
+	arti.exhausted = true
+	if len(items) == iteratorBatchSize {
+		end = items[len(items)-1].key
+		arti.exhausted = false
+	}
+	var saved []*Item
+	visit := func(it btree.Item) bool {
+		item := it.(*Item)
+		if key != nil {
+			if cmp := bytes.Compare(item.key, key); cmp > 0 || (cmp == 0 && !inclusive) {
+				return true
+			}
+		}
+		if end != nil && bytes.Compare(item.key, end) < 0 {
+			return false
+		}
+		saved = append(saved, item)
+		return true
+	}
+	if key != nil {
+		arti.view.saved.DescendLessOrEqual(&Item{key: key}, visit)
+	} else {
+		arti.view.saved.Descend(visit)
+	}
+	arti.scanned = end
+
+	arti.values = mergeViewItems(items, saved, true)
+}
+
+// merge the items of tree with the saved old ones in the order of iterator, the saved ones win,
+// and saved items of nil pos are the keys which did not exist
+func mergeViewItems(items []*Item, saved []*Item, reverse bool) []*Item {
+	before := func(a, b []byte) bool {
+		if reverse {
+			return bytes.Compare(a, b) > 0
+		}
+		return bytes.Compare(a, b) < 0
+	}
+	values := make([]*Item, 0, len(items)+len(saved))
+	for i, j := 0, 0; i < len(items) || j < len(saved); {
+		var item *Item
+		switch {
+		case j == len(saved) || (i < len(items) && before(items[i].key, saved[j].key)):
+			item = items[i]
+			i++
+		case i < len(items) && bytes.Equal(items[i].key, saved[j].key):
+			item = saved[j]
+			i++
+			j++
+		default:
+			item = saved[j]
+			j++
+		}
+		if item.pos != nil {
+			values = append(values, item)
+		}
+	}
+	return values
 }
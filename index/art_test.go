@@ -2,8 +2,13 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"fmt"
+	"math/rand"
+	"sort"
 	"testing"
 
+	goART "github.com/plar/go-adaptive-radix-tree"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -77,3 +82,253 @@ func TestAdaptiveRadixTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestAdaptiveRadixTree_Snapshot(t *testing.T) {
+	art := NewART()
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 1, Offset: 12})
+	art.Put([]byte("key-2"), &data.LogRecordPos{Fid: 1, Offset: 24})
+
+	snap, err := art.Snapshot()
+	assert.Nil(t, err)
+	art.Put([]byte("key-1"), &data.LogRecordPos{Fid: 2, Offset: 36})
+	art.Delete([]byte("key-2"))
+	art.Put([]byte("key-3"), &data.LogRecordPos{Fid: 2, Offset: 48})
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, int64(12), snap.Get([]byte("key-1")).Offset)
+	assert.NotNil(t, snap.Get([]byte("key-2")))
+	assert.Nil(t, snap.Get([]byte("key-3")))
+	assert.Nil(t, snap.Close())
+}
+
+func TestAdaptiveRadixTree_RangeIterator(t *testing.T) {
+	bt := NewART()
+	for _, key := range []string{"aaa", "bbb", "ccc", "ddd", "eee"} {
+		bt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 12})
+	}
+
+	collect := func(it Iterator) []string {
+		var keys []string
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		it.Close()
+		return keys
+	}
+
+	assert.Equal(t, []string{"bbb", "ccc"}, collect(bt.RangeIterator(false, []byte("bbb"), []byte("ddd"))))
+	assert.Equal(t, []string{"ccc", "bbb"}, collect(bt.RangeIterator(true, []byte("bbb"), []byte("ddd"))))
+	assert.Equal(t, []string{"ddd", "eee"}, collect(bt.RangeIterator(false, []byte("cz"), nil)))
+	assert.Equal(t, []string{"bbb", "aaa"}, collect(bt.RangeIterator(true, nil, []byte("c"))))
+	assert.Nil(t, collect(bt.RangeIterator(false, []byte("x"), nil)))
+
+	it := bt.RangeIterator(false, []byte("bbb"), []byte("ddd"))
+	it.Seek([]byte("bbz"))
+	assert.Equal(t, []byte("ccc"), it.Key())
+	it.Next()
+	assert.False(t, it.Valid())
+	it.Close()
+}
+
+func TestAdaptiveRadixTree_Iterator_Batches(t *testing.T) {
+	bt := NewART()
+	n := iteratorBatchSize*3 + 7
+	for i := 0; i < n; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// forward traversal goes through all the batches
+	var count int
+	iter := bt.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%05d", count)), iter.Key())
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, n, count)
+
+	// reverse traversal
+	count = 0
+	iter = bt.Iterator(true)
+	for iter.Seek([]byte("key-00200")); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%05d", 200-count)), iter.Key())
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, 201, count)
+
+	// iterators see the tree at the time they are created, like the btree ones
+	iter = bt.Iterator(false)
+	iter.Seek([]byte("key-00100"))
+	reverseIter := bt.Iterator(true)
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			bt.Delete([]byte(fmt.Sprintf("key-%05d", i)))
+		} else {
+			bt.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 2})
+			bt.Put([]byte(fmt.Sprintf("new-%05d", i)), &data.LogRecordPos{Fid: 2})
+		}
+	}
+	count = 0
+	for ; iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%05d", 100+count)), iter.Key())
+		assert.Equal(t, uint32(1), iter.Value().Fid)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, n-100, count)
+	count = 0
+	for ; reverseIter.Valid(); reverseIter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%05d", n-1-count)), reverseIter.Key())
+		assert.Equal(t, int64(n-1-count), reverseIter.Value().Offset)
+		count++
+	}
+	reverseIter.Close()
+	assert.Equal(t, n, count)
+
+	// new iterator sees the writes
+	var keys [][]byte
+	iter = bt.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, bytes.Compare(keys[i-1], keys[i]) < 0)
+	}
+	assert.Equal(t, bt.Size(), len(keys))
+	assert.True(t, bytes.HasPrefix(keys[len(keys)-1], []byte("new-")))
+	assert.Equal(t, 0, len(bt.views))
+}
+
+func TestAdaptiveRadixTree_Iterator_Concurrent(t *testing.T) {
+	art := NewART()
+	for i := 0; i < 1000; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1000; i < 2000; i++ {
+			art.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+		}
+	}()
+
+	var prev []byte
+	iter := art.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.True(t, bytes.Compare(prev, iter.Key()) < 0)
+		prev = iter.Key()
+	}
+	iter.Close()
+	<-done
+}
+
+func TestAdaptiveRadixTree_Iterator_Seek(t *testing.T) {
+	art := NewART()
+	rnd := rand.New(rand.NewSource(1))
+	var keys []string
+	// short keys of a few bytes, so that keys are prefixes of others and have 0x00 and 0xff
+	alphabet := []byte{0x00, 0x01, 'a', 'b', 0xfe, 0xff}
+	for len(keys) < 2000 {
+		key := make([]byte, 1+rnd.Intn(6))
+		for i := range key {
+			key[i] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		if art.Put(key, &data.LogRecordPos{Fid: 1}) == nil {
+			keys = append(keys, string(key))
+		}
+	}
+	sort.Strings(keys)
+
+	for i := 0; i < 200; i++ {
+		seek := make([]byte, rnd.Intn(6))
+		for j := range seek {
+			seek[j] = alphabet[rnd.Intn(len(alphabet))]
+		}
+		// the keys >= seek in order, and the keys <= seek in reverse order
+		start := sort.SearchStrings(keys, string(seek))
+		var expected []string
+		expected = append(expected, keys[start:]...)
+		var reverseExpected []string
+		end := start
+		if end < len(keys) && keys[end] == string(seek) {
+			end++
+		}
+		for j := end - 1; j >= 0; j-- {
+			reverseExpected = append(reverseExpected, keys[j])
+		}
+
+		for _, reverse := range []bool{false, true} {
+			var got []string
+			iter := art.Iterator(reverse)
+			for iter.Seek(seek); iter.Valid(); iter.Next() {
+				got = append(got, string(iter.Key()))
+			}
+			iter.Close()
+			if reverse {
+				assert.Equal(t, reverseExpected, got)
+			} else {
+				assert.Equal(t, expected, got)
+			}
+		}
+	}
+}
+
+// goART tree which counts the leaves walked
+type countingARTTree struct {
+	goART.Tree
+	walked int
+}
+
+func (c *countingARTTree) ForEach(callback goART.Callback, options ...int) {
+	c.Tree.ForEach(func(node goART.Node) bool {
+		c.walked++
+		return callback(node)
+	}, options...)
+}
+
+func (c *countingARTTree) ForEachPrefix(keyPrefix goART.Key, callback goART.Callback) {
+	c.Tree.ForEachPrefix(keyPrefix, func(node goART.Node) bool {
+		c.walked++
+		return callback(node)
+	})
+}
+
+func TestAdaptiveRadixTree_Iterator_Bounded(t *testing.T) {
+	tree := &countingARTTree{Tree: goART.New()}
+	art := NewART()
+	art.tree = tree
+	n := 100000
+	for i := 0; i < n; i++ {
+		art.Put([]byte(fmt.Sprintf("key-%06d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// Seek and the batches after it do not walk the keys before the seek key
+	for _, reverse := range []bool{false, true} {
+		tree.walked = 0
+		iter := art.Iterator(reverse)
+		assert.True(t, tree.walked < n/20)
+		tree.walked = 0
+		iter.Seek([]byte(fmt.Sprintf("key-%06d", n/2)))
+		for i := 0; i < iteratorBatchSize*4; i++ {
+			assert.True(t, iter.Valid())
+			iter.Next()
+		}
+		iter.Close()
+		assert.True(t, tree.walked < n/20)
+	}
+
+	// a full reverse walk reads every key a bounded number of times
+	tree.walked = 0
+	count := 0
+	iter := art.Iterator(true)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, int64(n-1-count), iter.Value().Offset)
+		count++
+	}
+	iter.Close()
+	assert.Equal(t, n, count)
+	assert.True(t, tree.walked < 3*n)
+}
@@ -2,23 +2,39 @@ package index
 
 import (
 	"bitcask-go/data"
+	"bytes"
+	"errors"
 	"path/filepath"
+	"sync"
 
 	"go.etcd.io/bbolt"
 )
 
 const bptreeIndexFileName = "bptree-index"
 
+// writers remap the bbolt file when it grows beyond the mmap size, which waits for the read transactions
+// of snapshots and iterators, so map a large size up front to keep them from blocking writers
+const bptreeInitialMmapSize = 256 * 1024 * 1024 // 256MB
+
 var indexBucketName = []byte("bitcask-index")
 
 type BPlusTree struct {
-	tree *bbolt.DB
+	tree      *bbolt.DB
+	lock      *sync.Mutex
+	snapshots map[*bptreeSnapshot]struct{} // snapshots not closed yet, their transactions are rolled back on Close
+	closed    bool
 }
 
 func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
+	return NewBPlusTreeWithFileName(dirPath, bptreeIndexFileName, syncWrites)
+}
+
+// bptree saved in the given file of dirPath, e.g. the index of a namespace
+func NewBPlusTreeWithFileName(dirPath string, fileName string, syncWrites bool) *BPlusTree {
 	opt := bbolt.DefaultOptions
 	opt.NoSync = !syncWrites
-	bptree, err := bbolt.Open(filepath.Join(dirPath, bptreeIndexFileName), 0644, opt)
+	opt.InitialMmapSize = bptreeInitialMmapSize
+	bptree, err := bbolt.Open(filepath.Join(dirPath, fileName), 0644, opt)
 	if err != nil {
 		panic("failed to open bptree")
 	}
@@ -32,7 +48,9 @@ func NewBPlusTree(dirPath string, syncWrites bool) *BPlusTree {
 	}
 
 	return &BPlusTree{
-		tree: bptree,
+		tree:      bptree,
+		lock:      new(sync.Mutex),
+		snapshots: make(map[*bptreeSnapshot]struct{}),
 	}
 }
 
@@ -99,30 +117,173 @@ func (bpt *BPlusTree) Size() int {
 }
 
 func (bpt *BPlusTree) Close() error {
+	bpt.lock.Lock()
+	bpt.closed = true
+	snapshots := bpt.snapshots
+	bpt.snapshots = make(map[*bptreeSnapshot]struct{})
+	bpt.lock.Unlock()
+
+	// bbolt waits for all read transactions on close, so roll back the snapshots not closed
+	for snap := range snapshots {
+		snap.rollback()
+	}
 	return bpt.tree.Close()
 }
 
-func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
-	return newBPlusTreeIterator(bpt.tree, reverse)
+// snapshot holds a bbolt read transaction, which costs O(1), until it is closed
+// writers wait for the transaction when the bbolt file grows beyond the mmap size, so close it soon
+func (bpt *BPlusTree) Snapshot() (Indexer, error) {
+	bpt.lock.Lock()
+	defer bpt.lock.Unlock()
+
+	if bpt.closed {
+		return nil, bbolt.ErrDatabaseNotOpen
+	}
+	tx, err := bpt.tree.Begin(false)
+	if err != nil {
+		return nil, err
+	}
+	snap := &bptreeSnapshot{
+		lock: new(sync.RWMutex),
+		bpt:  bpt,
+		tx:   tx,
+	}
+	bpt.snapshots[snap] = struct{}{}
+	return snap, nil
 }
 
-type bptreeIterator struct {
-	tx      *bbolt.Tx
-	cursor  *bbolt.Cursor
-	reverse bool
-	curKey  []byte
-	curVal  []byte
+func (bpt *BPlusTree) Iterator(reverse bool) Iterator {
+	return bpt.RangeIterator(reverse, nil, nil)
 }
 
-func newBPlusTreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
-	tx, err := tree.Begin(false)
+func (bpt *BPlusTree) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
+	tx, err := bpt.tree.Begin(false)
 	if err != nil {
 		panic("failed to begin a transaction in bptreeIterator")
 	}
+	return newBPlusTreeIterator(tx, reverse, lowerBound, upperBound, func() { _ = tx.Rollback() })
+}
+
+// read-only view of bptree in a bbolt read transaction
+type bptreeSnapshot struct {
+	lock      *sync.RWMutex
+	bpt       *BPlusTree
+	tx        *bbolt.Tx
+	iterators int  // iterators not closed, they keep tx after the snapshot is closed
+	released  bool // snapshot is closed
+	closed    bool // tx is rolled back, by Close of the snapshot and its iterators, or of bptree
+}
+
+// snapshot is read-only, put is ignored
+func (snap *bptreeSnapshot) Put(key []byte, pos *data.LogRecordPos) *data.LogRecordPos {
+	return nil
+}
+
+func (snap *bptreeSnapshot) Get(key []byte) *data.LogRecordPos {
+	snap.lock.RLock()
+	defer snap.lock.RUnlock()
+
+	if snap.released || snap.closed {
+		return nil
+	}
+	val := snap.tx.Bucket(indexBucketName).Get(key)
+	if len(val) == 0 {
+		return nil
+	}
+	return data.DeCodeLogRecordPos(val)
+}
+
+// snapshot is read-only, delete is ignored
+func (snap *bptreeSnapshot) Delete(key []byte) (*data.LogRecordPos, bool) {
+	return nil, false
+}
+
+func (snap *bptreeSnapshot) Iterator(reverse bool) Iterator {
+	return snap.RangeIterator(reverse, nil, nil)
+}
+
+// iterators share the transaction of snapshot, which is rolled back when all of them and the snapshot are closed
+func (snap *bptreeSnapshot) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+
+	if snap.released || snap.closed {
+		return NewBtree().RangeIterator(reverse, lowerBound, upperBound)
+	}
+	snap.iterators++
+	return newBPlusTreeIterator(snap.tx, reverse, lowerBound, upperBound, snap.closeIterator)
+}
+
+func (snap *bptreeSnapshot) Size() int {
+	snap.lock.RLock()
+	defer snap.lock.RUnlock()
+
+	if snap.released || snap.closed {
+		return 0
+	}
+	return snap.tx.Bucket(indexBucketName).Stats().KeyN
+}
+
+func (snap *bptreeSnapshot) Snapshot() (Indexer, error) {
+	return nil, errors.New("snapshot of bptree snapshot is not supported")
+}
+
+func (snap *bptreeSnapshot) Close() error {
+	snap.bpt.lock.Lock()
+	delete(snap.bpt.snapshots, snap)
+	snap.bpt.lock.Unlock()
+
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	snap.released = true
+	if snap.iterators == 0 {
+		snap.rollbackLocked()
+	}
+	return nil
+}
+
+func (snap *bptreeSnapshot) closeIterator() {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	snap.iterators--
+	if snap.released && snap.iterators == 0 {
+		snap.rollbackLocked()
+	}
+}
+
+// roll back the transaction, it can be called repeatedly
+func (snap *bptreeSnapshot) rollback() {
+	snap.lock.Lock()
+	defer snap.lock.Unlock()
+	snap.rollbackLocked()
+}
+
+func (snap *bptreeSnapshot) rollbackLocked() {
+	if snap.closed {
+		return
+	}
+	snap.closed = true
+	_ = snap.tx.Rollback()
+}
+
+// bptree iterator walks the cursor directly, so keys are never materialised
+type bptreeIterator struct {
+	release    func() // called on Close, it rolls back tx unless tx belongs to a snapshot with other users
+	cursor     *bbolt.Cursor
+	reverse    bool
+	lowerBound []byte
+	upperBound []byte
+	curKey     []byte
+	curVal     []byte
+}
+
+func newBPlusTreeIterator(tx *bbolt.Tx, reverse bool, lowerBound []byte, upperBound []byte, release func()) *bptreeIterator {
 	bpti := &bptreeIterator{
-		tx:      tx,
-		cursor:  tx.Bucket(indexBucketName).Cursor(),
-		reverse: reverse,
+		release:    release,
+		cursor:     tx.Bucket(indexBucketName).Cursor(),
+		reverse:    reverse,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
 	}
 	bpti.Rewind()
 
@@ -132,15 +293,46 @@ func newBPlusTreeIterator(tree *bbolt.DB, reverse bool) *bptreeIterator {
 // go back to the first data of iterator
 func (bpti *bptreeIterator) Rewind() {
 	if bpti.reverse {
-		bpti.curKey, bpti.curVal = bpti.cursor.Last()
+		if bpti.upperBound != nil {
+			bpti.seekReverse(bpti.upperBound, false)
+		} else {
+			bpti.curKey, bpti.curVal = bpti.cursor.Last()
+		}
 	} else {
-		bpti.curKey, bpti.curVal = bpti.cursor.First()
+		if bpti.lowerBound != nil {
+			bpti.curKey, bpti.curVal = bpti.cursor.Seek(bpti.lowerBound)
+		} else {
+			bpti.curKey, bpti.curVal = bpti.cursor.First()
+		}
 	}
 }
 
 // find the first target key which is >= or <= params-key, and start traversing from target key
 func (bpti *bptreeIterator) Seek(key []byte) {
+	if bpti.reverse {
+		if bpti.upperBound != nil && bytes.Compare(key, bpti.upperBound) >= 0 {
+			bpti.seekReverse(bpti.upperBound, false)
+		} else {
+			bpti.seekReverse(key, true)
+		}
+	} else {
+		if bpti.lowerBound != nil && bytes.Compare(key, bpti.lowerBound) < 0 {
+			key = bpti.lowerBound
+		}
+		bpti.curKey, bpti.curVal = bpti.cursor.Seek(key)
+	}
+}
+
+// move to the last key which is < key, or <= key if inclusive
+func (bpti *bptreeIterator) seekReverse(key []byte, inclusive bool) {
 	bpti.curKey, bpti.curVal = bpti.cursor.Seek(key)
+	if bpti.curKey == nil {
+		bpti.curKey, bpti.curVal = bpti.cursor.Last()
+		return
+	}
+	if cmp := bytes.Compare(bpti.curKey, key); cmp > 0 || (cmp == 0 && !inclusive) {
+		bpti.curKey, bpti.curVal = bpti.cursor.Prev()
+	}
 }
 
 // jump to the next key
@@ -154,7 +346,7 @@ func (bpti *bptreeIterator) Next() {
 
 // used to determine whether the traversal has been completed
 func (bpti *bptreeIterator) Valid() bool {
-	return len(bpti.curKey) != 0
+	return len(bpti.curKey) != 0 && inRange(bpti.curKey, bpti.lowerBound, bpti.upperBound)
 }
 
 // get key of current postion
@@ -169,5 +361,8 @@ func (bpti *bptreeIterator) Value() *data.LogRecordPos {
 
 // close iterator and release resources
 func (bpti *bptreeIterator) Close() {
-	_ = bpti.tx.Rollback()
+	if bpti.release != nil {
+		bpti.release()
+		bpti.release = nil
+	}
 }
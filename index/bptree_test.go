@@ -2,6 +2,7 @@ package index
 
 import (
 	"bitcask-go/data"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
@@ -110,3 +111,142 @@ func TestBPlusTree_Iterator(t *testing.T) {
 		assert.NotNil(t, iter.Value())
 	}
 }
+
+func TestBPlusTree_Snapshot(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-snapshot")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+	tree.Put([]byte("abc"), &data.LogRecordPos{Fid: 123, Offset: 888})
+
+	snap, err := tree.Snapshot()
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		tree.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 456, Offset: 111})
+	}
+	tree.Put([]byte("aac"), &data.LogRecordPos{Fid: 456, Offset: 111})
+	tree.Delete([]byte("abc"))
+
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, uint32(123), snap.Get([]byte("aac")).Fid)
+	assert.NotNil(t, snap.Get([]byte("abc")))
+
+	var keys [][]byte
+	iter := snap.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, [][]byte{[]byte("aac"), []byte("abc")}, keys)
+
+	assert.Nil(t, snap.Close())
+	assert.Equal(t, uint32(456), tree.Get([]byte("aac")).Fid)
+	assert.Nil(t, tree.Close())
+}
+
+func TestBPlusTree_Snapshot_Close(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-snapshot-close")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+	for i := 0; i < 100; i++ {
+		tree.Put([]byte(fmt.Sprintf("key-%04d", i)), &data.LogRecordPos{Fid: 123, Offset: int64(i)})
+	}
+
+	// case1: iterator keeps working after the snapshot is closed
+	snap, err := tree.Snapshot()
+	assert.Nil(t, err)
+	iter := snap.Iterator(false)
+	assert.Nil(t, snap.Close())
+	assert.Nil(t, snap.Get([]byte("key-0001")))
+	assert.Equal(t, 0, snap.Size())
+	var count int
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		count++
+	}
+	assert.Equal(t, 100, count)
+	iter.Close()
+	iter.Close()
+
+	// case2: iterator of closed snapshot is empty
+	iter = snap.Iterator(true)
+	iter.Rewind()
+	assert.False(t, iter.Valid())
+	iter.Close()
+
+	// case3: snapshot of snapshot is not supported
+	snap, err = tree.Snapshot()
+	assert.Nil(t, err)
+	_, err = snap.Snapshot()
+	assert.NotNil(t, err)
+
+	// case4: closing bptree does not wait for the snapshot not closed
+	assert.Nil(t, tree.Close())
+	assert.Nil(t, snap.Get([]byte("key-0001")))
+	assert.Nil(t, snap.Close())
+	_, err = tree.Snapshot()
+	assert.NotNil(t, err)
+}
+
+func TestBPlusTree_RangeIterator(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-range-iter")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+	tree := NewBPlusTree(path, false)
+	defer tree.Close()
+
+	for _, key := range []string{"aaa", "bbb", "ccc", "ddd", "eee"} {
+		tree.Put([]byte(key), &data.LogRecordPos{Fid: 123, Offset: 999})
+	}
+
+	collect := func(it Iterator) []string {
+		var keys []string
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		it.Close()
+		return keys
+	}
+
+	assert.Equal(t, []string{"bbb", "ccc"}, collect(tree.RangeIterator(false, []byte("bbb"), []byte("ddd"))))
+	assert.Equal(t, []string{"ccc", "bbb"}, collect(tree.RangeIterator(true, []byte("bbb"), []byte("ddd"))))
+	assert.Equal(t, []string{"ddd", "eee"}, collect(tree.RangeIterator(false, []byte("cz"), nil)))
+	assert.Equal(t, []string{"bbb", "aaa"}, collect(tree.RangeIterator(true, nil, []byte("c"))))
+	assert.Nil(t, collect(tree.RangeIterator(false, []byte("x"), nil)))
+
+	// seek in reverse goes to the last key <= target
+	it := tree.RangeIterator(true, nil, nil)
+	it.Seek([]byte("ccd"))
+	assert.Equal(t, []byte("ccc"), it.Key())
+	it.Seek([]byte("ccc"))
+	assert.Equal(t, []byte("ccc"), it.Key())
+	it.Seek([]byte("zzz"))
+	assert.Equal(t, []byte("eee"), it.Key())
+	it.Close()
+}
+
+func TestBPlusTree_WithFileName(t *testing.T) {
+	path := filepath.Join(os.TempDir(), "bptree-file-name")
+	_ = os.MkdirAll(path, os.ModePerm)
+	defer func() {
+		_ = os.RemoveAll(path)
+	}()
+
+	tree1 := NewBPlusTree(path, false)
+	tree2 := NewBPlusTreeWithFileName(path, "bptree-index-2", false)
+	tree1.Put([]byte("aac"), &data.LogRecordPos{Fid: 123, Offset: 999})
+
+	assert.NotNil(t, tree1.Get([]byte("aac")))
+	assert.Nil(t, tree2.Get([]byte("aac")))
+	assert.Equal(t, 0, tree2.Size())
+	assert.Nil(t, tree1.Close())
+	assert.Nil(t, tree2.Close())
+}
@@ -3,7 +3,6 @@ package index
 import (
 	"bitcask-go/data"
 	"bytes"
-	"sort"
 	"sync"
 
 	"github.com/google/btree"
@@ -66,70 +65,97 @@ func (bt *BTree) Size() int {
 }
 
 func (bt *BTree) Iterator(reverse bool) Iterator {
+	return bt.RangeIterator(reverse, nil, nil)
+}
+
+func (bt *BTree) RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator {
 	if bt == nil {
 		return nil
 	}
-	bt.lock.RLock()
-	defer bt.lock.RUnlock()
-	return NewBtreeIterator(bt.tree, reverse)
+	// iterate on a copy-on-write clone, so writes after creating the iterator are invisible to it
+	// btree.Clone should not be called concurrently with writes
+	bt.lock.Lock()
+	tree := bt.tree.Clone()
+	bt.lock.Unlock()
+	return newBtreeRangeIterator(tree, reverse, lowerBound, upperBound)
+}
+
+// lazy copy-on-write clone, cost O(1)
+func (bt *BTree) Snapshot() (Indexer, error) {
+	// btree.Clone should not be called concurrently with writes
+	bt.lock.Lock()
+	defer bt.lock.Unlock()
+	return &BTree{
+		tree: bt.tree.Clone(),
+		lock: new(sync.RWMutex),
+	}, nil
 }
 
 func (bt *BTree) Close() error {
 	return nil
 }
 
-// btree's index iterator
+// number of items an iterator reads from the tree each time
+const iteratorBatchSize = 128
+
+// btree's index iterator, walks the tree lazily, iteratorBatchSize items at a time
 type btreeIterator struct {
-	curIndex int     // current index number
-	reverse  bool    // support reverse traversal
-	values   []*Item // snapshot for the index, one Item includes key + pos
+	tree       *btree.BTree
+	reverse    bool    // support reverse traversal
+	lowerBound []byte  // inclusive, nil means unbounded
+	upperBound []byte  // exclusive, nil means unbounded
+	values     []*Item // current batch of items, one Item includes key + pos
+	curIndex   int     // current index number in values
+	exhausted  bool    // no more items after values
 }
 
-// new btree iterator
+// new btree iterator, the tree should not be modified during iteration
 func NewBtreeIterator(tree *btree.BTree, reverse bool) *btreeIterator {
-	var idx int
-	values := make([]*Item, tree.Len())
-
-	saveValues := func(it btree.Item) bool {
-		values[idx] = it.(*Item)
-		idx++
-		return true
-	}
-
-	if reverse {
-		tree.Descend(saveValues)
-	} else {
-		tree.Ascend(saveValues)
-	}
+	return newBtreeRangeIterator(tree, reverse, nil, nil)
+}
 
-	return &btreeIterator{
-		curIndex: 0,
-		reverse:  reverse,
-		values:   values,
+func newBtreeRangeIterator(tree *btree.BTree, reverse bool, lowerBound []byte, upperBound []byte) *btreeIterator {
+	bti := &btreeIterator{
+		tree:       tree,
+		reverse:    reverse,
+		lowerBound: lowerBound,
+		upperBound: upperBound,
 	}
+	bti.Rewind()
+	return bti
 }
 
 // go back to the first data of iterator
 func (bti *btreeIterator) Rewind() {
-	bti.curIndex = 0
+	if bti.reverse {
+		bti.fill(bti.upperBound, false)
+	} else {
+		bti.fill(bti.lowerBound, true)
+	}
 }
 
 // find the first target key which is >= or <=(reverse) params-key, and start traversing from target key
 func (bti *btreeIterator) Seek(key []byte) {
 	if bti.reverse {
-		bti.curIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) <= 0
-		})
+		if bti.upperBound != nil && bytes.Compare(key, bti.upperBound) >= 0 {
+			bti.fill(bti.upperBound, false)
+		} else {
+			bti.fill(key, true)
+		}
 	} else {
-		bti.curIndex = sort.Search(len(bti.values), func(i int) bool {
-			return bytes.Compare(bti.values[i].key, key) >= 0
-		})
+		if bti.lowerBound != nil && bytes.Compare(key, bti.lowerBound) < 0 {
+			key = bti.lowerBound
+		}
+		bti.fill(key, true)
 	}
 }
 
 // jump to the next key
 func (bti *btreeIterator) Next() {
 	bti.curIndex += 1
+	if bti.curIndex == len(bti.values) && !bti.exhausted {
+		bti.fill(bti.values[bti.curIndex-1].key, false)
+	}
 }
 
 // used to determine whether the traversal has been completed
@@ -149,5 +175,43 @@ func (bti *btreeIterator) Value() *data.LogRecordPos {
 
 // close iterator and release resources
 func (bti *btreeIterator) Close() {
+	bti.tree = nil
 	bti.values = nil
+	bti.exhausted = true
+}
+
+// read the next batch of items starting from key (nil means the first one of traversal),
+// key itself is skipped if not inclusive
+func (bti *btreeIterator) fill(key []byte, inclusive bool) {
+	bti.values = make([]*Item, 0, iteratorBatchSize)
+	bti.curIndex = 0
+	bti.exhausted = true
+
+	saveValues := func(it btree.Item) bool {
+		item := it.(*Item)
+		if !inclusive && bytes.Equal(item.key, key) {
+			return true
+		}
+		if !inRange(item.key, bti.lowerBound, bti.upperBound) {
+			// upperBound itself is the first key of reverse traversal
+			return bti.reverse && bytes.Equal(item.key, bti.upperBound)
+		}
+		if len(bti.values) == iteratorBatchSize {
+			bti.exhausted = false
+			return false
+		}
+		bti.values = append(bti.values, item)
+		return true
+	}
+
+	switch {
+	case bti.reverse && key != nil:
+		bti.tree.DescendLessOrEqual(&Item{key: key}, saveValues)
+	case bti.reverse:
+		bti.tree.Descend(saveValues)
+	case key != nil:
+		bti.tree.AscendGreaterOrEqual(&Item{key: key}, saveValues)
+	default:
+		bti.tree.Ascend(saveValues)
+	}
 }
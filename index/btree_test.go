@@ -2,6 +2,7 @@ package index
 
 import (
 	"bitcask-go/data"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -102,3 +103,100 @@ func TestBtree_Iterator(t *testing.T) {
 		assert.NotNil(t, it6.Key())
 	}
 }
+
+func TestBTree_Snapshot(t *testing.T) {
+	bt := NewBtree()
+	bt.Put([]byte("aaa"), &data.LogRecordPos{Fid: 1, Offset: 10})
+	bt.Put([]byte("bbb"), &data.LogRecordPos{Fid: 1, Offset: 20})
+
+	snap, err := bt.Snapshot()
+	assert.Nil(t, err)
+	bt.Put([]byte("aaa"), &data.LogRecordPos{Fid: 2, Offset: 30})
+	bt.Delete([]byte("bbb"))
+	bt.Put([]byte("ccc"), &data.LogRecordPos{Fid: 2, Offset: 40})
+
+	// snapshot is not affected by writes after it
+	assert.Equal(t, 2, snap.Size())
+	assert.Equal(t, int64(10), snap.Get([]byte("aaa")).Offset)
+	assert.NotNil(t, snap.Get([]byte("bbb")))
+	assert.Nil(t, snap.Get([]byte("ccc")))
+
+	// writes to snapshot do not affect the origin tree
+	snap.Put([]byte("ddd"), &data.LogRecordPos{Fid: 3, Offset: 50})
+	assert.Nil(t, bt.Get([]byte("ddd")))
+	assert.Equal(t, int64(30), bt.Get([]byte("aaa")).Offset)
+	assert.Nil(t, snap.Close())
+}
+
+func TestBTree_RangeIterator(t *testing.T) {
+	bt := NewBtree()
+	for _, key := range []string{"aaa", "bbb", "ccc", "ddd", "eee"} {
+		bt.Put([]byte(key), &data.LogRecordPos{Fid: 1, Offset: 12})
+	}
+
+	collect := func(it Iterator) []string {
+		var keys []string
+		for it.Rewind(); it.Valid(); it.Next() {
+			keys = append(keys, string(it.Key()))
+		}
+		it.Close()
+		return keys
+	}
+
+	assert.Equal(t, []string{"bbb", "ccc"}, collect(bt.RangeIterator(false, []byte("bbb"), []byte("ddd"))))
+	assert.Equal(t, []string{"ccc", "bbb"}, collect(bt.RangeIterator(true, []byte("bbb"), []byte("ddd"))))
+	assert.Equal(t, []string{"ddd", "eee"}, collect(bt.RangeIterator(false, []byte("cz"), nil)))
+	assert.Equal(t, []string{"bbb", "aaa"}, collect(bt.RangeIterator(true, nil, []byte("c"))))
+	assert.Nil(t, collect(bt.RangeIterator(false, []byte("x"), nil)))
+
+	it := bt.RangeIterator(false, []byte("bbb"), []byte("ddd"))
+	it.Seek([]byte("bbz"))
+	assert.Equal(t, []byte("ccc"), it.Key())
+	it.Next()
+	assert.False(t, it.Valid())
+	it.Close()
+}
+
+func TestBTree_Iterator_Batches(t *testing.T) {
+	bt := NewBtree()
+	n := iteratorBatchSize*3 + 7
+	for i := 0; i < n; i++ {
+		bt.Put([]byte(fmt.Sprintf("key-%05d", i)), &data.LogRecordPos{Fid: 1, Offset: int64(i)})
+	}
+
+	// forward traversal goes through all the batches
+	var count int
+	iter := bt.Iterator(false)
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%05d", count)), iter.Key())
+		count++
+	}
+	assert.Equal(t, n, count)
+
+	// reverse traversal
+	count = 0
+	iter = bt.Iterator(true)
+	for iter.Seek([]byte("key-00200")); iter.Valid(); iter.Next() {
+		assert.Equal(t, []byte(fmt.Sprintf("key-%05d", 200-count)), iter.Key())
+		count++
+	}
+	assert.Equal(t, 201, count)
+
+	// writes after creating the iterator are invisible
+	iter = bt.Iterator(false)
+	iter.Seek([]byte("key-00100"))
+	for i := 0; i < n; i++ {
+		if i%2 == 0 {
+			bt.Delete([]byte(fmt.Sprintf("key-%05d", i)))
+		} else {
+			bt.Put([]byte(fmt.Sprintf("new-%05d", i)), &data.LogRecordPos{Fid: 2})
+		}
+	}
+	var keys [][]byte
+	for ; iter.Valid(); iter.Next() {
+		keys = append(keys, iter.Key())
+	}
+	iter.Close()
+	assert.Equal(t, n-100, len(keys))
+	assert.Equal(t, []byte("key-00100"), keys[0])
+}
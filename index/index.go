@@ -13,7 +13,9 @@ type Indexer interface {
 	Get(key []byte) *data.LogRecordPos
 	Delete(key []byte) (*data.LogRecordPos, bool)
 	Iterator(reverse bool) Iterator
+	RangeIterator(reverse bool, lowerBound []byte, upperBound []byte) Iterator // keys in [lowerBound, upperBound), nil bound means unbounded
 	Size() int
+	Snapshot() (Indexer, error) // get a read-only point-in-time view of the index, Close it after use
 	Close() error
 }
 
@@ -64,3 +66,14 @@ type Iterator interface {
 	Value() *data.LogRecordPos // get value of current positon
 	Close()                    // close iterator and release resources
 }
+
+// whether key is in [lowerBound, upperBound), nil bound means unbounded
+func inRange(key []byte, lowerBound []byte, upperBound []byte) bool {
+	if lowerBound != nil && bytes.Compare(key, lowerBound) < 0 {
+		return false
+	}
+	if upperBound != nil && bytes.Compare(key, upperBound) >= 0 {
+		return false
+	}
+	return true
+}
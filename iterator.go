@@ -10,31 +10,65 @@ type Iterator struct {
 	indexIter index.Iterator
 	db        *DB
 	opts      IteratorOptions
+	blobFids  []uint32 // blob files pinned by the iterator
+	err       error    // why the iterator is closed when it is created
+	closed    bool
 }
 
 // initialize iterator
 func (db *DB) NewIterator(opts IteratorOptions) *Iterator {
-	indexIter := db.index.Iterator(opts.Reverse)
-	return &Iterator{
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.newIterator(db.index, opts)
+}
+
+// iterator over the given index, which may be db.index or a snapshot of it, under db.mu.Lock
+// the blob files are pinned, as the index iterator may keep the positions of blobs moved by CompactBlobs
+// iterator of closed db is empty, and db.Close waits for the others to be closed
+func (db *DB) newIterator(idx index.Indexer, opts IteratorOptions) *Iterator {
+	if db.closed {
+		return closedIterator(db, opts, ErrDBClosed)
+	}
+	db.inflight.Add(1)
+
+	lowerBound, upperBound := opts.bounds()
+	indexIter := idx.RangeIterator(opts.Reverse, lowerBound, upperBound)
+	iterator := &Iterator{
 		indexIter: indexIter,
 		db:        db,
 		opts:      opts,
+		blobFids:  db.pinBlobFiles(),
 	}
+	iterator.skipExpired()
+	return iterator
+}
+
+// empty iterator which is already closed, err is returned by Err
+func closedIterator(db *DB, opts IteratorOptions, err error) *Iterator {
+	return &Iterator{indexIter: index.NewBtree().Iterator(false), db: db, opts: opts, err: err, closed: true}
+}
+
+// error of the iterator which is created closed, e.g. ErrDBClosed or ErrSnapshotReleased, nil for the others
+func (it *Iterator) Err() error {
+	return it.err
 }
 
 // go back to the first data of iterator
 func (it *Iterator) Rewind() {
 	it.indexIter.Rewind()
+	it.skipExpired()
 }
 
 // find the first target key which is >= or <=(reverse) params-key, and start traversing from target key
 func (it *Iterator) Seek(key []byte) {
 	it.indexIter.Seek(key)
+	it.skipExpired()
 }
 
 // jump to the next key
 func (it *Iterator) Next() {
 	it.indexIter.Next()
+	it.skipExpired()
 }
 
 // used to determine whether the traversal has been completed
@@ -49,28 +83,61 @@ func (it *Iterator) Key() []byte {
 
 // get value of current positon
 func (it *Iterator) Value() ([]byte, error) {
-	lr := it.indexIter.Value()
+	if it.opts.KeysOnly {
+		return nil, ErrIteratorKeysOnly
+	}
 	it.db.mu.RLock()
 	defer it.db.mu.RUnlock()
-	return it.db.getValueByPosition(lr)
+	if it.db.closed {
+		return nil, ErrDBClosed
+	}
+	return it.db.getValueByPosition(it.indexIter.Value())
 }
 
-// close iterator and release resources
+// close iterator and release resources, it can be called repeatedly
 func (it *Iterator) Close() {
-	it.indexIter.Close()
-}
-
-func (it *Iterator) skipToNext() {
-	prefixLen := len(it.opts.Prefix)
-	// if prefix = nil , return
-	if prefixLen == 0 {
+	if it.closed {
 		return
 	}
+	it.closed = true
+	it.indexIter.Close()
+	if len(it.blobFids) > 0 {
+		it.db.mu.Lock()
+		it.db.unpinBlobFiles(it.blobFids)
+		it.db.mu.Unlock()
+	}
+	it.db.inflight.Done()
+}
 
-	for ; it.indexIter.Valid(); it.Next() {
-		key := it.indexIter.Key()
-		if prefixLen <= len(key) && bytes.Compare(it.opts.Prefix, key[:prefixLen]) == 0 {
+// expired keys are invisible to users
+func (it *Iterator) skipExpired() {
+	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if !it.indexIter.Value().IsExpired() {
 			break
 		}
 	}
 }
+
+// merge Prefix into LowerBound and UpperBound, the tighter bound wins
+func (opts IteratorOptions) bounds() ([]byte, []byte) {
+	lowerBound, upperBound := opts.LowerBound, opts.UpperBound
+	if len(opts.Prefix) == 0 {
+		return lowerBound, upperBound
+	}
+	if lowerBound == nil || bytes.Compare(opts.Prefix, lowerBound) > 0 {
+		lowerBound = opts.Prefix
+	}
+	if end := prefixEnd(opts.Prefix); end != nil && (upperBound == nil || bytes.Compare(end, upperBound) < 0) {
+		upperBound = end
+	}
+	return lowerBound, upperBound
+}
+
+// whether key is in the range of iterator options
+func (opts IteratorOptions) contains(key []byte) bool {
+	lowerBound, upperBound := opts.bounds()
+	if lowerBound != nil && bytes.Compare(key, lowerBound) < 0 {
+		return false
+	}
+	return upperBound == nil || bytes.Compare(key, upperBound) < 0
+}
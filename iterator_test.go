@@ -96,3 +96,61 @@ func TestDB_Iterator_Multi_Values(t *testing.T) {
 	}
 	it3.Close()
 }
+
+func TestDB_Iterator_Bounds(t *testing.T) {
+	for _, typ := range []IndexerType{Btree, ARtree, BPtree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-iterator-bounds")
+		opts.DirPath = dir
+		opts.IndexType = typ
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for _, key := range []string{"aaa", "bba", "bbb", "bbc", "bc", "ccc", "ddd"} {
+			assert.Nil(t, db.Put([]byte(key), []byte(key)))
+		}
+
+		collect := func(itOpts IteratorOptions, seek []byte) []string {
+			it := db.NewIterator(itOpts)
+			defer it.Close()
+			var keys []string
+			if seek != nil {
+				it.Seek(seek)
+			}
+			for ; it.Valid(); it.Next() {
+				keys = append(keys, string(it.Key()))
+			}
+			return keys
+		}
+
+		// case1: prefix
+		assert.Equal(t, []string{"bba", "bbb", "bbc"}, collect(IteratorOptions{Prefix: []byte("bb")}, nil))
+		assert.Equal(t, []string{"bbc", "bbb", "bba"}, collect(IteratorOptions{Prefix: []byte("bb"), Reverse: true}, nil))
+		assert.Nil(t, collect(IteratorOptions{Prefix: []byte("x")}, nil))
+
+		// case2: lower bound and upper bound
+		assert.Equal(t, []string{"bbb", "bbc", "bc"}, collect(IteratorOptions{LowerBound: []byte("bbb"), UpperBound: []byte("ccc")}, nil))
+		assert.Equal(t, []string{"bc", "bbc", "bbb"}, collect(IteratorOptions{LowerBound: []byte("bbb"), UpperBound: []byte("ccc"), Reverse: true}, nil))
+		assert.Equal(t, []string{"ccc", "ddd"}, collect(IteratorOptions{LowerBound: []byte("c")}, nil))
+		assert.Equal(t, []string{"aaa"}, collect(IteratorOptions{UpperBound: []byte("bba")}, nil))
+
+		// case3: prefix with bounds
+		assert.Equal(t, []string{"bbb"}, collect(IteratorOptions{Prefix: []byte("bb"), LowerBound: []byte("bbb"), UpperBound: []byte("bbc")}, nil))
+
+		// case4: seek is limited by bounds
+		assert.Equal(t, []string{"bba", "bbb", "bbc"}, collect(IteratorOptions{Prefix: []byte("bb")}, []byte("a")))
+		assert.Equal(t, []string{"bbb", "bbc"}, collect(IteratorOptions{Prefix: []byte("bb")}, []byte("bbaz")))
+		assert.Equal(t, []string{"bbc", "bbb", "bba"}, collect(IteratorOptions{Prefix: []byte("bb"), Reverse: true}, []byte("z")))
+		assert.Equal(t, []string{"bba"}, collect(IteratorOptions{Prefix: []byte("bb"), Reverse: true}, []byte("bbaz")))
+
+		// case5: keys only
+		it := db.NewIterator(IteratorOptions{KeysOnly: true})
+		assert.Equal(t, []byte("aaa"), it.Key())
+		_, err = it.Value()
+		assert.Equal(t, ErrIteratorKeysOnly, err)
+		it.Close()
+
+		destroyDB(db)
+	}
+}
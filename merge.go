@@ -3,6 +3,7 @@ package bitcaskminidb
 import (
 	"bitcask-go/data"
 	"bitcask-go/utils"
+	"context"
 	"fmt"
 	"io"
 	"os"
@@ -14,14 +15,34 @@ import (
 const (
 	MergeDirSuffix       = "-merge"
 	MergeFinishedFileKey = "merge.finished"
+
+	// saved in merge-finished-file after MergeFinishedFileKey, for ChangesSince
+	mergeCommitSeqNoKey  = "commit.seqno"
+	mergeChangesFloorKey = "changes.floor"
 )
 
-func (db *DB) Merge() error {
+// compact data files, rewrite valid records into new files and generate hint file
+func (db *DB) Compact() error {
+	return db.CompactContext(context.Background())
+}
+
+// compact data files, stop when ctx is done, and the unfinished merge dir is removed
+func (db *DB) CompactContext(ctx context.Context) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	db.mu.Lock()
+
+	if db.closed {
+		db.mu.Unlock()
+		return ErrDBClosed
+	}
+
 	// if db.activeFile = nil
 	if db.activeFile == nil {
+		db.mu.Unlock()
 		return nil
 	}
-	db.mu.Lock()
 
 	// if db is merging
 	if db.isMerging {
@@ -29,14 +50,19 @@ func (db *DB) Merge() error {
 		return ErrMergeIsInProgress
 	}
 
+	// expired keys are invalid, which will be dropped during merge
+	db.removeExpiredKeys()
+
 	// calc merge radio
 	totalSize, err := utils.DirSize(db.options.DirPath)
 	if err != nil {
 		db.mu.Unlock()
 		return err
 	}
+	// blob files are not rewritten by merge, see CompactBlobs
+	totalSize -= db.blobFilesSize()
 	curRatio := float32(db.reclaimSize) / float32(totalSize)
-	if curRatio < db.options.DataFileMergeRatio {
+	if curRatio < db.options.DataFileMergeRatio && !db.hasStaleEncryption() {
 		db.mu.Unlock()
 		return ErrMergeRatioUnreached
 	}
@@ -55,6 +81,9 @@ func (db *DB) Merge() error {
 	// start merging, set db.isMerge = true
 	db.isMerging = true
 	defer func() { db.isMerging = false }()
+	// db.Close waits for merging
+	db.inflight.Add(1)
+	defer db.inflight.Done()
 
 	// e.g. to merge file 0 1 2, file-2 is active, we need to create the new active file3
 	// step 1. sync current active file
@@ -72,6 +101,13 @@ func (db *DB) Merge() error {
 
 	nonMergeFileId := db.activeFile.FileId // for merge-finished-file
 
+	// records in merge files are committed before mergeCommitSeqNo,
+	// and the invalid ones after changesFloor are kept for ChangesSince
+	mergeCommitSeqNo := db.commitSeqNo
+	changesFloor := db.changesRetentionFloor()
+	// old versions inside the retention window are kept as well
+	versions := db.retainedVersions(nonMergeFileId)
+
 	// get mergeList
 	var mergeFiles []*data.DataFile
 	for _, file := range db.olderFiles {
@@ -97,49 +133,146 @@ func (db *DB) Merge() error {
 	if err := os.MkdirAll(mergePath, os.ModePerm); err != nil {
 		return err
 	}
+	// merge files belong to this db
+	if err := writeDBId(mergePath, db.fileOptions.DBId); err != nil {
+		_ = os.RemoveAll(mergePath)
+		return err
+	}
 	// create temp merge-DB-instance to merge
 	mergeOptions := db.options
 	mergeOptions.DirPath = mergePath
 	mergeOptions.SyncWrites = false // if before completed, merge crashed ..., we can sync after merge
+	mergeOptions.ValueThreshold = 0 // values in blob files are not moved, and the others stay in data files
 	mergeDB, err := Open(mergeOptions)
 	if err != nil {
+		_ = os.RemoveAll(mergePath)
 		return err
 	}
 
 	// open hint file to store valid index
-	hintFile, err := data.OpenHintFile(mergePath)
+	hintFile, err := data.OpenHintFile(mergePath, db.fileOptions)
 	if err != nil {
+		_ = mergeDB.Close()
+		_ = os.RemoveAll(mergePath)
+		return err
+	}
+
+	// merge files without merge-finished-file are never loaded, remove them when merge stops
+	abort := func(err error) error {
+		_ = hintFile.Close()
+		_ = mergeDB.Close()
+		_ = os.RemoveAll(mergePath)
+		return err
+	}
+
+	// invalid records of write batch kept for ChangesSince, they are written after the batch is finished
+	retainedTxnRecords := make(map[uint64][]*retainedRecord)
+
+	// rewrite a kept invalid record, and save it in hint file if it is a retained version
+	writeRetained := func(r *retainedRecord) error {
+		pos, err := mergeDB.AppendLogRecordWithLock(r.record)
+		if err != nil {
+			return err
+		}
+		if r.version {
+			return hintFile.WriteHintRecord(data.LogRecordHistory, r.key, pos, r.record)
+		}
 		return nil
 	}
 
 	// then we need to open the mergeFiles, traversal the log records, and rewrite the valid records
 	for _, dataFile := range mergeFiles {
+		fileSize, err := dataFile.IOManager.Size()
+		if err != nil {
+			return abort(err)
+		}
 		var offset int64 = 0
 		for {
-			lr, size, err := dataFile.ReadLogRecord(offset)
+			if err := ctx.Err(); err != nil {
+				return abort(err)
+			}
+
+			// corrupted data is skipped, its records are dropped as they are by Options.Recovery
+			lr, recordOffset, size, err := readValidRecord(dataFile, offset, fileSize)
 			if err != nil {
 				if err == io.EOF { // finish reading current dataFile
 					break
 				}
-				return err
+				return abort(err)
 			}
+			offset = recordOffset
 			// parse log record - key, and get the real key
-			realKey, _ := parseLogRecordKey(lr.Key)
-			// get log record pos and compare
-			lrPos := db.index.Get(realKey)
+			realKey, txnSeqNo := parseLogRecordKey(lr.Key)
+			// get log record pos and compare, records of dropped namespace are invalid
+			db.mu.RLock()
+			var lrPos *data.LogRecordPos
+			if idx := db.indexOf(lr.Namespace); idx != nil {
+				lrPos = mergedLogRecordPos(idx.Get(realKey), nonMergeFileId)
+			}
+			db.mu.RUnlock()
+			version := versions.positions[recordPosition{fid: dataFile.FileId, offset: offset}]
+			keepChange := lr.Namespace == defaultNamespaceId && lr.SeqNo > changesFloor
 			// as the valid pos in data file is the same as the one in index, so compare fileid and offset
 			// if same, then record is valid
+			// range tombstones are never in index, they are dropped with the keys they cover
 			if lrPos != nil && lrPos.Fid == dataFile.FileId && lrPos.Offset == offset {
+				// collapse the chain of merge operands into a full value
+				if lr.Type == data.LogRecordMerge {
+					db.mu.RLock()
+					value, err := db.getValueByPosition(lrPos)
+					db.mu.RUnlock()
+					if err != nil {
+						return abort(err)
+					}
+					lr.Value = value
+					lr.Type = data.LogRecordNormal
+				}
+				// the moved pos is the only one of the version after merge
+				hintType := data.LogRecordNormal
+				if isBlobRecord(lr.Type) {
+					lr.Type = data.LogRecordBlob
+					hintType = data.LogRecordBlob
+				}
 				// clean the seqNo (if has), save space overhead
 				lr.Key = logRecordKeyWithSeq(realKey, noTransactionSeqNo)
 				// add the valid record to mergeDB
 				pos, err := mergeDB.AppendLogRecordWithLock(lr)
 				if err != nil {
-					return err
+					return abort(err)
 				}
 				// add realKey & lrPos record to hint file
-				if err := hintFile.WriteHintRecord(realKey, pos); err != nil {
-					return err
+				if err := hintFile.WriteHintRecord(hintType, realKey, pos, lr); err != nil {
+					return abort(err)
+				}
+			} else if version != nil || keepChange {
+				// invalid record inside the retention window of changes or versions
+				if version != nil && lr.Type == data.LogRecordMerge {
+					// the chain of operands is dropped, so keep the full value of version
+					db.mu.RLock()
+					value, err := db.getValueByPosition(version.pos)
+					db.mu.RUnlock()
+					if err != nil {
+						return abort(err)
+					}
+					lr.Value = value
+					lr.Type = data.LogRecordNormal
+				}
+				if lr.Type == data.LogRecordTxnFinish {
+					for _, r := range retainedTxnRecords[txnSeqNo] {
+						if err := writeRetained(r); err != nil {
+							return abort(err)
+						}
+					}
+					delete(retainedTxnRecords, txnSeqNo)
+				} else {
+					lr.Key = logRecordKeyWithSeq(realKey, noTransactionSeqNo)
+					r := &retainedRecord{record: lr, key: realKey, version: version != nil}
+					// records of batch are kept for changes after the batch is finished
+					if txnSeqNo != noTransactionSeqNo && keepChange {
+						retainedTxnRecords[txnSeqNo] = append(retainedTxnRecords[txnSeqNo], r)
+					} else if err := writeRetained(r); err != nil {
+						return abort(err)
+					}
 				}
 			}
 
@@ -147,19 +280,29 @@ func (db *DB) Merge() error {
 		}
 	}
 
+	// retained deletions are only saved in hint file
+	for key, deleted := range versions.deleted {
+		for _, v := range deleted {
+			lr := &data.LogRecord{SeqNo: v.seqNo, Timestamp: v.timestamp}
+			if err := hintFile.WriteHintRecord(data.LogRecordHistory, []byte(key), nil, lr); err != nil {
+				return abort(err)
+			}
+		}
+	}
+
 	// sync
 	if err := hintFile.Sync(); err != nil {
-		return err
+		return abort(err)
 	}
 
 	if err := mergeDB.Sync(); err != nil {
-		return err
+		return abort(err)
 	}
 
 	// new merge-finished-flag-file
-	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath)
+	mergeFinishedFile, err := data.OpenMergeFinishedFile(mergePath, nil)
 	if err != nil {
-		return nil
+		return abort(err)
 	}
 
 	// write the finish lr to merge-finished-file, to mark the merged files
@@ -172,16 +315,43 @@ func (db *DB) Merge() error {
 	// the mergeFinishedFile only have one log record to record nonMergeFileId,
 	// that will be used in loadMergeFiles when setting up db
 	if err := mergeFinishedFile.Write(encMergeFinishedLogRecord); err != nil {
-		return err
+		return abort(err)
+	}
+
+	// then the seqNos for ChangesSince
+	for _, record := range []*data.LogRecord{
+		{Key: []byte(mergeCommitSeqNoKey), Value: []byte(strconv.FormatUint(mergeCommitSeqNo, 10))},
+		{Key: []byte(mergeChangesFloorKey), Value: []byte(strconv.FormatUint(changesFloor, 10))},
+	} {
+		encRecord, _ := data.EncodeLogRecord(record)
+		if err := mergeFinishedFile.Write(encRecord); err != nil {
+			return abort(err)
+		}
 	}
 
 	if err := mergeFinishedFile.Sync(); err != nil {
-		return err
+		return abort(err)
 	}
 
 	return nil
 }
 
+// invalid record kept by merge, version means it is a retained version of key
+type retainedRecord struct {
+	record  *data.LogRecord
+	key     []byte
+	version bool
+}
+
+// the newest record of key in merge files, that is the head of index pos,
+// or an operand/value in the chain of merge operands if the head is written after merge starts
+func mergedLogRecordPos(pos *data.LogRecordPos, nonMergeFileId uint32) *data.LogRecordPos {
+	for pos != nil && pos.Fid >= nonMergeFileId {
+		pos = pos.Prev
+	}
+	return pos
+}
+
 // merge dir level e.g. /tmp/bitcask VS /tmp/bitcask-merge
 func (db *DB) getMergePath() string {
 	//fmt.Printf(db.options.DirPath + "\n")
@@ -219,10 +389,10 @@ func (db *DB) loadMergeFiles() error {
 		if entry.Name() == data.MergeFinishedFileName {
 			mergeFinished = true
 		}
-		if entry.Name() == data.SeqNoFileName {
+		if entry.Name() == data.SeqNoFileName || entry.Name() == data.DBIdFileName {
 			continue
 		}
-		if entry.Name() == fileLockName {
+		if entry.Name() == fileLockName || entry.Name() == readerLockName {
 			continue
 		}
 		mergeFileNames = append(mergeFileNames, entry.Name())
@@ -265,10 +435,15 @@ func (db *DB) loadMergeFiles() error {
 }
 
 func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
-	mergeFinishFile, err := data.OpenMergeFinishedFile(dirPath)
+	// opening would create the file
+	if _, err := os.Stat(filepath.Join(dirPath, data.MergeFinishedFileName)); err != nil {
+		return 0, nil
+	}
+	mergeFinishFile, err := data.OpenMergeFinishedFile(dirPath, db.fileOptions)
 	if err != nil {
 		return 0, nil
 	}
+	defer mergeFinishFile.Close()
 
 	// because only one log record, offset = 0
 	record, _, err := mergeFinishFile.ReadLogRecord(0)
@@ -285,8 +460,8 @@ func (db *DB) getNonMergeFileId(dirPath string) (uint32, error) {
 	return uint32(nonMergeFileId), nil
 }
 
-// load index from hint file
-func (db *DB) loadIndexFromHintFile() error {
+// load index from hint file, check ctx between records
+func (db *DB) loadIndexFromHintFile(ctx context.Context) error {
 	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
 	// if hint file is not exitst, return nil
 	if _, err := os.Stat(hintFileName); os.IsNotExist(err) {
@@ -294,7 +469,7 @@ func (db *DB) loadIndexFromHintFile() error {
 	}
 
 	// open hint file
-	hintFile, err := data.OpenHintFile(hintFileName)
+	hintFile, err := data.OpenHintFile(db.options.DirPath, db.fileOptions)
 	if err != nil {
 		return err
 	}
@@ -302,6 +477,10 @@ func (db *DB) loadIndexFromHintFile() error {
 	// load index
 	var offset int64 = 0
 	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		logRecord, size, err := hintFile.ReadLogRecord(offset)
 
 		// err happens, include to the end of the file
@@ -312,10 +491,44 @@ func (db *DB) loadIndexFromHintFile() error {
 			return err
 		}
 
+		// old version kept by merge
+		if logRecord.Type == data.LogRecordHistory {
+			var pos *data.LogRecordPos
+			if len(logRecord.Value) > 0 {
+				pos = data.DeCodeLogRecordPos(logRecord.Value)
+			}
+			db.addMergedVersion(logRecord.Key, logRecord.SeqNo, logRecord.Timestamp, pos, pos == nil)
+			offset += size
+			continue
+		}
+
 		// decode pos from log record's value
 		pos := data.DeCodeLogRecordPos(logRecord.Value)
 
-		db.index.Put(logRecord.Key, pos)
+		valid := false
+		if logRecord.Namespace != defaultNamespaceId {
+			// records of dropped namespace are invalid
+			if ns, ok := db.namespaceIds[logRecord.Namespace]; ok {
+				ns.updateIndex(logRecord.Key, data.LogRecordNormal, pos)
+				valid = !pos.IsExpired()
+			} else {
+				db.reclaimSize += int64(pos.Size)
+			}
+		} else if pos.IsExpired() {
+			// key expired after merge, skip it
+			db.reclaimSize += int64(pos.Size)
+		} else {
+			db.index.Put(logRecord.Key, pos)
+			db.addMergedVersion(logRecord.Key, logRecord.SeqNo, logRecord.Timestamp, pos, false)
+			valid = true
+		}
+
+		// value is in blob file, the pos of it is in the record
+		if valid && logRecord.Type == data.LogRecordBlob {
+			if err := db.loadBlobRef(pos); err != nil {
+				return err
+			}
+		}
 
 		offset += size
 	}
@@ -0,0 +1,256 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// merge existing value (nil if key does not exist) with operand, return the new value
+type MergeFunc func(key []byte, existing []byte, operand []byte) ([]byte, error)
+
+// built-in merge operators
+const (
+	MergeOperatorInt64Add = "int64-add" // values and operands are decimal int64, e.g. "42"
+	MergeOperatorAppend   = "append"    // append operand to the end of value
+	MergeOperatorSetUnion = "set-union" // values and operands are sets encoded by EncodeSetMembers
+)
+
+// if the chain of merge operands is longer than this, resolve it and write the full value
+const maxMergeOperands = 64
+
+var (
+	mergeOperatorsLock = new(sync.RWMutex)
+	mergeOperators     = map[string]MergeFunc{
+		MergeOperatorInt64Add: int64AddMerge,
+		MergeOperatorAppend:   appendMerge,
+		MergeOperatorSetUnion: setUnionMerge,
+	}
+)
+
+// register a named merge operator, name is persisted with each operand,
+// so the operator must be registered before opening a db which contains its operands
+func RegisterMergeOperator(name string, fn MergeFunc) {
+	mergeOperatorsLock.Lock()
+	defer mergeOperatorsLock.Unlock()
+	mergeOperators[name] = fn
+}
+
+func getMergeOperator(name string) MergeFunc {
+	mergeOperatorsLock.RLock()
+	defer mergeOperatorsLock.RUnlock()
+	return mergeOperators[name]
+}
+
+// merge operand into the value of key with Options.MergeOperator, atomically
+// the operand is appended as a log record and resolved when reading,
+// operand refused by the operator is not written and its error is returned
+func (db *DB) Merge(key []byte, operand []byte) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if db.options.MergeOperator == "" {
+		return ErrMergeOperatorNotSet
+	}
+	// a bad operand would fail every later read of key
+	if err := validateMergeOperand(db.options.MergeOperator, key, operand); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	prev := db.mergeOperandPrev(key)
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, noTransactionSeqNo),
+		Value: encodeMergeOperand(db.options.MergeOperator, operand),
+		Type:  data.LogRecordMerge,
+		SeqNo: db.commitSeqNo + 1,
+	}
+
+	// too many operands makes reading slow, resolve them now
+	if operandChainLen(prev) >= maxMergeOperands {
+		value, err := db.resolveMergeOperand(logRecord, prev)
+		if err != nil {
+			return err
+		}
+		return db.writeLocked(key, value, data.LogRecordNormal, 0)
+	}
+
+	pos, err := db.AppendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+	pos.Prev = prev
+	db.index.Put(key, pos)
+	db.addVersion(key, logRecord.SeqNo, logRecord.Timestamp, pos, false)
+
+	db.markSingleWriteCommitted(key)
+	db.commitSeqNo = logRecord.SeqNo
+	// watchers and secondary indexes get the merged value
+	if db.isWatched(key) || len(db.secondaryIndexes) > 0 {
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return err
+		}
+		db.updateSecondaryIndexes(key, value, pos)
+		db.notifyWatchers(EventPut, key, value, db.commitSeqNo)
+	}
+	return nil
+}
+
+// get pos of the value which a new merge operand of key applies to
+// expired value is the same as not existing, count it as invalid
+func (db *DB) mergeOperandPrev(key []byte) *data.LogRecordPos {
+	prev := db.index.Get(key)
+	if prev != nil && prev.IsExpired() {
+		db.reclaimSize += prev.TotalSize()
+		db.releaseBlobs(prev)
+		return nil
+	}
+	return prev
+}
+
+// number of records in the chain of merge operands
+func operandChainLen(pos *data.LogRecordPos) int {
+	var n int
+	for p := pos; p != nil; p = p.Prev {
+		n++
+	}
+	return n
+}
+
+// apply merge operand record to the value at prev (nil means key does not exist)
+func (db *DB) resolveMergeOperand(logRecord *data.LogRecord, prev *data.LogRecordPos) ([]byte, error) {
+	var existing []byte
+	if prev != nil && !prev.IsExpired() {
+		val, err := db.getValueByPosition(prev)
+		if err != nil && err != ErrKeyNotFound {
+			return nil, err
+		}
+		existing = val
+	}
+
+	name, operand, err := decodeMergeOperand(logRecord.Value)
+	if err != nil {
+		return nil, err
+	}
+	fn := getMergeOperator(name)
+	if fn == nil {
+		return nil, ErrMergeOperatorNotFound
+	}
+
+	key, _ := parseLogRecordKey(logRecord.Key)
+	return fn(key, existing, operand)
+}
+
+// apply operand to a missing value, so the operand is checked without reading the existing one
+func validateMergeOperand(name string, key []byte, operand []byte) error {
+	fn := getMergeOperator(name)
+	if fn == nil {
+		return ErrMergeOperatorNotFound
+	}
+	_, err := fn(key, nil, operand)
+	return err
+}
+
+// name-size | name | operand
+func encodeMergeOperand(name string, operand []byte) []byte {
+	buf := make([]byte, binary.MaxVarintLen32+len(name)+len(operand))
+	idx := binary.PutUvarint(buf, uint64(len(name)))
+	idx += copy(buf[idx:], name)
+	idx += copy(buf[idx:], operand)
+	return buf[:idx]
+}
+
+func decodeMergeOperand(buf []byte) (string, []byte, error) {
+	nameSize, n := binary.Uvarint(buf)
+	if n <= 0 || uint64(len(buf)-n) < nameSize {
+		return "", nil, errInvalidMergeOperand
+	}
+	name := string(buf[n : n+int(nameSize)])
+	return name, buf[n+int(nameSize):], nil
+}
+
+var errInvalidMergeOperand = errors.New("invalid merge operand, log record maybe corrupted")
+
+func int64AddMerge(key []byte, existing []byte, operand []byte) ([]byte, error) {
+	var base int64
+	if len(existing) > 0 {
+		v, err := strconv.ParseInt(string(existing), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		base = v
+	}
+	delta, err := strconv.ParseInt(string(operand), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strconv.FormatInt(base+delta, 10)), nil
+}
+
+func appendMerge(key []byte, existing []byte, operand []byte) ([]byte, error) {
+	value := make([]byte, 0, len(existing)+len(operand))
+	value = append(value, existing...)
+	return append(value, operand...), nil
+}
+
+func setUnionMerge(key []byte, existing []byte, operand []byte) ([]byte, error) {
+	members, err := DecodeSetMembers(existing)
+	if err != nil {
+		return nil, err
+	}
+	added, err := DecodeSetMembers(operand)
+	if err != nil {
+		return nil, err
+	}
+	return EncodeSetMembers(append(members, added...)), nil
+}
+
+// encode set members for set-union merge operator, members are sorted and deduplicated
+func EncodeSetMembers(members [][]byte) []byte {
+	sorted := make([][]byte, len(members))
+	copy(sorted, members)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	var buf []byte
+	sizeBuf := make([]byte, binary.MaxVarintLen32)
+	for i, member := range sorted {
+		if i > 0 && bytes.Equal(member, sorted[i-1]) {
+			continue
+		}
+		n := binary.PutUvarint(sizeBuf, uint64(len(member)))
+		buf = append(buf, sizeBuf[:n]...)
+		buf = append(buf, member...)
+	}
+	return buf
+}
+
+// decode set members encoded by EncodeSetMembers
+func DecodeSetMembers(buf []byte) ([][]byte, error) {
+	var members [][]byte
+	for idx := 0; idx < len(buf); {
+		size, n := binary.Uvarint(buf[idx:])
+		if n <= 0 || uint64(len(buf)-idx-n) < size {
+			return nil, errInvalidMergeOperand
+		}
+		idx += n
+		members = append(members, buf[idx:idx+int(size)])
+		idx += int(size)
+	}
+	return members, nil
+}
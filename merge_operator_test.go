@@ -0,0 +1,218 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/utils"
+	"bytes"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Merge_Int64Add(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-op-1")
+	opts.DirPath = dir
+	opts.MergeOperator = MergeOperatorInt64Add
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: merge on a missing key
+	err = db.Merge([]byte("counter"), []byte("5"))
+	assert.Nil(t, err)
+	val, err := db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("5"), val)
+
+	// case2: concurrent merge
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				assert.Nil(t, db.Merge([]byte("counter"), []byte("1")))
+			}
+		}()
+	}
+	wg.Wait()
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("205"), val)
+
+	// case3: merge on an existing value
+	assert.Nil(t, db.Put([]byte("counter2"), []byte("100")))
+	assert.Nil(t, db.Merge([]byte("counter2"), []byte("-1")))
+	val, err = db.Get([]byte("counter2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("99"), val)
+
+	// case4: put covers the operands
+	assert.Nil(t, db.Put([]byte("counter"), []byte("0")))
+	assert.Nil(t, db.Merge([]byte("counter"), []byte("3")))
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+
+	// case5: bad operand is not written
+	err = db.Merge([]byte("counter"), []byte("abc"))
+	assert.NotNil(t, err)
+	val, err = db.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+
+	// case6: restart, operands are replayed
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err = db2.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("3"), val)
+	val, err = db2.Get([]byte("counter2"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("99"), val)
+	db2.Close()
+}
+
+func TestDB_Merge_Operators(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-op-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: operator is not set
+	err = db.Merge([]byte("key"), []byte("a"))
+	assert.Equal(t, ErrMergeOperatorNotSet, err)
+
+	// case2: operator is not registered
+	opts2 := opts
+	opts2.MergeOperator = "unknown"
+	_, err = Open(opts2)
+	assert.Equal(t, ErrMergeOperatorNotFound, err)
+
+	// case3: append
+	db.options.MergeOperator = MergeOperatorAppend
+	assert.Nil(t, db.Merge([]byte("list"), []byte("a")))
+	assert.Nil(t, db.Merge([]byte("list"), []byte("b")))
+	assert.Nil(t, db.Merge([]byte("list"), []byte("c")))
+	val, err := db.Get([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), val)
+
+	// case4: set union
+	db.options.MergeOperator = MergeOperatorSetUnion
+	assert.Nil(t, db.Merge([]byte("set"), EncodeSetMembers([][]byte{[]byte("b"), []byte("a")})))
+	assert.Nil(t, db.Merge([]byte("set"), EncodeSetMembers([][]byte{[]byte("c"), []byte("a")})))
+	val, err = db.Get([]byte("set"))
+	assert.Nil(t, err)
+	members, err := DecodeSetMembers(val)
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("a"), []byte("b"), []byte("c")}, members)
+
+	// case5: custom operator
+	RegisterMergeOperator("max", func(key []byte, existing []byte, operand []byte) ([]byte, error) {
+		if bytes.Compare(existing, operand) > 0 {
+			return existing, nil
+		}
+		return operand, nil
+	})
+	db.options.MergeOperator = "max"
+	assert.Nil(t, db.Merge([]byte("max"), []byte("b")))
+	assert.Nil(t, db.Merge([]byte("max"), []byte("c")))
+	assert.Nil(t, db.Merge([]byte("max"), []byte("a")))
+	val, err = db.Get([]byte("max"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+
+	// case6: operands of different operators are resolved by their own operator
+	val, err = db.Get([]byte("list"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), val)
+
+	// case7: iterator resolves operands
+	it := db.NewIterator(DefaultIteratorOptions)
+	it.Seek([]byte("list"))
+	assert.Equal(t, []byte("list"), it.Key())
+	val, err = it.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), val)
+	it.Close()
+}
+
+func TestDB_Merge_Compact(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-op-3")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.MergeOperator = MergeOperatorInt64Add
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Merge(utils.GetTestKey(i%10), []byte(strconv.Itoa(i))))
+	}
+	// too many operands are collapsed when writing
+	for i := 0; i < 2*maxMergeOperands; i++ {
+		assert.Nil(t, db.Merge([]byte("counter"), []byte("1")))
+	}
+	assert.True(t, operandChainLen(db.index.Get([]byte("counter"))) <= maxMergeOperands)
+
+	err = db.Compact()
+	assert.Nil(t, err)
+
+	// operands after compaction
+	assert.Nil(t, db.Merge(utils.GetTestKey(0), []byte("1000")))
+
+	// restart, operands are collapsed by compaction
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err := db2.Get(utils.GetTestKey(0))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1450"), val) // 0+10+...+90 + 1000
+	val, err = db2.Get(utils.GetTestKey(9))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("540"), val) // 9+19+...+99
+	val, err = db2.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte(strconv.Itoa(2*maxMergeOperands)), val)
+	assert.Nil(t, db2.index.Get(utils.GetTestKey(9)).Prev)
+	db2.Close()
+}
+
+func TestDB_Merge_BPtree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-op-4")
+	opts.DirPath = dir
+	opts.IndexType = BPtree
+	opts.MergeOperator = MergeOperatorAppend
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("key"), []byte("a")))
+	assert.Nil(t, db.Merge([]byte("key"), []byte("b")))
+	assert.Nil(t, db.Merge([]byte("key"), []byte("c")))
+
+	// chain of operands is persisted in bptree
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err := db2.Get([]byte("key"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("abc"), val)
+	db2.Close()
+}
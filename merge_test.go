@@ -2,8 +2,10 @@ package bitcaskminidb
 
 import (
 	"bitcask-go/utils"
+	"context"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -18,7 +20,7 @@ func TestDB_Merge(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, db)
 
-	err = db.Merge()
+	err = db.Compact()
 	assert.Nil(t, err)
 }
 
@@ -40,7 +42,7 @@ func TestDB_Merge2(t *testing.T) {
 		assert.Nil(t, err)
 	}
 
-	err = db.Merge()
+	err = db.Compact()
 	assert.Nil(t, err)
 
 	// restart
@@ -62,3 +64,79 @@ func TestDB_Merge2(t *testing.T) {
 
 	// db2.Close()
 }
+
+// case3: expired keys are dropped, and hint file carries expire
+func TestDB_Merge3(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge3")
+	opts.DataFileSize = 32 * 1024 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), 50*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	for i := 1000; i < 2000; i++ {
+		err := db.PutWithTTL(utils.GetTestKey(i), utils.RandomValue(128), 300*time.Millisecond)
+		assert.Nil(t, err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	err = db.Compact()
+	assert.Nil(t, err)
+
+	// restart, keys are loaded from hint file
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, len(db2.ListKeys()))
+
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, 0, len(db2.ListKeys()))
+	_, err = db2.Get(utils.GetTestKey(1500))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	db2.Close()
+}
+
+func TestDB_CompactContext(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-merge-context")
+	opts.DataFileMergeRatio = 0
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+
+	// case1: canceled compaction removes merge dir
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = db.CompactContext(ctx)
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(db.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+
+	// case2: compact again
+	err = db.CompactContext(context.Background())
+	assert.Nil(t, err)
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1000), db2.Stat().KeyNum)
+	assert.Equal(t, int64(0), db2.Stat().ReclaimSize)
+	db2.Close()
+}
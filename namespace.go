@@ -0,0 +1,445 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+	"context"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// id of the default namespace, which is used by the methods of DB
+const defaultNamespaceId uint32 = 0
+
+// file name of bptree index of namespace is followed by the namespace id
+const namespaceIndexFilePrefix = "bptree-index-ns-"
+
+// a logical dataset inside db, keys of different namespaces never conflict
+// records of all namespaces share the data files, and each namespace has its own index
+type Namespace struct {
+	db       *DB
+	name     string
+	id       uint32
+	index    *expiryIndex
+	dataSize int64 // size of valid records, under db.mu
+	dropped  bool
+}
+
+// statistics of namespace
+type NamespaceStat struct {
+	KeyNum   uint
+	DataSize int64 // size of valid log records
+}
+
+// create a namespace, or get it if it already exists
+// the namespace is saved in the namespace file, and survives restart
+func (db *DB) CreateNamespace(name string) (*Namespace, error) {
+	if len(name) == 0 {
+		return nil, ErrNamespaceNameIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	if ns, ok := db.namespaces[name]; ok {
+		return ns, nil
+	}
+
+	id := db.nextNamespaceId
+	if err := db.writeNamespaceRecord(name, id, data.LogRecordNormal); err != nil {
+		return nil, err
+	}
+	db.nextNamespaceId++
+
+	ns := &Namespace{
+		db:    db,
+		name:  name,
+		id:    id,
+		index: db.newNamespaceIndex(id),
+	}
+	db.namespaces[name] = ns
+	db.namespaceIds[id] = ns
+	return ns, nil
+}
+
+// drop a namespace with all its keys in O(1), only the index is discarded,
+// and the records left in data files are invalid, which will be cleaned by Compact, so are its blobs by CompactBlobs
+func (db *DB) DropNamespace(name string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	ns, ok := db.namespaces[name]
+	if !ok {
+		return ErrNamespaceNotFound
+	}
+
+	if err := db.writeNamespaceRecord(name, ns.id, data.LogRecordDeleted); err != nil {
+		return err
+	}
+	delete(db.namespaces, name)
+	delete(db.namespaceIds, ns.id)
+	ns.dropped = true
+	db.reclaimSize += ns.dataSize
+	// walking the index is O(n), so its blobs are released by CompactBlobs later
+	if len(db.blobRefs) > 0 {
+		db.droppedIndexes = append(db.droppedIndexes, ns.index)
+	} else if err := ns.index.Close(); err != nil {
+		return err
+	}
+	return db.removeNamespaceIndexFile(ns.id)
+}
+
+// names of all the namespaces in order
+func (db *DB) ListNamespaces() []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil
+	}
+
+	names := make([]string, 0, len(db.namespaces))
+	for name := range db.namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// name of namespace
+func (ns *Namespace) Name() string {
+	return ns.name
+}
+
+func (ns *Namespace) Put(key []byte, value []byte) error {
+	return ns.PutWithTTL(key, value, 0)
+}
+
+// put kv which will expire after ttl, ttl = 0 means never expire
+func (ns *Namespace) PutWithTTL(key []byte, value []byte, ttl time.Duration) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if ttl < 0 {
+		return ErrInvalidTTL
+	}
+
+	var expire int64
+	if ttl > 0 {
+		expire = time.Now().Add(ttl).UnixNano()
+	}
+
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+
+	if ns.db.closed {
+		return ErrDBClosed
+	}
+	if ns.dropped {
+		return ErrNamespaceDropped
+	}
+	return ns.writeLocked(key, value, data.LogRecordNormal, expire)
+}
+
+func (ns *Namespace) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+
+	if ns.db.closed {
+		return nil, ErrDBClosed
+	}
+	if ns.dropped {
+		return nil, ErrNamespaceDropped
+	}
+	logRecordPos := ns.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	return ns.db.getValueByPosition(logRecordPos)
+}
+
+func (ns *Namespace) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+
+	if ns.db.closed {
+		return ErrDBClosed
+	}
+	if ns.dropped {
+		return ErrNamespaceDropped
+	}
+	if pos := ns.index.Get(key); pos == nil {
+		return nil
+	}
+	return ns.writeLocked(key, nil, data.LogRecordDeleted, 0)
+}
+
+// iterator over the keys of namespace, the iterator of a dropped namespace or closed db is empty
+func (ns *Namespace) NewIterator(opts IteratorOptions) *Iterator {
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+
+	if ns.dropped {
+		return ns.db.newIterator(index.NewBtree(), opts)
+	}
+	return ns.db.newIterator(ns.index, opts)
+}
+
+// get all keys of namespace
+func (ns *Namespace) ListKeys() [][]byte {
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+
+	if ns.db.closed || ns.dropped {
+		return nil
+	}
+	keys, _ := listKeys(context.Background(), ns.index)
+	return keys
+}
+
+// get all kv of namespace and perform user's specified actions(by param-fn)
+func (ns *Namespace) Fold(fn func(key []byte, value []byte) bool) error {
+	ns.db.mu.Lock()
+	defer ns.db.mu.Unlock()
+
+	if ns.db.closed {
+		return ErrDBClosed
+	}
+	if ns.dropped {
+		return ErrNamespaceDropped
+	}
+	return ns.db.fold(context.Background(), ns.index, fn)
+}
+
+// write batch whose Put and Delete go to namespace, PutIn and DeleteIn can span other namespaces
+func (ns *Namespace) NewWriteBatch(opts WriteBatchOptions) *WriteBatch {
+	wb := ns.db.NewWriteBatch(opts)
+	wb.ns = ns
+	return wb
+}
+
+func (ns *Namespace) Stat() *NamespaceStat {
+	ns.db.mu.RLock()
+	defer ns.db.mu.RUnlock()
+
+	if ns.db.closed || ns.dropped {
+		return &NamespaceStat{}
+	}
+
+	// expired keys are garbage as well, they are not counted
+	expiredSize, expiredNum := ns.index.expired()
+	return &NamespaceStat{
+		KeyNum:   uint(ns.index.Size() - expiredNum),
+		DataSize: ns.dataSize - expiredSize,
+	}
+}
+
+// append a normal or deleted log record of namespace and update its index, under db.mu
+func (ns *Namespace) writeLocked(key []byte, value []byte, typ data.LogRecordType, expire int64) error {
+	logRecord := &data.LogRecord{
+		Key:       logRecordKeyWithSeq(key, noTransactionSeqNo),
+		Value:     value,
+		Type:      typ,
+		Expire:    expire,
+		SeqNo:     ns.db.commitSeqNo + 1,
+		Namespace: ns.id,
+	}
+	pos, err := ns.db.AppendLogRecord(logRecord)
+	if err != nil {
+		return err
+	}
+
+	ns.updateIndex(key, typ, pos)
+	ns.db.commitSeqNo = logRecord.SeqNo
+	return nil
+}
+
+// update index of namespace with a committed record, and count the invalid size, under db.mu
+func (ns *Namespace) updateIndex(key []byte, typ data.LogRecordType, pos *data.LogRecordPos) {
+	var oldPos *data.LogRecordPos
+	// expired record is the same as deleted one, both of them are invalid
+	if typ == data.LogRecordDeleted || pos.IsExpired() {
+		oldPos, _ = ns.index.Delete(key)
+		ns.db.reclaimSize += int64(pos.Size)
+	} else {
+		oldPos = ns.index.Put(key, pos)
+		ns.dataSize += int64(pos.Size)
+	}
+	if oldPos != nil {
+		ns.db.reclaimSize += oldPos.TotalSize()
+		ns.dataSize -= oldPos.TotalSize()
+		ns.db.releaseBlobs(oldPos)
+	}
+}
+
+// remove expired keys from index of namespace, under db.mu
+func (ns *Namespace) removeExpiredKeys() {
+	size := ns.db.removeExpiredKeysOf(ns.index)
+	ns.db.reclaimSize += size
+	ns.dataSize -= size
+}
+
+// nil namespace is the default one
+func (ns *Namespace) namespaceId() uint32 {
+	if ns == nil {
+		return defaultNamespaceId
+	}
+	return ns.id
+}
+
+// index of the namespace id, nil if the namespace has been dropped, under db.mu
+func (db *DB) indexOf(namespace uint32) index.Indexer {
+	if namespace == defaultNamespaceId {
+		return db.index
+	}
+	if ns, ok := db.namespaceIds[namespace]; ok {
+		return ns.index
+	}
+	return nil
+}
+
+func (db *DB) newNamespaceIndex(id uint32) *expiryIndex {
+	if db.options.IndexType == BPtree {
+		return newExpiryIndex(index.NewBPlusTreeWithFileName(db.options.DirPath, namespaceIndexFileName(id), db.options.SyncWrites))
+	}
+	return newExpiryIndex(index.NewIndexer(int8(db.options.IndexType), db.options.DirPath, db.options.SyncWrites))
+}
+
+func (db *DB) removeNamespaceIndexFile(id uint32) error {
+	if db.options.IndexType != BPtree {
+		return nil
+	}
+	err := os.Remove(filepath.Join(db.options.DirPath, namespaceIndexFileName(id)))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func namespaceIndexFileName(id uint32) string {
+	return namespaceIndexFilePrefix + strconv.FormatUint(uint64(id), 10)
+}
+
+// save the creation (normal record) or drop (deleted record) of namespace, under db.mu
+func (db *DB) writeNamespaceRecord(name string, id uint32, typ data.LogRecordType) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	namespaceFile, err := data.OpenNamespaceFile(db.options.DirPath, db.fileOptions)
+	if err != nil {
+		return err
+	}
+	defer namespaceFile.Close()
+
+	record := &data.LogRecord{
+		Key:   []byte(name),
+		Value: []byte(strconv.FormatUint(uint64(id), 10)),
+		Type:  typ,
+	}
+	encRecord, _ := data.EncodeLogRecord(record)
+	if err := namespaceFile.Write(encRecord); err != nil {
+		return err
+	}
+	return namespaceFile.Sync()
+}
+
+// load namespaces from namespace file, before loading index
+func (db *DB) loadNamespaces() error {
+	fileName := filepath.Join(db.options.DirPath, data.NamespaceFileName)
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+
+	namespaceFile, err := data.OpenNamespaceFile(db.options.DirPath, db.fileOptions)
+	if err != nil {
+		return err
+	}
+	defer namespaceFile.Close()
+
+	ids := make(map[string]uint32)
+	var droppedIds []uint32
+	var offset int64 = 0
+	for {
+		record, size, err := namespaceFile.ReadLogRecord(offset)
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+		offset += size
+
+		id, err := strconv.ParseUint(string(record.Value), 10, 32)
+		if err != nil {
+			return err
+		}
+		// ids are never reused, even if the namespace has been dropped
+		if uint32(id) >= db.nextNamespaceId {
+			db.nextNamespaceId = uint32(id) + 1
+		}
+		if record.Type == data.LogRecordDeleted {
+			delete(ids, string(record.Key))
+			droppedIds = append(droppedIds, uint32(id))
+		} else {
+			ids[string(record.Key)] = uint32(id)
+		}
+	}
+
+	// index file may be left if db crashed while dropping
+	for _, id := range droppedIds {
+		if err := db.removeNamespaceIndexFile(id); err != nil {
+			return err
+		}
+	}
+
+	for name, id := range ids {
+		// loaded before refreshing a read-only db
+		if _, ok := db.namespaceIds[id]; ok {
+			continue
+		}
+		ns := &Namespace{
+			db:    db,
+			name:  name,
+			id:    id,
+			index: db.newNamespaceIndex(id),
+		}
+		// B+ Tree is not loaded from data files, so count the valid size here
+		if db.options.IndexType == BPtree {
+			it := ns.index.Iterator(false)
+			for it.Rewind(); it.Valid(); it.Next() {
+				ns.dataSize += int64(it.Value().Size)
+			}
+			it.Close()
+		}
+		db.namespaces[name] = ns
+		db.namespaceIds[id] = ns
+	}
+	return nil
+}
+
+// key of pending write in batch, which is unique among namespaces
+func batchKey(namespace uint32, key []byte) string {
+	buf := binary.AppendUvarint(make([]byte, 0, binary.MaxVarintLen32+len(key)), uint64(namespace))
+	return string(append(buf, key...))
+}
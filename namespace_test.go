@@ -0,0 +1,302 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Namespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	_, err = db.CreateNamespace("")
+	assert.Equal(t, ErrNamespaceNameIsEmpty, err)
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	sessions, err := db.CreateNamespace("sessions")
+	assert.Nil(t, err)
+	assert.Equal(t, "users", users.Name())
+	assert.Equal(t, []string{"sessions", "users"}, db.ListNamespaces())
+
+	// case1: the same key in different namespaces
+	assert.Nil(t, db.Put([]byte("k"), []byte("default")))
+	assert.Nil(t, users.Put([]byte("k"), []byte("users")))
+	assert.Nil(t, sessions.Put([]byte("k"), []byte("sessions")))
+	val, err := db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	val, err = users.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+
+	assert.Nil(t, users.Delete([]byte("k")))
+	_, err = users.Get([]byte("k"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = sessions.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("sessions"), val)
+
+	// case2: iterator and stat of namespace
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, users.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	it := users.NewIterator(DefaultIteratorOptions)
+	var n int
+	for it.Rewind(); it.Valid(); it.Next() {
+		_, err := it.Value()
+		assert.Nil(t, err)
+		n++
+	}
+	it.Close()
+	assert.Equal(t, 10, n)
+	assert.Equal(t, 10, len(users.ListKeys()))
+	stat := users.Stat()
+	assert.Equal(t, uint(10), stat.KeyNum)
+	assert.True(t, stat.DataSize > 0)
+	assert.Equal(t, uint(1), db.Stat().KeyNum)
+
+	// case3: create an existing namespace
+	users2, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.True(t, users == users2)
+
+	// case4: restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	users, err = db2.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, stat, users.Stat())
+	_, err = users.Get([]byte("k"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	sessions, err = db2.CreateNamespace("sessions")
+	assert.Nil(t, err)
+	val, err = sessions.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("sessions"), val)
+
+	// case5: namespace file belongs to the db
+	namespaceFile, err := data.OpenNamespaceFile(dir, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, db2.fileOptions.DBId, namespaceFile.Header().DBId)
+	assert.Nil(t, namespaceFile.Close())
+
+	// case6: overwritten record of namespace is reclaimable
+	reclaimSize := db2.reclaimSize
+	oldPos := users.index.Get(utils.GetTestKey(1))
+	assert.Nil(t, users.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.Equal(t, reclaimSize+oldPos.TotalSize(), db2.reclaimSize)
+	db2.Close()
+}
+
+func TestDB_Namespace_WriteBatch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	audit, err := db.CreateNamespace("audit")
+	assert.Nil(t, err)
+	assert.Nil(t, audit.Put([]byte("old"), []byte("1")))
+
+	// case1: batch spans namespaces
+	wb := users.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k"), []byte("users")))
+	assert.Nil(t, wb.PutIn(audit, []byte("k"), []byte("audit")))
+	assert.Nil(t, wb.PutIn(nil, []byte("k"), []byte("default")))
+	assert.Nil(t, wb.DeleteIn(audit, []byte("old")))
+	_, err = users.Get([]byte("k"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, wb.Commit())
+
+	val, err := users.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	val, err = audit.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("audit"), val)
+	val, err = db.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	_, err = audit.Get([]byte("old"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// case2: namespace is dropped before commit
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("k2"), []byte("default")))
+	assert.Nil(t, wb.PutIn(audit, []byte("k2"), []byte("audit")))
+	assert.Nil(t, db.DropNamespace("audit"))
+	assert.Equal(t, ErrNamespaceDropped, wb.Commit())
+	_, err = db.Get([]byte("k2"))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// case3: restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	users, err = db2.CreateNamespace("users")
+	assert.Nil(t, err)
+	val, err = users.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("users"), val)
+	val, err = db2.Get([]byte("k"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("default"), val)
+	db2.Close()
+}
+
+func TestDB_DropNamespace(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-3")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Equal(t, ErrNamespaceNotFound, db.DropNamespace("users"))
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	sessions, err := db.CreateNamespace("sessions")
+	assert.Nil(t, err)
+	for i := 0; i < 1000; i++ {
+		assert.Nil(t, users.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	dataSize := users.Stat().DataSize
+	reclaimSize := db.Stat().ReclaimSize
+
+	// case1: drop namespace, the records become invalid
+	assert.Nil(t, db.DropNamespace("users"))
+	assert.Equal(t, reclaimSize+dataSize, db.Stat().ReclaimSize)
+	_, err = users.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrNamespaceDropped, err)
+	assert.Equal(t, ErrNamespaceDropped, users.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	it := users.NewIterator(DefaultIteratorOptions)
+	assert.False(t, it.Valid())
+	it.Close()
+	assert.Equal(t, []string{"sessions"}, db.ListNamespaces())
+
+	// case2: the same name is a new empty namespace
+	users, err = db.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(0), users.Stat().KeyNum)
+	assert.Nil(t, users.Put([]byte("new"), []byte("value")))
+
+	// case3: restart and compact
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	users, err = db2.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("new")}, users.ListKeys())
+	assert.Equal(t, reclaimSize+dataSize, db2.Stat().ReclaimSize)
+
+	err = db2.Compact()
+	assert.Nil(t, err)
+	err = db2.Close()
+	assert.Nil(t, err)
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), db3.Stat().ReclaimSize)
+	users, err = db3.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("new")}, users.ListKeys())
+	sessions, err = db3.CreateNamespace("sessions")
+	assert.Nil(t, err)
+	assert.Equal(t, uint(1000), sessions.Stat().KeyNum)
+	db3.Close()
+}
+
+func TestDB_Namespace_BPtree(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-namespace-4")
+	opts.DirPath = dir
+	opts.IndexType = BPtree
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	users, err := db.CreateNamespace("users")
+	assert.Nil(t, err)
+	sessions, err := db.CreateNamespace("sessions")
+	assert.Nil(t, err)
+	for i := 0; i < 10; i++ {
+		assert.Nil(t, users.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+		assert.Nil(t, sessions.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	stat := users.Stat()
+
+	// the index file is removed with the namespace
+	assert.Nil(t, db.DropNamespace("sessions"))
+	_, err = os.Stat(filepath.Join(dir, namespaceIndexFileName(sessions.id)))
+	assert.True(t, os.IsNotExist(err))
+
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	users, err = db2.CreateNamespace("users")
+	assert.Nil(t, err)
+	assert.Equal(t, stat, users.Stat())
+	val, err := users.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	db2.Close()
+}
+
+func TestDB_Namespace_Closed(t *testing.T) {
+	for _, indexType := range []IndexerType{Btree, BPtree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-namespace-5")
+		opts.DirPath = dir
+		opts.IndexType = indexType
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		users, err := db.CreateNamespace("users")
+		assert.Nil(t, err)
+		for i := 0; i < 10; i++ {
+			assert.Nil(t, users.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+		}
+		assert.Nil(t, db.Close())
+
+		// every method of namespace returns ErrDBClosed after close
+		assert.Equal(t, ErrDBClosed, users.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+		_, err = users.Get(utils.GetTestKey(1))
+		assert.Equal(t, ErrDBClosed, err)
+		assert.Equal(t, ErrDBClosed, users.Delete(utils.GetTestKey(1)))
+		it := users.NewIterator(DefaultIteratorOptions)
+		assert.False(t, it.Valid())
+		assert.Equal(t, ErrDBClosed, it.Err())
+		it.Close()
+		assert.Nil(t, users.ListKeys())
+		assert.Equal(t, ErrDBClosed, users.Fold(func(key []byte, value []byte) bool {
+			return true
+		}))
+		assert.Equal(t, &NamespaceStat{}, users.Stat())
+		destroyDB(db)
+	}
+}
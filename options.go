@@ -1,6 +1,10 @@
 package bitcaskminidb
 
-import "os"
+import (
+	"bitcask-go/data"
+	"os"
+	"time"
+)
 
 type Options struct {
 	DirPath            string
@@ -10,6 +14,29 @@ type Options struct {
 	IndexType          IndexerType //index type: Btree/ARTree
 	MMapAtStartUp      bool        // if use mmap instead of standard_fio when start up db
 	DataFileMergeRatio float32
+	MergeOperator      string                    // name of the registered merge operator used by DB.Merge
+	ChangeRetention    uint64                    // number of latest commits whose invalid records are kept by Compact for ChangesSince
+	SecondaryIndexes   map[string]IndexExtractor // secondary indexes built when db opens, see DB.RegisterIndex
+	// old versions kept for DB.GetAt and DB.History, a version is kept if it is one of the last
+	// VersionRetention versions of key, or newer than VersionRetentionDuration, both 0 means disabled
+	VersionRetention         uint
+	VersionRetentionDuration time.Duration
+	// open db for reading only, read-only processes share the directory with each other and with the writer
+	ReadOnly bool
+	// values larger than it are written to blob files, and data files only keep their pos, 0 means disabled
+	ValueThreshold int64
+	// blob file is rewritten by DB.CompactBlobs if the ratio of its invalid values reaches it
+	BlobFileGCRatio float32
+	// codec to compress values larger than CompressionThreshold, values written before keep their codec until Compact
+	Compression          data.CodecId
+	CompressionThreshold int64
+	// encrypt data, blob, hint and seq-no files with AES-GCM, the key is 16, 24 or 32 bytes,
+	// KeyProvider supports key rotation, new files are encrypted by its current key and Compact re-encrypts the others
+	// only one of them can be set, files encrypted before can not be opened without the key
+	EncryptionKey []byte
+	KeyProvider   KeyProvider
+	// how corrupted records in data files are handled when db opens, see DB.RecoveryReport
+	Recovery RecoveryPolicy
 }
 
 type IndexerType int8
@@ -18,8 +45,14 @@ type IndexerType int8
 type IteratorOptions struct {
 	// traverse all keys prefixed with Prefix
 	Prefix []byte
+	// traverse keys >= LowerBound, nil means unbounded
+	LowerBound []byte
+	// traverse keys < UpperBound, nil means unbounded
+	UpperBound []byte
 	// if reverse traverse, default: false
 	Reverse bool
+	// only traverse keys, Iterator.Value is not available
+	KeysOnly bool
 }
 
 type WriteBatchOptions struct {
@@ -33,15 +66,27 @@ const (
 	BPtree
 )
 
+// built-in codecs of Options.Compression, others can be registered by data.RegisterCodec
+const (
+	NoCompression    = data.CodecNone
+	FlateCompression = data.CodecFlate
+	GzipCompression  = data.CodecGzip
+	ZlibCompression  = data.CodecZlib
+)
+
 // for example
 var DefaultOptions = Options{
-	DirPath:            os.TempDir(),
-	DataFileSize:       256 * 1024 * 1024, //256MB
-	SyncWrites:         false,
-	BytesPerSync:       0,
-	IndexType:          Btree,
-	MMapAtStartUp:      true,
-	DataFileMergeRatio: 0.5,
+	DirPath:              os.TempDir(),
+	DataFileSize:         256 * 1024 * 1024, //256MB
+	SyncWrites:           false,
+	BytesPerSync:         0,
+	IndexType:            Btree,
+	MMapAtStartUp:        true,
+	DataFileMergeRatio:   0.5,
+	BlobFileGCRatio:      0.5,
+	Compression:          NoCompression,
+	CompressionThreshold: 128,
+	Recovery:             RecoveryTruncate,
 }
 
 var DefaultIteratorOptions = IteratorOptions{
@@ -0,0 +1,144 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"context"
+	"os"
+	"path/filepath"
+
+	"github.com/gofrs/flock"
+)
+
+// shared by read-only processes, the writer locks it only when replacing data files with merge files
+const readerLockName = "flock-readers"
+
+// writer holds the exclusive flock, and read-only db holds the shared reader lock
+func lockDir(options Options) (*flock.Flock, bool, error) {
+	if options.ReadOnly {
+		readerLockFile := filepath.Join(options.DirPath, readerLockName)
+		fileLock := flock.New(readerLockFile)
+		// the reader lock is created by the writer, if it can not be created here, e.g. on a read-only
+		// file system, there is no writer to replace the data files, so read-only db goes without it
+		if _, err := os.Stat(readerLockFile); os.IsNotExist(err) {
+			if err := createReaderLock(options.DirPath); err != nil {
+				return fileLock, true, nil
+			}
+		}
+		hold, err := fileLock.TryRLock()
+		return fileLock, hold, err
+	}
+	fileLock := flock.New(filepath.Join(options.DirPath, fileLockName))
+	hold, err := fileLock.TryLock()
+	if err != nil || !hold {
+		return fileLock, hold, err
+	}
+	if err := createReaderLock(options.DirPath); err != nil {
+		_ = fileLock.Unlock()
+		return fileLock, false, err
+	}
+	return fileLock, true, nil
+}
+
+// create the reader lock file, so that read-only db need not create it
+func createReaderLock(dirPath string) error {
+	file, err := os.OpenFile(filepath.Join(dirPath, readerLockName), os.O_CREATE|os.O_RDONLY, fio.DataFilePerm)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// the writer may have just created the file and not written its header yet, so read-only db opens it by a later Refresh
+func (db *DB) isNewFile(fileName string) (bool, error) {
+	if !db.options.ReadOnly {
+		return false, nil
+	}
+	stat, err := os.Stat(fileName)
+	if err != nil {
+		return false, err
+	}
+	return stat.Size() == 0, nil
+}
+
+// replace or remove files only if no read-only db is using them,
+// otherwise fn is not called, and the files are replaced or removed next time
+func (db *DB) withoutReaders(fn func() error) error {
+	readerLock := flock.New(filepath.Join(db.options.DirPath, readerLockName))
+	hold, err := readerLock.TryLock()
+	if err != nil {
+		return err
+	}
+	if !hold {
+		return readerLock.Close()
+	}
+	defer readerLock.Close()
+	defer readerLock.Unlock()
+
+	return fn()
+}
+
+// load the records appended by the writer since open or the last refresh, only for read-only db
+// data files replaced by Compact of the writer are loaded when db opens again
+func (db *DB) Refresh() error {
+	if !db.options.ReadOnly {
+		return nil
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	// namespaces created by the writer
+	if err := db.loadNamespaces(); err != nil {
+		return err
+	}
+
+	// records appended by the writer may point to new blob files
+	if err := db.loadBlobFiles(); err != nil {
+		return err
+	}
+
+	// the rest of active file
+	if db.activeFile != nil {
+		if err := db.refreshActiveFile(); err != nil {
+			return err
+		}
+	}
+
+	// new data files of the writer
+	fileIds, err := db.dataFileIds()
+	if err != nil {
+		return err
+	}
+	for _, fid := range fileIds {
+		if db.activeFile != nil && uint32(fid) <= db.activeFile.FileId {
+			continue
+		}
+		if isNew, err := db.isNewFile(data.GetDataFileName(db.options.DirPath, uint32(fid))); err != nil || isNew {
+			return err
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, uint32(fid), fio.StandardFIO, db.fileOptions)
+		if err != nil {
+			return fileHeaderError(err, data.GetDataFileName(db.options.DirPath, uint32(fid)))
+		}
+		if db.activeFile != nil {
+			db.olderFiles[db.activeFile.FileId] = db.activeFile
+		}
+		db.activeFile = dataFile
+		if err := db.refreshActiveFile(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load records of active file after its write offset, which is the end of loaded records
+func (db *DB) refreshActiveFile() error {
+	offset, err := db.loadIndexFromDataFile(context.Background(), db.activeFile, db.activeFile.WriteOff)
+	db.activeFile.WriteOff = offset
+	return err
+}
@@ -0,0 +1,164 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_ReadOnly(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-1")
+	opts.DirPath = dir
+	opts.DataFileSize = 32 * 1024
+	opts.MergeOperator = MergeOperatorAppend
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+
+	// case1: readers share the directory with the writer
+	roOpts := opts
+	roOpts.ReadOnly = true
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+	reader2, err := Open(roOpts)
+	assert.Nil(t, err)
+	assert.Nil(t, reader2.Close())
+
+	val, err := reader.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+
+	// case2: writes are rejected
+	assert.Equal(t, ErrReadOnly, reader.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.Equal(t, ErrReadOnly, reader.Delete(utils.GetTestKey(1)))
+	assert.Equal(t, ErrReadOnly, reader.Merge(utils.GetTestKey(1), []byte("x")))
+	wb := reader.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Equal(t, ErrReadOnly, wb.Put(utils.GetTestKey(1), utils.RandomValue(24)))
+	assert.Equal(t, ErrReadOnly, wb.Commit())
+	assert.Equal(t, ErrReadOnly, reader.Compact())
+	_, err = reader.Begin()
+	assert.Equal(t, ErrReadOnly, err)
+
+	// case3: refresh picks up the appended records and the new data files
+	for i := 100; i < 500; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(128)))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(1)))
+	wb = db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), []byte("value")))
+	assert.Nil(t, wb.Commit())
+
+	_, err = reader.Get(utils.GetTestKey(400))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Nil(t, reader.Refresh())
+	val, err = reader.Get(utils.GetTestKey(400))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	_, err = reader.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	val, err = reader.Get([]byte("batch"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("value"), val)
+	assert.Equal(t, db.Stat().KeyNum, reader.Stat().KeyNum)
+
+	// case4: nothing is created by reader
+	assert.Nil(t, reader.Close())
+	_, err = os.Stat(filepath.Join(dir, data.SeqNoFileName))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = Open(Options{DirPath: filepath.Join(dir, "not-exist"), DataFileSize: opts.DataFileSize, ReadOnly: true})
+	assert.NotNil(t, err)
+	roOpts.IndexType = BPtree
+	_, err = Open(roOpts)
+	assert.Equal(t, ErrReadOnlyUnsupported, err)
+}
+
+func TestDB_ReadOnly_MergeFiles(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-2")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Close())
+
+	// case1: merge files are not loaded while reader is open
+	roOpts := opts
+	roOpts.ReadOnly = true
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(db2.getMergePath())
+	assert.Nil(t, err)
+	assert.Equal(t, uint(100), reader.Stat().KeyNum)
+	assert.Nil(t, db2.Close())
+	assert.Nil(t, reader.Close())
+
+	// case2: loaded after reader is closed
+	db3, err := Open(opts)
+	assert.Nil(t, err)
+	_, err = os.Stat(db3.getMergePath())
+	assert.True(t, os.IsNotExist(err))
+	assert.Equal(t, uint(100), db3.Stat().KeyNum)
+	assert.Nil(t, db3.Close())
+}
+
+func TestDB_ReadOnly_ReaderLock(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-readonly-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(24)))
+	}
+	assert.Nil(t, db.Close())
+
+	// case1: reader lock is created by the writer, and read-only db changes no file
+	stat, err := os.Stat(filepath.Join(dir, readerLockName))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(fio.DataFilePerm), stat.Mode().Perm())
+	files := readDirFiles(t, dir)
+	roOpts := opts
+	roOpts.ReadOnly = true
+	reader, err := Open(roOpts)
+	assert.Nil(t, err)
+	val, err := reader.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Nil(t, reader.Close())
+	assert.Equal(t, files, readDirFiles(t, dir))
+
+	// case2: read-only db goes without reader lock if it can not be created
+	assert.Nil(t, os.Remove(filepath.Join(dir, readerLockName)))
+	assert.Nil(t, os.Symlink(filepath.Join(dir, "missing", readerLockName), filepath.Join(dir, readerLockName)))
+	reader, err = Open(roOpts)
+	assert.Nil(t, err)
+	val, err = reader.Get(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	assert.NotNil(t, val)
+	assert.Nil(t, reader.Close())
+}
@@ -0,0 +1,188 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"context"
+	"fmt"
+	"io"
+)
+
+// how corrupted records in data files are handled when db opens, see Options.Recovery
+type RecoveryPolicy int8
+
+const (
+	// truncate the newest data file at its first corrupted record, which is usually torn by a crash,
+	// corrupted records followed by valid ones, or in older data files, fail Open
+	RecoveryTruncate RecoveryPolicy = iota
+	// any corrupted record fails Open
+	RecoveryFail
+	// skip corrupted data of all data files to the next valid record,
+	// the newest data file is truncated if there is no valid record after it
+	RecoverySkip
+)
+
+// corrupted data discarded when db opens
+type Corruption struct {
+	FileId    uint32
+	Offset    int64 // where the corrupted data starts, not counting the file header
+	Size      int64 // discarded bytes
+	Truncated bool  // if the file is truncated at Offset, read-only db never truncates
+	Err       error // data.ErrInvalidCRC, or io.ErrUnexpectedEOF if the record is torn at the end of file
+}
+
+// corrupted data discarded by the last Open, see DB.RecoveryReport
+type RecoveryReport struct {
+	Policy         RecoveryPolicy
+	Corruptions    []Corruption
+	DiscardedBytes int64
+}
+
+// corrupted data discarded when db opens, B+ Tree index does not load data files, so nothing is reported
+func (db *DB) RecoveryReport() RecoveryReport {
+	report := db.recovery
+	report.Corruptions = append([]Corruption(nil), db.recovery.Corruptions...)
+	return report
+}
+
+// load the records of data file into index when db opens, corrupted records are handled by Options.Recovery,
+// return the offset where loading stops
+func (db *DB) recoverDataFile(ctx context.Context, dataFile *data.DataFile, isNewest bool) (int64, error) {
+	var offset int64
+	for {
+		end, err := db.loadIndexFromDataFile(ctx, dataFile, offset)
+		if err != nil && err != data.ErrInvalidCRC {
+			return end, err
+		}
+		size, sizeErr := dataFile.IOManager.Size()
+		if sizeErr != nil {
+			return end, sizeErr
+		}
+		if err == nil {
+			if end >= size {
+				return end, nil
+			}
+			// the writer may be writing the last record
+			if db.options.ReadOnly && isNewest {
+				return end, nil
+			}
+			err = io.ErrUnexpectedEOF
+		}
+
+		corruption := Corruption{FileId: dataFile.FileId, Offset: end, Size: size - end, Err: err}
+		switch db.options.Recovery {
+		case RecoveryTruncate:
+			// valid records after it mean the data is damaged rather than torn, so they are never truncated
+			if _, ok := dataFile.NextValidRecord(end+1, size); ok {
+				corruption.Err = damagedError(err)
+				return end, db.corruptionError(corruption)
+			}
+			if !isNewest {
+				return end, db.corruptionError(corruption)
+			}
+		case RecoverySkip:
+			if next, ok := dataFile.NextValidRecord(end+1, size); ok {
+				corruption.Err = damagedError(err)
+				corruption.Size = next - end
+				db.discard(corruption)
+				offset = next
+				continue
+			}
+			if !isNewest {
+				db.discard(corruption)
+				return end, nil
+			}
+		default:
+			return end, db.corruptionError(corruption)
+		}
+
+		// the tail of the newest file is truncated, reopened encrypted file is never appended,
+		// so the nonce of the discarded record is not reused
+		if !db.options.ReadOnly {
+			if err := dataFile.Truncate(db.options.DirPath, end); err != nil {
+				return end, err
+			}
+			corruption.Truncated = true
+		}
+		db.discard(corruption)
+		return end, nil
+	}
+}
+
+// add corrupted data to the recovery report, it is reclaimed by Compact if the file is not truncated
+func (db *DB) discard(corruption Corruption) {
+	db.recovery.Corruptions = append(db.recovery.Corruptions, corruption)
+	db.recovery.DiscardedBytes += corruption.Size
+	if !corruption.Truncated {
+		db.reclaimSize += corruption.Size
+	}
+}
+
+// error of corrupted data followed by valid records, whose header may state a size past the end of file
+func damagedError(err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return data.ErrInvalidCRC
+	}
+	return err
+}
+
+func (db *DB) corruptionError(corruption Corruption) error {
+	return fmt.Errorf("%w, file: %s, offset: %d", corruption.Err,
+		data.GetDataFileName(db.options.DirPath, corruption.FileId), corruption.Offset)
+}
+
+// read the record at offset, or the first valid record after it if the data at offset is corrupted,
+// return the record and its offset, io.EOF if there is no valid record before size
+func readValidRecord(dataFile *data.DataFile, offset, size int64) (*data.LogRecord, int64, int64, error) {
+	for offset < size {
+		lr, recordSize, err := dataFile.ReadLogRecord(offset)
+		if err == nil {
+			return lr, offset, recordSize, nil
+		}
+		// a record stated past the end of file is also corrupted if it is not at the tail
+		if err != io.EOF && err != data.ErrInvalidCRC && err != data.ErrUnknownCodec {
+			return nil, 0, 0, err
+		}
+		next, ok := dataFile.NextValidRecord(offset+1, size)
+		if !ok {
+			break
+		}
+		offset = next
+	}
+	return nil, 0, 0, io.EOF
+}
+
+// read the records of data file of size, corrupted data is skipped to the next valid record,
+// visit is called with the valid records
+func scanDataFile(dataFile *data.DataFile, size int64, visit func(lr *data.LogRecord, offset, recordSize int64)) ([]Corruption, error) {
+	var corruptions []Corruption
+	var offset int64
+	for offset < size {
+		lr, recordSize, err := dataFile.ReadLogRecord(offset)
+		if err == io.EOF {
+			// a corrupted header may state a size past the end of file, so the record is torn only if no valid record follows
+			next, ok := dataFile.NextValidRecord(offset+1, size)
+			if !ok {
+				corruptions = append(corruptions, Corruption{FileId: dataFile.FileId, Offset: offset, Size: size - offset, Err: io.ErrUnexpectedEOF})
+				break
+			}
+			corruptions = append(corruptions, Corruption{FileId: dataFile.FileId, Offset: offset, Size: next - offset, Err: data.ErrInvalidCRC})
+			offset = next
+			continue
+		}
+		if err == data.ErrInvalidCRC || err == data.ErrUnknownCodec {
+			next, ok := dataFile.NextValidRecord(offset+1, size)
+			if !ok {
+				next = size
+			}
+			corruptions = append(corruptions, Corruption{FileId: dataFile.FileId, Offset: offset, Size: next - offset, Err: err})
+			offset = next
+			continue
+		}
+		if err != nil {
+			return corruptions, err
+		}
+		visit(lr, offset, recordSize)
+		offset += recordSize
+	}
+	return corruptions, nil
+}
@@ -0,0 +1,244 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// append bytes to the data file of db dir
+func appendToFile(t *testing.T, fileName string, b []byte) {
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_WRONLY, 0644)
+	assert.Nil(t, err)
+	_, err = f.Write(b)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+}
+
+// make the key size of the record at offset of data file run past the end of file, as a corrupted header does
+func corruptKeySize(t *testing.T, dir string, fid uint32, offset int64) {
+	dataFile, err := data.OpenDataFile(dir, fid, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	size, err := dataFile.IOManager.Size()
+	assert.Nil(t, err)
+	assert.Nil(t, dataFile.Close())
+	fileName := data.GetDataFileName(dir, fid)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	headerSize := int64(len(content)) - size
+	// crc | type and codec | key size
+	binary.PutVarint(content[headerSize+offset+crc32.Size+1:], 1<<30)
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+}
+
+func TestDB_Recovery_Truncate(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	assert.Nil(t, db.Close())
+	fileName := data.GetDataFileName(dir, 0)
+	stat, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	// torn record of a crash
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: logRecordKeyWithSeq([]byte("torn"), noTransactionSeqNo), Value: utils.RandomValue(64)})
+	appendToFile(t, fileName, encRecord[:len(encRecord)/2])
+
+	// case1: fail
+	opts.Recovery = RecoveryFail
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, io.ErrUnexpectedEOF))
+
+	// case2: truncate the torn record
+	opts.Recovery = RecoveryTruncate
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.Equal(t, RecoveryTruncate, report.Policy)
+	assert.Equal(t, int64(len(encRecord)/2), report.DiscardedBytes)
+	assert.Equal(t, 1, len(report.Corruptions))
+	assert.Equal(t, uint32(0), report.Corruptions[0].FileId)
+	assert.True(t, report.Corruptions[0].Truncated)
+	assert.Equal(t, io.ErrUnexpectedEOF, report.Corruptions[0].Err)
+	truncated, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.Size(), truncated.Size())
+	assert.Nil(t, db.Put([]byte("name"), []byte("bitcask-go")))
+	assert.Nil(t, db.Close())
+
+	// case3: clean restart
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db.RecoveryReport().Corruptions))
+	val, err := db.Get([]byte("name"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bitcask-go"), val)
+	assert.Equal(t, uint(101), db.Stat().KeyNum)
+	assert.Nil(t, db.Close())
+
+	// case4: damaged record followed by valid ones is not truncated
+	stat, err = os.Stat(fileName)
+	assert.Nil(t, err)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[len(content)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrInvalidCRC))
+	damaged, err := os.Stat(fileName)
+	assert.Nil(t, err)
+	assert.Equal(t, stat.Size(), damaged.Size())
+	content[len(content)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
+
+func TestDB_Recovery_Skip(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-2")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 200; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	lastFid := db.activeFile.FileId
+	assert.True(t, lastFid > 0)
+	assert.Nil(t, db.Close())
+
+	// corrupt a record in the first data file, and tear the last one
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[len(content)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+	appendToFile(t, data.GetDataFileName(dir, lastFid), []byte("torn"))
+
+	// case1: corrupted record of older file fails truncate policy
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrInvalidCRC))
+
+	// case2: skip
+	opts.Recovery = RecoverySkip
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.Equal(t, 2, len(report.Corruptions))
+	assert.Equal(t, uint32(0), report.Corruptions[0].FileId)
+	assert.False(t, report.Corruptions[0].Truncated)
+	assert.Equal(t, data.ErrInvalidCRC, report.Corruptions[0].Err)
+	assert.True(t, report.Corruptions[1].Truncated)
+	assert.Equal(t, int64(4), report.Corruptions[1].Size)
+	assert.Equal(t, report.Corruptions[0].Size+4, report.DiscardedBytes)
+
+	// only the corrupted record is lost
+	lost := 0
+	for i, v := range values {
+		val, err := db.Get(utils.GetTestKey(i))
+		if err == ErrKeyNotFound {
+			lost++
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, v, val)
+	}
+	assert.Equal(t, 1, lost)
+	assert.Equal(t, report.Corruptions[0].Size, db.Stat().ReclaimSize)
+
+	// case3: changes and compaction skip the corrupted record
+	assert.Equal(t, 199, countChanges(t, db))
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db.RecoveryReport().Corruptions))
+	assert.Equal(t, 199, len(db.ListKeys()))
+}
+
+func TestDB_Recovery_CorruptedHeader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-recovery-3")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	pos := db.index.Get(utils.GetTestKey(10))
+	assert.Equal(t, uint32(0), pos.Fid)
+	assert.Nil(t, db.Close())
+	corruptKeySize(t, dir, pos.Fid, pos.Offset)
+
+	// case1: the record states a size past the end of file, but it is not a torn tail
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrInvalidCRC))
+
+	// case2: skip to the next valid record
+	opts.Recovery = RecoverySkip
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	report := db.RecoveryReport()
+	assert.Equal(t, 1, len(report.Corruptions))
+	assert.Equal(t, data.ErrInvalidCRC, report.Corruptions[0].Err)
+	assert.Equal(t, pos.Offset, report.Corruptions[0].Offset)
+	assert.False(t, report.Corruptions[0].Truncated)
+	assert.Equal(t, 199, len(db.ListKeys()))
+
+	// case3: changes and compaction read the records after it
+	assert.Equal(t, 199, countChanges(t, db))
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(db.RecoveryReport().Corruptions))
+	for i := 0; i < 200; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i == 10 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
+
+// number of changes since the start of db
+func countChanges(t *testing.T, db *DB) int {
+	it, err := db.ChangesSince(0)
+	assert.Nil(t, err)
+	defer it.Close()
+	n := 0
+	for ; it.Valid(); it.Next() {
+		n++
+	}
+	assert.Nil(t, it.Err())
+	return n
+}
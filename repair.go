@@ -0,0 +1,336 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/gofrs/flock"
+)
+
+// originals of the files rewritten by RepairDir are moved to it, under the db dir
+const LostFoundDirName = "lost+found"
+
+// data file rewritten by RepairDir
+type RepairedFile struct {
+	FileId      uint32
+	Records     int          // salvaged records
+	Corruptions []Corruption // skipped data of the original file
+}
+
+// result of RepairDir
+type RepairReport struct {
+	LostFoundDir string // originals of the repaired files are moved to it, empty if nothing is repaired
+	Files        []RepairedFile
+	HintRebuilt  bool // hint file is rebuilt from the merged data files
+	// old versions kept by merge for GetAt, which are carried over to the rebuilt hint file,
+	// or lost as their records are corrupted, versions in the corrupted part of hint file are not counted
+	VersionsKept int
+	VersionsLost int
+}
+
+// salvage the valid records of damaged data files offline, the db must not be opened by any process.
+// corrupted data is skipped to the next record whose crc is valid, the salvaged records are written
+// into a fresh file of the same id, and the original one is moved to lost+found.
+// hint file is rebuilt if it is damaged or some merged data files are repaired,
+// and the old versions kept by merge for GetAt are carried over unless their records are lost.
+// records of the batches whose finish record is lost are discarded by the next Open, and blob files are not repaired.
+// options carries the encryption key, and the dir is options.DirPath if dirPath is empty
+func RepairDir(dirPath string, options Options) (*RepairReport, error) {
+	if dirPath != "" {
+		options.DirPath = dirPath
+	}
+	if err := CheckOptions(options); err != nil {
+		return nil, err
+	}
+	if options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	// positions in B+ Tree index file are changed by repair
+	if options.IndexType == BPtree {
+		return nil, ErrRepairUnsupported
+	}
+	if _, err := os.Stat(options.DirPath); err != nil {
+		return nil, err
+	}
+
+	// neither the writer nor read-only db is using the files
+	fileLock, hold, err := lockDir(options)
+	if err != nil {
+		return nil, err
+	}
+	defer fileLock.Close()
+	if !hold {
+		return nil, ErrDatabaseIsBeingUsed
+	}
+	defer fileLock.Unlock()
+	readerLock := flock.New(filepath.Join(options.DirPath, readerLockName))
+	defer readerLock.Close()
+	if hold, err := readerLock.TryLock(); err != nil || !hold {
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrDatabaseIsBeingUsed
+	}
+	defer readerLock.Unlock()
+
+	db := &DB{options: options, keys: keyProviderOf(options)}
+	if err := db.loadDBId(); err != nil {
+		return nil, err
+	}
+	return db.repair()
+}
+
+func (db *DB) repair() (*RepairReport, error) {
+	report := &RepairReport{}
+	fileIds, err := db.dataFileIds()
+	if err != nil {
+		return nil, err
+	}
+	nonMergeFileId, err := db.getNonMergeFileId(db.options.DirPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// fresh files are written in tmp dir first, then moved in place of the originals
+	lostFoundPath := filepath.Join(db.options.DirPath, LostFoundDirName, strconv.FormatInt(time.Now().UnixNano(), 10))
+	tmpPath := filepath.Join(lostFoundPath, "tmp")
+	defer os.RemoveAll(tmpPath)
+	// move the original to lost+found, and the fresh one in place of it
+	replace := func(fileName string) error {
+		if err := os.Rename(filepath.Join(db.options.DirPath, fileName), filepath.Join(lostFoundPath, fileName)); err != nil {
+			return err
+		}
+		return os.Rename(filepath.Join(tmpPath, fileName), filepath.Join(db.options.DirPath, fileName))
+	}
+
+	rebuildHint := false
+	for _, fid := range fileIds {
+		repaired, err := db.repairDataFile(uint32(fid), tmpPath)
+		if err != nil {
+			return nil, err
+		}
+		if repaired == nil {
+			continue
+		}
+		if err := replace(data.GetDataFileName("", uint32(fid))); err != nil {
+			return nil, err
+		}
+		report.Files = append(report.Files, *repaired)
+		if uint32(fid) < nonMergeFileId {
+			rebuildHint = true
+		}
+	}
+
+	hintFileName := filepath.Join(db.options.DirPath, data.HintFileName)
+	if _, err := os.Stat(hintFileName); err == nil {
+		if !rebuildHint {
+			if rebuildHint, err = db.isDamaged(func() (*data.DataFile, error) {
+				return data.OpenHintFile(db.options.DirPath, db.fileOptions)
+			}); err != nil {
+				return nil, err
+			}
+		}
+		if rebuildHint {
+			if err := db.rebuildHintFile(tmpPath, nonMergeFileId, report); err != nil {
+				return nil, err
+			}
+			if err := replace(data.HintFileName); err != nil {
+				return nil, err
+			}
+			report.HintRebuilt = true
+		}
+	}
+
+	if len(report.Files) > 0 || report.HintRebuilt {
+		report.LostFoundDir = lostFoundPath
+	}
+	return report, nil
+}
+
+// write the salvaged records of data file into a fresh file in tmpPath, nil if the file is not damaged
+func (db *DB) repairDataFile(fid uint32, tmpPath string) (*RepairedFile, error) {
+	dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFIO, db.fileOptions)
+	if err != nil {
+		return nil, fileHeaderError(err, data.GetDataFileName(db.options.DirPath, fid))
+	}
+	defer dataFile.Close()
+	size, err := dataFile.IOManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	corruptions, err := scanDataFile(dataFile, size, func(*data.LogRecord, int64, int64) {})
+	if err != nil || len(corruptions) == 0 {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(tmpPath, os.ModePerm); err != nil {
+		return nil, err
+	}
+	freshFile, err := data.OpenDataFile(tmpPath, fid, fio.StandardFIO, db.fileOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer freshFile.Close()
+	repaired := &RepairedFile{FileId: fid, Corruptions: corruptions}
+	var writeErr error
+	if _, err := scanDataFile(dataFile, size, func(lr *data.LogRecord, _, _ int64) {
+		if writeErr != nil {
+			return
+		}
+		encRecord, _ := data.EncodeLogRecord(lr)
+		writeErr = freshFile.Write(encRecord)
+		repaired.Records++
+	}); err != nil {
+		return nil, err
+	}
+	if writeErr != nil {
+		return nil, writeErr
+	}
+	if err := freshFile.Sync(); err != nil {
+		return nil, err
+	}
+	return repaired, nil
+}
+
+// if the file opened by open has corrupted data
+func (db *DB) isDamaged(open func() (*data.DataFile, error)) (bool, error) {
+	dataFile, err := open()
+	if err != nil {
+		return false, err
+	}
+	defer dataFile.Close()
+	size, err := dataFile.IOManager.Size()
+	if err != nil {
+		return false, err
+	}
+	corruptions, err := scanDataFile(dataFile, size, func(*data.LogRecord, int64, int64) {})
+	return len(corruptions) > 0, err
+}
+
+// valid record of key in merged data files, for rebuilding hint file
+type hintEntry struct {
+	typ    data.LogRecordType
+	key    []byte
+	pos    *data.LogRecordPos
+	record *data.LogRecord
+}
+
+// old version in hint file
+type hintVersion struct {
+	key   string
+	seqNo uint64
+}
+
+// old versions saved in hint file by merge, the value of a deleted version is empty
+func (db *DB) readHintVersions() (map[hintVersion]*data.LogRecord, error) {
+	hintFile, err := data.OpenHintFile(db.options.DirPath, db.fileOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer hintFile.Close()
+	size, err := hintFile.IOManager.Size()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[hintVersion]*data.LogRecord)
+	_, err = scanDataFile(hintFile, size, func(lr *data.LogRecord, _, _ int64) {
+		if lr.Type == data.LogRecordHistory {
+			versions[hintVersion{key: string(lr.Key), seqNo: lr.SeqNo}] = lr
+		}
+	})
+	return versions, err
+}
+
+// rebuild hint file in tmpPath from the data files merged before nonMergeFileId, and carry over the old versions of it.
+// the last record of key is valid, as merge writes the valid record after the invalid ones kept for changes,
+// except the old versions in hint file
+func (db *DB) rebuildHintFile(tmpPath string, nonMergeFileId uint32, report *RepairReport) error {
+	versions, err := db.readHintVersions()
+	if err != nil {
+		return err
+	}
+	var history []*hintEntry
+	var keys []string
+	entries := make(map[string]*hintEntry)
+	for fid := uint32(0); fid < nonMergeFileId; fid++ {
+		if _, err := os.Stat(data.GetDataFileName(db.options.DirPath, fid)); os.IsNotExist(err) {
+			continue
+		}
+		dataFile, err := data.OpenDataFile(db.options.DirPath, fid, fio.StandardFIO, db.fileOptions)
+		if err != nil {
+			return err
+		}
+		size, err := dataFile.IOManager.Size()
+		if err != nil {
+			_ = dataFile.Close()
+			return err
+		}
+		_, err = scanDataFile(dataFile, size, func(lr *data.LogRecord, offset, recordSize int64) {
+			realKey, _ := parseLogRecordKey(lr.Key)
+			key := strconv.FormatUint(uint64(lr.Namespace), 10) + "/" + string(realKey)
+			if _, ok := entries[key]; !ok {
+				keys = append(keys, key)
+				entries[key] = nil
+			}
+			switch {
+			case lr.Type == data.LogRecordNormal || isBlobRecord(lr.Type):
+				typ := data.LogRecordNormal
+				if isBlobRecord(lr.Type) {
+					typ = data.LogRecordBlob
+				}
+				pos := &data.LogRecordPos{Fid: fid, Offset: offset, Size: uint32(recordSize), Expire: lr.Expire}
+				version := hintVersion{key: string(realKey), seqNo: lr.SeqNo}
+				if v, ok := versions[version]; ok && lr.Namespace == defaultNamespaceId && len(v.Value) > 0 {
+					history = append(history, &hintEntry{typ: data.LogRecordHistory, key: realKey, pos: pos, record: lr})
+					delete(versions, version)
+					return
+				}
+				entries[key] = &hintEntry{typ: typ, key: realKey, pos: pos, record: lr}
+			case lr.Type == data.LogRecordDeleted:
+				entries[key] = nil
+			}
+		})
+		_ = dataFile.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(tmpPath, os.ModePerm); err != nil {
+		return err
+	}
+	hintFile, err := data.OpenHintFile(tmpPath, db.fileOptions)
+	if err != nil {
+		return err
+	}
+	defer hintFile.Close()
+	for _, e := range history {
+		if err := hintFile.WriteHintRecord(e.typ, e.key, e.pos, e.record); err != nil {
+			return err
+		}
+	}
+	report.VersionsKept = len(history)
+	// deleted versions are only saved in hint file, and the others left are lost with their records
+	for _, v := range versions {
+		if len(v.Value) > 0 {
+			report.VersionsLost++
+			continue
+		}
+		if err := hintFile.WriteHintRecord(data.LogRecordHistory, v.Key, nil, v); err != nil {
+			return err
+		}
+		report.VersionsKept++
+	}
+	for _, key := range keys {
+		if e := entries[key]; e != nil {
+			if err := hintFile.WriteHintRecord(e.typ, e.key, e.pos, e.record); err != nil {
+				return err
+			}
+		}
+	}
+	return hintFile.Sync()
+}
@@ -0,0 +1,215 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepairDir(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	values := make(map[int][]byte)
+	for i := 0; i < 200; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	assert.Nil(t, db.Delete(utils.GetTestKey(0)))
+	delete(values, 0)
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 200; i < 300; i++ {
+		values[i] = utils.RandomValue(64)
+		assert.Nil(t, db.Put(utils.GetTestKey(i), values[i]))
+	}
+	nonMergeFileId, err := db.getNonMergeFileId(dir)
+	assert.Nil(t, err)
+	assert.True(t, db.activeFile.FileId > nonMergeFileId)
+
+	// case1: db is being used
+	_, err = RepairDir(dir, opts)
+	assert.Equal(t, ErrDatabaseIsBeingUsed, err)
+	assert.Nil(t, db.Close())
+
+	// case2: nothing to repair
+	report, err := RepairDir(dir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, "", report.LostFoundDir)
+	assert.Equal(t, 0, len(report.Files))
+
+	// case3: a flipped bit in an older data file makes db unopenable, and the merged one is read by hint file
+	var contents [][]byte
+	for _, fid := range []uint32{0, nonMergeFileId} {
+		fileName := data.GetDataFileName(dir, fid)
+		content, err := os.ReadFile(fileName)
+		assert.Nil(t, err)
+		content[len(content)/2] ^= 0x01
+		assert.Nil(t, os.WriteFile(fileName, content, 0644))
+		contents = append(contents, content)
+	}
+	_, err = Open(opts)
+	assert.True(t, errors.Is(err, data.ErrInvalidCRC))
+
+	report, err = RepairDir(dir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(report.Files))
+	for i, fid := range []uint32{0, nonMergeFileId} {
+		assert.Equal(t, fid, report.Files[i].FileId)
+		assert.True(t, report.Files[i].Records > 0)
+		assert.Equal(t, 1, len(report.Files[i].Corruptions))
+		assert.Equal(t, data.ErrInvalidCRC, report.Files[i].Corruptions[0].Err)
+		original, err := os.ReadFile(filepath.Join(report.LostFoundDir, filepath.Base(data.GetDataFileName(dir, fid))))
+		assert.Nil(t, err)
+		assert.Equal(t, contents[i], original)
+	}
+	assert.True(t, report.HintRebuilt)
+	_, err = os.Stat(filepath.Join(report.LostFoundDir, data.HintFileName))
+	assert.Nil(t, err)
+
+	verified, err := VerifyDir(dir, nil)
+	assert.Nil(t, err)
+	assert.True(t, verified.OK())
+
+	// only the corrupted records are lost
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	lost := 0
+	for i := 0; i < 300; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if v, ok := values[i]; ok && err == nil {
+			assert.Equal(t, v, val)
+			continue
+		}
+		assert.Equal(t, ErrKeyNotFound, err)
+		if i > 0 {
+			lost++
+		}
+	}
+	assert.Equal(t, 2, lost)
+	assert.Nil(t, db.Put([]byte("name"), []byte("bitcask-go")))
+	assert.Nil(t, db.Close())
+
+	// case4: b+ tree
+	opts.IndexType = BPtree
+	_, err = RepairDir(dir, opts)
+	assert.Equal(t, ErrRepairUnsupported, err)
+	opts.IndexType = Btree
+	db, err = Open(opts)
+	assert.Nil(t, err)
+}
+
+func TestRepairDir_Versions(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair-versions")
+	opts.DirPath = dir
+	opts.DataFileMergeRatio = 0
+	opts.VersionRetention = 3
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	filler := utils.RandomValue(64)
+	assert.Nil(t, db.Put([]byte("filler"), filler))
+	for _, v := range []string{"a1", "a2", "a3"} {
+		assert.Nil(t, db.Put([]byte("a"), []byte(v)))
+	}
+	assert.Nil(t, db.Put([]byte("b"), []byte("b1")))
+	assert.Nil(t, db.Delete([]byte("b")))
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Close())
+	// merge files are applied by open
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Close())
+
+	// a flipped bit in the merged data file makes hint file rebuilt
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	i := bytes.Index(content, filler)
+	assert.True(t, i > 0)
+	content[i] ^= 0x01
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+
+	report, err := RepairDir(dir, opts)
+	assert.Nil(t, err)
+	assert.True(t, report.HintRebuilt)
+	assert.Equal(t, 1, len(report.Files))
+	// a1, a2, b1 and the deletion of b
+	assert.Equal(t, 4, report.VersionsKept)
+	assert.Equal(t, 0, report.VersionsLost)
+
+	// old versions are carried over, and the key deleted before merge is still deleted
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	versions, err := db.History([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(versions))
+	for i, v := range []string{"a1", "a2", "a3"} {
+		assert.Equal(t, []byte(v), versions[i].Value)
+	}
+	versions, err = db.History([]byte("b"))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, []byte("b1"), versions[0].Value)
+	assert.True(t, versions[1].Deleted)
+	_, err = db.Get([]byte("b"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get([]byte("filler"))
+	assert.Equal(t, ErrKeyNotFound, err)
+}
+
+func TestRepairDir_CorruptedHeader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-repair-header")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	pos := db.index.Get(utils.GetTestKey(50))
+	assert.Nil(t, db.Close())
+	corruptKeySize(t, dir, pos.Fid, pos.Offset)
+
+	// the records after the corrupted header are salvaged, rather than dropped as a torn tail
+	report, err := RepairDir(dir, opts)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Files))
+	assert.Equal(t, 99, report.Files[0].Records)
+	assert.Equal(t, 1, len(report.Files[0].Corruptions))
+	assert.Equal(t, data.ErrInvalidCRC, report.Files[0].Corruptions[0].Err)
+	assert.Equal(t, pos.Offset, report.Files[0].Corruptions[0].Offset)
+
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 0; i < 100; i++ {
+		val, err := db.Get(utils.GetTestKey(i))
+		if i == 50 {
+			assert.Equal(t, ErrKeyNotFound, err)
+			continue
+		}
+		assert.Nil(t, err)
+		assert.Equal(t, utils.GetTestKey(i), val)
+	}
+}
@@ -0,0 +1,257 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/index"
+)
+
+// get the index keys of a kv, a kv can have zero or more index keys
+type IndexExtractor func(key []byte, value []byte) [][]byte
+
+// secondary index of the default namespace, which lives in memory and is kept up to date
+// with the primary records under db.mu, it is rebuilt from the valid records when registered
+type secondaryIndex struct {
+	extract IndexExtractor
+	entries index.Indexer       // encoded (index key, primary key) -> pos of primary record
+	keys    map[string][][]byte // primary key -> its index keys, to remove old entries
+}
+
+// register a secondary index, and build it from the existing kvs
+// the extractor can not be saved, so register it again after db opens, or set it in Options.SecondaryIndexes
+func (db *DB) RegisterIndex(name string, extract IndexExtractor) error {
+	if len(name) == 0 {
+		return ErrIndexNameIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+	return db.registerIndexLocked(name, extract)
+}
+
+func (db *DB) registerIndexLocked(name string, extract IndexExtractor) error {
+	if _, ok := db.secondaryIndexes[name]; ok {
+		return ErrIndexExists
+	}
+
+	si := &secondaryIndex{
+		extract: extract,
+		entries: index.NewBtree(),
+		keys:    make(map[string][][]byte),
+	}
+	it := db.index.Iterator(false)
+	defer it.Close()
+	for it.Rewind(); it.Valid(); it.Next() {
+		pos := it.Value()
+		if pos.IsExpired() {
+			continue
+		}
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return err
+		}
+		// copy key, bptree key is only valid in its read transaction
+		si.put(append([]byte(nil), it.Key()...), value, pos)
+	}
+
+	db.secondaryIndexes[name] = si
+	return nil
+}
+
+// get the primary keys of index key, in order
+func (db *DB) LookupIndex(name string, indexKey []byte) ([][]byte, error) {
+	// indexKey + 0x00 is the smallest index key greater than indexKey
+	it, err := db.NewIndexIterator(name, IteratorOptions{
+		LowerBound: indexKey,
+		UpperBound: append(append([]byte(nil), indexKey...), 0),
+		KeysOnly:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer it.Close()
+
+	var keys [][]byte
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	return keys, nil
+}
+
+// update secondary indexes with the committed write of key, pos is nil if key is deleted, under db.mu
+func (db *DB) updateSecondaryIndexes(key []byte, value []byte, pos *data.LogRecordPos) {
+	for _, si := range db.secondaryIndexes {
+		si.remove(key)
+		if pos != nil {
+			si.put(key, value, pos)
+		}
+	}
+}
+
+func (si *secondaryIndex) put(key []byte, value []byte, pos *data.LogRecordPos) {
+	var indexKeys [][]byte
+	for _, indexKey := range si.extract(key, value) {
+		// index key may be a part of value, which will be reused by user
+		indexKey = append([]byte(nil), indexKey...)
+		si.entries.Put(encodeIndexEntry(indexKey, key), pos)
+		indexKeys = append(indexKeys, indexKey)
+	}
+	if len(indexKeys) > 0 {
+		si.keys[string(key)] = indexKeys
+	}
+}
+
+func (si *secondaryIndex) remove(key []byte) {
+	for _, indexKey := range si.keys[string(key)] {
+		si.entries.Delete(encodeIndexEntry(indexKey, key))
+	}
+	delete(si.keys, string(key))
+}
+
+// iterator over the entries of secondary index, in the order of (index key, primary key)
+// bounds and prefix of opts apply to index keys
+type IndexIterator struct {
+	indexIter index.Iterator
+	db        *DB
+	opts      IteratorOptions
+	indexKey  []byte
+	key       []byte
+	closed    bool
+}
+
+// iterate over the entries of secondary index name, close the iterator after use, db.Close waits for it
+func (db *DB) NewIndexIterator(name string, opts IteratorOptions) (*IndexIterator, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	si, ok := db.secondaryIndexes[name]
+	if !ok {
+		return nil, ErrIndexNotFound
+	}
+
+	var lowerBound, upperBound []byte
+	lower, upper := opts.bounds()
+	if lower != nil {
+		lowerBound = escapeIndexKey(lower)
+	}
+	if upper != nil {
+		upperBound = escapeIndexKey(upper)
+	}
+	db.inflight.Add(1)
+	it := &IndexIterator{
+		indexIter: si.entries.RangeIterator(opts.Reverse, lowerBound, upperBound),
+		db:        db,
+		opts:      opts,
+	}
+	it.skipExpired()
+	return it, nil
+}
+
+// go back to the first entry of iterator
+func (it *IndexIterator) Rewind() {
+	it.indexIter.Rewind()
+	it.skipExpired()
+}
+
+// find the first entry whose index key is >= or <=(reverse) indexKey
+func (it *IndexIterator) Seek(indexKey []byte) {
+	if it.opts.Reverse {
+		// after all the entries of indexKey
+		it.indexIter.Seek(append(escapeIndexKey(indexKey), 0, 2))
+	} else {
+		it.indexIter.Seek(escapeIndexKey(indexKey))
+	}
+	it.skipExpired()
+}
+
+func (it *IndexIterator) Next() {
+	it.indexIter.Next()
+	it.skipExpired()
+}
+
+func (it *IndexIterator) Valid() bool {
+	return it.indexIter.Valid()
+}
+
+// get index key of current entry
+func (it *IndexIterator) IndexKey() []byte {
+	return it.indexKey
+}
+
+// get primary key of current entry
+func (it *IndexIterator) Key() []byte {
+	return it.key
+}
+
+// get value of primary key of current entry
+func (it *IndexIterator) Value() ([]byte, error) {
+	if it.opts.KeysOnly {
+		return nil, ErrIteratorKeysOnly
+	}
+	it.db.mu.RLock()
+	defer it.db.mu.RUnlock()
+	return it.db.getValueByPosition(it.indexIter.Value())
+}
+
+// close iterator and release resources, it can be called repeatedly
+func (it *IndexIterator) Close() {
+	if it.closed {
+		return
+	}
+	it.closed = true
+	it.indexIter.Close()
+	it.db.inflight.Done()
+}
+
+// expired primary keys are invisible to users
+func (it *IndexIterator) skipExpired() {
+	for ; it.indexIter.Valid(); it.indexIter.Next() {
+		if !it.indexIter.Value().IsExpired() {
+			it.indexKey, it.key = decodeIndexEntry(it.indexIter.Key())
+			return
+		}
+	}
+}
+
+// escaped index key | 0x00 0x01 | primary key
+// 0x00 in index key is escaped as 0x00 0xff, so entries are in the order of index key
+func encodeIndexEntry(indexKey []byte, key []byte) []byte {
+	buf := escapeIndexKey(indexKey)
+	buf = append(buf, 0, 1)
+	return append(buf, key...)
+}
+
+func escapeIndexKey(indexKey []byte) []byte {
+	buf := make([]byte, 0, len(indexKey)+2)
+	for _, b := range indexKey {
+		if b == 0 {
+			buf = append(buf, 0, 0xff)
+		} else {
+			buf = append(buf, b)
+		}
+	}
+	return buf
+}
+
+func decodeIndexEntry(entry []byte) ([]byte, []byte) {
+	indexKey := make([]byte, 0, len(entry))
+	for i := 0; i < len(entry); i++ {
+		if entry[i] != 0 {
+			indexKey = append(indexKey, entry[i])
+			continue
+		}
+		if i+1 < len(entry) && entry[i+1] == 1 {
+			return indexKey, entry[i+2:]
+		}
+		indexKey = append(indexKey, 0)
+		i++
+	}
+	return indexKey, nil
+}
@@ -0,0 +1,163 @@
+package bitcaskminidb
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// value is "city,tag1,tag2...", index by city
+func cityExtractor(key []byte, value []byte) [][]byte {
+	city, _, _ := bytes.Cut(value, []byte(","))
+	if len(city) == 0 {
+		return nil
+	}
+	return [][]byte{city}
+}
+
+// index by every tag
+func tagsExtractor(key []byte, value []byte) [][]byte {
+	parts := bytes.Split(value, []byte(","))
+	return parts[1:]
+}
+
+func TestDB_SecondaryIndex(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-secondary-index-1")
+	opts.DirPath = dir
+	opts.MergeOperator = MergeOperatorAppend
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("alice"), []byte("paris,a,b")))
+	assert.Equal(t, ErrIndexNameIsEmpty, db.RegisterIndex("", cityExtractor))
+	assert.Nil(t, db.RegisterIndex("city", cityExtractor))
+	assert.Nil(t, db.RegisterIndex("tags", tagsExtractor))
+	assert.Equal(t, ErrIndexExists, db.RegisterIndex("city", cityExtractor))
+	_, err = db.LookupIndex("unknown", []byte("paris"))
+	assert.Equal(t, ErrIndexNotFound, err)
+
+	// case1: existing kv is indexed when registering
+	keys, err := db.LookupIndex("city", []byte("paris"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("alice")}, keys)
+
+	// case2: put, overwrite and delete
+	assert.Nil(t, db.Put([]byte("bob"), []byte("paris,b")))
+	assert.Nil(t, db.Put([]byte("carol"), []byte("tokyo,a")))
+	keys, _ = db.LookupIndex("city", []byte("paris"))
+	assert.Equal(t, [][]byte{[]byte("alice"), []byte("bob")}, keys)
+	keys, _ = db.LookupIndex("tags", []byte("a"))
+	assert.Equal(t, [][]byte{[]byte("alice"), []byte("carol")}, keys)
+
+	assert.Nil(t, db.Put([]byte("alice"), []byte("tokyo")))
+	assert.Nil(t, db.Delete([]byte("bob")))
+	keys, _ = db.LookupIndex("city", []byte("paris"))
+	assert.Equal(t, 0, len(keys))
+	keys, _ = db.LookupIndex("city", []byte("tokyo"))
+	assert.Equal(t, [][]byte{[]byte("alice"), []byte("carol")}, keys)
+	keys, _ = db.LookupIndex("tags", []byte("a"))
+	assert.Equal(t, [][]byte{[]byte("carol")}, keys)
+
+	// case3: write batch, merge operand and range deletion
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("dave"), []byte("rome,c")))
+	assert.Nil(t, wb.Delete([]byte("carol")))
+	keys, _ = db.LookupIndex("city", []byte("rome"))
+	assert.Equal(t, 0, len(keys))
+	assert.Nil(t, wb.Commit())
+	keys, _ = db.LookupIndex("city", []byte("rome"))
+	assert.Equal(t, [][]byte{[]byte("dave")}, keys)
+	keys, _ = db.LookupIndex("city", []byte("tokyo"))
+	assert.Equal(t, [][]byte{[]byte("alice")}, keys)
+
+	assert.Nil(t, db.Merge([]byte("dave"), []byte(",d")))
+	keys, _ = db.LookupIndex("tags", []byte("d"))
+	assert.Equal(t, [][]byte{[]byte("dave")}, keys)
+
+	assert.Nil(t, db.DeletePrefix([]byte("d")))
+	keys, _ = db.LookupIndex("city", []byte("rome"))
+	assert.Equal(t, 0, len(keys))
+
+	// case4: restart, indexes in options are rebuilt
+	err = db.Close()
+	assert.Nil(t, err)
+	opts.SecondaryIndexes = map[string]IndexExtractor{"city": cityExtractor}
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	keys, err = db2.LookupIndex("city", []byte("tokyo"))
+	assert.Nil(t, err)
+	assert.Equal(t, [][]byte{[]byte("alice")}, keys)
+	db2.Close()
+}
+
+func TestDB_IndexIterator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-secondary-index-2")
+	opts.DirPath = dir
+	opts.SecondaryIndexes = map[string]IndexExtractor{"city": cityExtractor}
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("k1"), []byte("b")))
+	assert.Nil(t, db.Put([]byte("k2"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("k3"), []byte("c")))
+	assert.Nil(t, db.Put([]byte("k4"), []byte("b")))
+	assert.Nil(t, db.Put([]byte("k5"), []byte("b\x00")))
+
+	collect := func(it *IndexIterator) []string {
+		var res []string
+		for ; it.Valid(); it.Next() {
+			res = append(res, string(it.IndexKey())+":"+string(it.Key()))
+		}
+		it.Close()
+		return res
+	}
+
+	// case1: all entries in the order of index key
+	it, err := db.NewIndexIterator("city", DefaultIteratorOptions)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a:k2", "b:k1", "b:k4", "b\x00:k5", "c:k3"}, collect(it))
+
+	// case2: range of index keys
+	it, _ = db.NewIndexIterator("city", IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("c")})
+	assert.Equal(t, []string{"b:k1", "b:k4", "b\x00:k5"}, collect(it))
+
+	// case3: reverse and seek
+	it, _ = db.NewIndexIterator("city", IteratorOptions{Reverse: true})
+	it.Seek([]byte("b"))
+	assert.Equal(t, []string{"b:k4", "b:k1", "a:k2"}, collect(it))
+
+	// case4: value of primary key
+	it, _ = db.NewIndexIterator("city", IteratorOptions{Prefix: []byte("c")})
+	assert.True(t, it.Valid())
+	val, err := it.Value()
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("c"), val)
+	it.Close()
+
+	// case5: close waits for index iterator
+	it, err = db.NewIndexIterator("city", DefaultIteratorOptions)
+	assert.Nil(t, err)
+	closed := make(chan error)
+	go func() {
+		closed <- db.Close()
+	}()
+	select {
+	case <-closed:
+		t.Fatal("db is closed before index iterator")
+	case <-time.After(100 * time.Millisecond):
+	}
+	it.Close()
+	it.Close()
+	assert.Nil(t, <-closed)
+	_, err = db.NewIndexIterator("city", DefaultIteratorOptions)
+	assert.Equal(t, ErrDBClosed, err)
+}
@@ -0,0 +1,109 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/index"
+	"sync"
+)
+
+// read-only point-in-time view of db, based on the snapshot of index
+type Snapshot struct {
+	mu       *sync.RWMutex
+	db       *DB
+	index    index.Indexer
+	blobFids []uint32 // blob files pinned by the snapshot
+	err      error    // why the snapshot is empty, returned by reading it
+	released bool
+}
+
+// create a snapshot, writes after this will not be seen by the snapshot
+// snapshot of Btree index is a lazy copy-on-write clone, which costs O(1),
+// snapshot of B+ Tree index holds a bbolt read transaction, which costs O(1), but writes growing
+// the index file beyond 256MB wait for it until Release, so release it soon,
+// while ART index is copied as a whole, which costs O(n) time and memory for n keys
+func (db *DB) NewSnapshot() *Snapshot {
+	// write batch updates index under db.mu, so the snapshot will not see half of a batch
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.newSnapshot()
+}
+
+// create a snapshot under db.mu
+func (db *DB) newSnapshot() *Snapshot {
+	// snapshot of closed db is empty, and reading it returns ErrDBClosed
+	if db.closed {
+		return &Snapshot{mu: new(sync.RWMutex), db: db, index: index.NewBtree(), err: ErrDBClosed}
+	}
+	idx, err := db.index.Snapshot()
+	if err != nil {
+		return &Snapshot{mu: new(sync.RWMutex), db: db, index: index.NewBtree(), err: err}
+	}
+	return &Snapshot{
+		mu:    new(sync.RWMutex),
+		db:    db,
+		index: idx,
+		// CompactBlobs does not remove the blob files the snapshot points to
+		blobFids: db.pinBlobFiles(),
+	}
+}
+
+// get value of key at the time the snapshot was taken
+func (s *Snapshot) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.released {
+		return nil, ErrSnapshotReleased
+	}
+	if s.err != nil {
+		return nil, s.err
+	}
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	logRecordPos := s.index.Get(key)
+	if logRecordPos == nil || logRecordPos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+
+	// data files are never deleted while db is running, and blob files are pinned, so old pos is still readable
+	s.db.mu.RLock()
+	defer s.db.mu.RUnlock()
+	return s.db.getValueByPosition(logRecordPos)
+}
+
+// iterate over the keys at the time the snapshot was taken, close it before releasing snapshot
+// the iterator of released snapshot is empty, and its Err is ErrSnapshotReleased
+func (s *Snapshot) NewIterator(opts IteratorOptions) *Iterator {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.released {
+		return closedIterator(s.db, opts, ErrSnapshotReleased)
+	}
+	if s.err != nil {
+		return closedIterator(s.db, opts, s.err)
+	}
+	s.db.mu.Lock()
+	defer s.db.mu.Unlock()
+	return s.db.newIterator(s.index, opts)
+}
+
+// release resources held by snapshot, it can be called repeatedly
+func (s *Snapshot) Release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.released {
+		return nil
+	}
+	s.released = true
+	// close index first, a writer may hold db.mu while waiting for the read transaction of B+ Tree snapshot
+	err := s.index.Close()
+	if len(s.blobFids) > 0 {
+		s.db.mu.Lock()
+		s.db.unpinBlobFiles(s.blobFids)
+		s.db.mu.Unlock()
+	}
+	return err
+}
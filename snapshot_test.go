@@ -0,0 +1,91 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_NewSnapshot(t *testing.T) {
+	for _, typ := range []IndexerType{Btree, ARtree, BPtree} {
+		opts := DefaultOptions
+		dir, _ := os.MkdirTemp("", "bitcask-go-snapshot")
+		opts.DirPath = dir
+		opts.IndexType = typ
+		db, err := Open(opts)
+		assert.Nil(t, err)
+		assert.NotNil(t, db)
+
+		for i := 0; i < 100; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("old"))
+			assert.Nil(t, err)
+		}
+
+		snap := db.NewSnapshot()
+
+		// writes after snapshot
+		for i := 0; i < 50; i++ {
+			err := db.Put(utils.GetTestKey(i), []byte("new"))
+			assert.Nil(t, err)
+		}
+		err = db.Delete(utils.GetTestKey(60))
+		assert.Nil(t, err)
+		err = db.Put(utils.GetTestKey(200), []byte("new"))
+		assert.Nil(t, err)
+
+		// case1: get from snapshot
+		val, err := snap.Get(utils.GetTestKey(10))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), val)
+		val, err = snap.Get(utils.GetTestKey(60))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("old"), val)
+		_, err = snap.Get(utils.GetTestKey(200))
+		assert.Equal(t, ErrKeyNotFound, err)
+
+		// case2: iterate snapshot
+		it := snap.NewIterator(DefaultIteratorOptions)
+		var count int
+		for it.Rewind(); it.Valid(); it.Next() {
+			val, err := it.Value()
+			assert.Nil(t, err)
+			assert.Equal(t, []byte("old"), val)
+			count++
+		}
+		it.Close()
+		assert.Equal(t, 100, count)
+
+		// case3: db sees the new values
+		val, err = db.Get(utils.GetTestKey(10))
+		assert.Nil(t, err)
+		assert.Equal(t, []byte("new"), val)
+
+		// case4: released
+		assert.Nil(t, snap.Release())
+		assert.Nil(t, snap.Release())
+		_, err = snap.Get(utils.GetTestKey(10))
+		assert.Equal(t, ErrSnapshotReleased, err)
+		it = snap.NewIterator(DefaultIteratorOptions)
+		assert.False(t, it.Valid())
+		assert.Equal(t, ErrSnapshotReleased, it.Err())
+		it.Close()
+
+		// case5: snapshot not released does not block Close
+		unreleased := db.NewSnapshot()
+		assert.Nil(t, db.Close())
+		assert.Nil(t, unreleased.Release())
+
+		// case6: snapshot of closed db
+		snap = db.NewSnapshot()
+		_, err = snap.Get(utils.GetTestKey(10))
+		assert.Equal(t, ErrDBClosed, err)
+		it = snap.NewIterator(DefaultIteratorOptions)
+		assert.False(t, it.Valid())
+		assert.Equal(t, ErrDBClosed, it.Err())
+		assert.Nil(t, snap.Release())
+
+		destroyDB(db)
+	}
+}
@@ -0,0 +1,250 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// prefix of the temp files of PutStream in db dir
+const streamSpoolPrefix = "stream-spool-"
+
+// put the value of size read from r, the value is never held in memory as a whole
+// it is copied to a temp file in db dir first without lock, so a slow r does not block other writes
+// the value is not compressed, so GetReader can read it in place, but encrypted record is sealed as a whole in memory
+func (db *DB) PutStream(key []byte, r io.Reader, size int64) error {
+	if db.options.ReadOnly {
+		return ErrReadOnly
+	}
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	if size < 0 {
+		return ErrInvalidValueSize
+	}
+
+	// the value may be as large as data files, so it is spooled on the same disk as them
+	spool, err := os.CreateTemp(db.options.DirPath, streamSpoolPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = spool.Close()
+		_ = os.Remove(spool.Name())
+	}()
+	if _, err := io.CopyN(spool, r, size); err != nil {
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return ErrDBClosed
+	}
+
+	logRecord := &data.LogRecord{
+		Key:   logRecordKeyWithSeq(key, noTransactionSeqNo),
+		Type:  data.LogRecordNormal,
+		SeqNo: db.commitSeqNo + 1,
+	}
+	pos, err := db.appendLogRecordFrom(logRecord, spool, size)
+	if err != nil {
+		return err
+	}
+
+	// watchers and secondary indexes get the whole value
+	var value []byte
+	if db.isWatched(key) || len(db.secondaryIndexes) > 0 {
+		if value, err = db.getValueByPosition(pos); err != nil {
+			return err
+		}
+	}
+	db.applyWriteLocked(key, value, logRecord, pos)
+	return nil
+}
+
+// remove the temp files left by PutStream when the process crashed, only for the writer
+func (db *DB) removeStreamSpools() error {
+	entries, err := os.ReadDir(db.options.DirPath)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), streamSpoolPrefix) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(db.options.DirPath, entry.Name())); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// append the normal log record whose value of valueSize is read from r, under db.mu
+func (db *DB) appendLogRecordFrom(logRecord *data.LogRecord, r io.ReadSeeker, valueSize int64) (*data.LogRecordPos, error) {
+	logRecord.Timestamp = time.Now().UnixNano()
+
+	// large value goes to blob file, and the record keeps the pos of it
+	if db.options.ValueThreshold > 0 && valueSize > db.options.ValueThreshold {
+		realKey, _ := parseLogRecordKey(logRecord.Key)
+		blob := &data.LogRecord{
+			Key:       realKey,
+			SeqNo:     logRecord.SeqNo,
+			Namespace: logRecord.Namespace,
+			Timestamp: logRecord.Timestamp,
+		}
+		head := data.EncodeLogRecordHead(blob, valueSize)
+		blobPos, err := db.writeActiveBlobFile(int64(len(head))+valueSize, func(blobFile *data.DataFile) error {
+			return blobFile.WriteLogRecordFrom(head, r, valueSize)
+		})
+		if err != nil {
+			return nil, err
+		}
+		logRecord.Value = data.EncodeBlobPos(blobPos)
+		logRecord.Type = data.LogRecordBlob
+		pos, err := db.AppendLogRecord(logRecord)
+		if err != nil {
+			return nil, err
+		}
+		db.blobRefs[recordPosition{fid: pos.Fid, offset: pos.Offset}] = blobPos
+		return pos, nil
+	}
+
+	if db.activeFile == nil {
+		if err := db.SetActiveDataFile(); err != nil {
+			return nil, err
+		}
+	}
+	head := data.EncodeLogRecordHead(logRecord, valueSize)
+	writeOff, size, err := db.writeActiveFile(int64(len(head))+valueSize, func(dataFile *data.DataFile) error {
+		return dataFile.WriteLogRecordFrom(head, r, valueSize)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &data.LogRecordPos{Fid: db.activeFile.FileId, Offset: writeOff, Size: uint32(size)}, nil
+}
+
+// get a reader of the value of key, which reads from data file or blob file without holding the whole value,
+// crc is checked when the value has been read in order to the end, then Read returns data.ErrInvalidCRC if it mismatches
+// the files are kept until the reader is closed, and db.Close waits for it
+// compressed or encrypted value is read as a whole when the reader is created
+func (db *DB) GetReader(key []byte) (io.ReadSeekCloser, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+
+	pos := db.index.Get(key)
+	if pos == nil || pos.IsExpired() {
+		return nil, ErrKeyNotFound
+	}
+	vr, blobPos, err := db.newValueReader(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := &valueReader{db: db, ReadSeeker: vr}
+	if vr == nil {
+		value, err := db.getValueByPosition(pos)
+		if err != nil {
+			return nil, err
+		}
+		reader.ReadSeeker = bytes.NewReader(value)
+	} else if blobPos != nil {
+		// CompactBlobs does not remove the blob file being read
+		reader.blobFid, reader.pinned = blobPos.Fid, true
+		db.blobPins[blobPos.Fid]++
+	}
+
+	db.inflight.Add(1)
+	return reader, nil
+}
+
+// reader of the value at pos in data file or blob file, blobPos is not nil if it is in blob file, under db.mu
+// the reader is nil if the value can not be read in place, which is encrypted, compressed, or resolved from merge operands
+func (db *DB) newValueReader(pos *data.LogRecordPos) (*data.ValueReader, *data.BlobPos, error) {
+	dataFile := db.dataFileOf(pos.Fid)
+	if dataFile == nil {
+		return nil, nil, ErrDataFileNotFound
+	}
+	if dataFile.Encrypted() {
+		return nil, nil, nil
+	}
+	vr, err := dataFile.NewValueReader(pos.Offset)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch vr.Type() {
+	case data.LogRecordMerge:
+		return nil, nil, nil
+	case data.LogRecordBlob, data.LogRecordBlobMoved:
+		encBlobPos, err := io.ReadAll(vr)
+		if err != nil {
+			return nil, nil, err
+		}
+		if encBlobPos, err = data.Decompress(vr.Codec(), encBlobPos); err != nil {
+			return nil, nil, err
+		}
+		blobPos := data.DecodeBlobPos(encBlobPos)
+		blobFile, ok := db.blobFiles[blobPos.Fid]
+		if !ok {
+			return nil, nil, ErrDataFileNotFound
+		}
+		if blobFile.Encrypted() {
+			return nil, nil, nil
+		}
+		if vr, err = blobFile.NewValueReader(blobPos.Offset); err != nil {
+			return nil, nil, err
+		}
+		if vr.Codec() != data.CodecNone {
+			return nil, nil, nil
+		}
+		return vr, blobPos, nil
+	}
+
+	if vr.Codec() != data.CodecNone {
+		return nil, nil, nil
+	}
+	return vr, nil, nil
+}
+
+// reader returned by GetReader
+type valueReader struct {
+	io.ReadSeeker
+	db      *DB
+	blobFid uint32
+	pinned  bool // blob file is pinned
+	once    sync.Once
+}
+
+// release the files, it can be called repeatedly
+func (r *valueReader) Close() error {
+	r.once.Do(func() {
+		if r.pinned {
+			r.db.mu.Lock()
+			if r.db.blobPins[r.blobFid]--; r.db.blobPins[r.blobFid] == 0 {
+				delete(r.db.blobPins, r.blobFid)
+			}
+			r.db.mu.Unlock()
+		}
+		r.db.inflight.Done()
+	})
+	return nil
+}
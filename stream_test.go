@@ -0,0 +1,188 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/utils"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reader which records if the spool file of PutStream is in dir while it is read
+type spoolCheckReader struct {
+	r     io.Reader
+	dir   string
+	found bool
+}
+
+func (s *spoolCheckReader) Read(p []byte) (int, error) {
+	entries, _ := os.ReadDir(s.dir)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), streamSpoolPrefix) {
+			s.found = true
+		}
+	}
+	return s.r.Read(p)
+}
+
+func TestDB_PutStream_GetReader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-1")
+	opts.DirPath = dir
+	opts.DataFileSize = 64 * 1024
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: value larger than data file
+	value := utils.RandomValue(100 * 1024)
+	small := utils.RandomValue(1024)
+	assert.Nil(t, db.Put(utils.GetTestKey(1), small))
+	spoolReader := &spoolCheckReader{r: bytes.NewReader(value), dir: dir}
+	assert.Nil(t, db.PutStream(utils.GetTestKey(2), spoolReader, int64(len(value))))
+	// value is spooled in db dir, and the spool is removed after put
+	assert.True(t, spoolReader.found)
+	spools, err := filepath.Glob(filepath.Join(dir, streamSpoolPrefix+"*"))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(spools))
+	val, err := db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+
+	r, err := db.GetReader(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	res, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, value, res)
+	_, err = r.Seek(1000, io.SeekStart)
+	assert.Nil(t, err)
+	buf := make([]byte, 100)
+	_, err = io.ReadFull(r, buf)
+	assert.Nil(t, err)
+	assert.Equal(t, value[1000:1100], buf)
+	assert.Nil(t, r.Close())
+	assert.Nil(t, r.Close())
+
+	// case2: empty value, and value of Put
+	assert.Nil(t, db.PutStream(utils.GetTestKey(3), bytes.NewReader(nil), 0))
+	r, err = db.GetReader(utils.GetTestKey(3))
+	assert.Nil(t, err)
+	res, err = io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(res))
+	assert.Nil(t, r.Close())
+	r, err = db.GetReader(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	res, err = io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, small, res)
+	assert.Nil(t, r.Close())
+
+	// case3: invalid input
+	assert.Equal(t, io.ErrUnexpectedEOF, db.PutStream(utils.GetTestKey(4), bytes.NewReader(value[:10]), 20))
+	assert.Equal(t, ErrInvalidValueSize, db.PutStream(utils.GetTestKey(4), bytes.NewReader(nil), -1))
+	assert.Equal(t, ErrKeyIsEmpty, db.PutStream(nil, bytes.NewReader(nil), 0))
+	_, err = db.GetReader(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// case4: restart, spool left by a crash is removed
+	assert.Nil(t, db.Close())
+	leftover := filepath.Join(dir, streamSpoolPrefix+"123")
+	assert.Nil(t, os.WriteFile(leftover, value, 0644))
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, value, val)
+	_, err = os.Stat(leftover)
+	assert.True(t, os.IsNotExist(err))
+
+	// case5: close waits for open readers
+	r, err = db2.GetReader(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	closed := make(chan struct{})
+	go func() {
+		_ = db2.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatal("close returned before the reader is closed")
+	case <-time.After(100 * time.Millisecond):
+	}
+	res, err = io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, value, res)
+	assert.Nil(t, r.Close())
+	<-closed
+}
+
+func TestDB_GetReader_Blob(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-2")
+	opts.DirPath = dir
+	opts.ValueThreshold = 128
+	opts.BlobFileGCRatio = 0
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: large value goes to blob file
+	value := utils.RandomValue(4096)
+	assert.Nil(t, db.PutStream([]byte("a"), bytes.NewReader(value), int64(len(value))))
+	assert.Equal(t, uint(1), db.Stat().BlobFileNum)
+	r, err := db.GetReader([]byte("a"))
+	assert.Nil(t, err)
+
+	// case2: blob file being read is not removed by CompactBlobs
+	value2 := utils.RandomValue(4096)
+	assert.Nil(t, db.Put([]byte("a"), value2))
+	assert.Nil(t, db.CompactBlobs())
+	res, err := io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, value, res)
+	assert.Nil(t, r.Close())
+	assert.Nil(t, db.CompactBlobs())
+	r, err = db.GetReader([]byte("a"))
+	assert.Nil(t, err)
+	res, err = io.ReadAll(r)
+	assert.Nil(t, err)
+	assert.Equal(t, value2, res)
+	assert.Nil(t, r.Close())
+	assert.Equal(t, uint(1), db.Stat().BlobFileNum)
+}
+
+func TestDB_GetReader_CRC(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-stream-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	value := utils.RandomValue(1024)
+	assert.Nil(t, db.Put([]byte("a"), value))
+
+	// corrupt the last byte of the value
+	f, err := os.OpenFile(data.GetDataFileName(dir, 0), os.O_RDWR, 0644)
+	assert.Nil(t, err)
+	stat, _ := f.Stat()
+	_, err = f.WriteAt([]byte{value[len(value)-1] ^ 0xff}, stat.Size()-1)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	r, err := db.GetReader([]byte("a"))
+	assert.Nil(t, err)
+	_, err = io.ReadAll(r)
+	assert.Equal(t, data.ErrInvalidCRC, err)
+	assert.Nil(t, r.Close())
+}
@@ -0,0 +1,410 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bytes"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// optimistic serializable read-write transaction
+// reads see the db at the time the transaction began and the writes of the transaction itself,
+// and commit fails with ErrTxnConflict if any key read or any key range scanned by the transaction
+// has been changed by others after it began
+type Txn struct {
+	mu            *sync.Mutex
+	db            *DB
+	snap          *Snapshot                  // db when the transaction began, released when it finishes
+	startSeqNo    uint64                     // db.seqNo when the transaction began
+	readKeys      map[string]struct{}        // keys read by the transaction, checked when committing
+	readRanges    []*keyRange                // key ranges scanned by iterators, checked when committing
+	pendingWrites map[string]*data.LogRecord // buffered writes, invisible to others until committed
+	finished      bool                       // committed or discarded
+}
+
+// key range [start, end) or [start, end], nil start or end means unbounded
+type keyRange struct {
+	start        []byte
+	end          []byte
+	endInclusive bool
+}
+
+// whether key is in the range
+func (r *keyRange) contains(key []byte) bool {
+	if r.start != nil && bytes.Compare(key, r.start) < 0 {
+		return false
+	}
+	if r.end == nil {
+		return true
+	}
+	cmp := bytes.Compare(key, r.end)
+	return cmp < 0 || (cmp == 0 && r.endInclusive)
+}
+
+// begin a transaction, Commit or Discard it after use
+// the transaction reads a snapshot of db, see NewSnapshot for its cost
+func (db *DB) Begin() (*Txn, error) {
+	if db.options.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	if db.options.IndexType == BPtree && !db.seqNoFileExists && !db.isInitial {
+		return nil, ErrTxnUnsupported
+	}
+
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if db.closed {
+		return nil, ErrDBClosed
+	}
+	snap := db.newSnapshot()
+	if snap.err != nil {
+		return nil, snap.err
+	}
+
+	db.activeTxns++
+	return &Txn{
+		mu:            new(sync.Mutex),
+		db:            db,
+		snap:          snap,
+		startSeqNo:    db.seqNo,
+		readKeys:      make(map[string]struct{}),
+		pendingWrites: make(map[string]*data.LogRecord),
+	}, nil
+}
+
+// record the latest commit of key, under db.mu
+func (db *DB) markKeyCommitted(key []byte, seqNo uint64) {
+	// nobody cares about the commit if there is no running transaction
+	if db.activeTxns == 0 {
+		return
+	}
+	db.keyCommitSeqNos[string(key)] = seqNo
+}
+
+// single write (not in batch) is committed at once, running transactions should know it, under db.mu
+func (db *DB) markSingleWriteCommitted(key []byte) {
+	if db.activeTxns > 0 {
+		db.markKeyCommitted(key, atomic.AddUint64(&db.seqNo, 1))
+	}
+}
+
+// called when a transaction is committed or discarded, under db.mu
+func (db *DB) finishTxn() {
+	db.activeTxns--
+	if db.activeTxns == 0 {
+		db.keyCommitSeqNos = make(map[string]uint64)
+	}
+}
+
+// get value of key, pending writes of the transaction come first
+func (txn *Txn) Get(key []byte) ([]byte, error) {
+	if len(key) == 0 {
+		return nil, ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return nil, ErrTxnFinished
+	}
+
+	if lr := txn.pendingWrites[string(key)]; lr != nil {
+		if lr.Type == data.LogRecordDeleted {
+			return nil, ErrKeyNotFound
+		}
+		return lr.Value, nil
+	}
+
+	// key not found is a read as well, someone may put it later
+	txn.readKeys[string(key)] = struct{}{}
+	return txn.snap.Get(key)
+}
+
+// put kv to transaction
+func (txn *Txn) Put(key []byte, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:   key,
+		Value: value,
+		Type:  data.LogRecordNormal,
+	}
+	return nil
+}
+
+// delete key in transaction
+func (txn *Txn) Delete(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyIsEmpty
+	}
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnFinished
+	}
+
+	txn.pendingWrites[string(key)] = &data.LogRecord{
+		Key:  key,
+		Type: data.LogRecordDeleted,
+	}
+	return nil
+}
+
+// check conflicts and write pending writes atomically
+func (txn *Txn) Commit() error {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return ErrTxnFinished
+	}
+	txn.finished = true
+	// snapshot release locks db.mu, so it runs before db.mu is locked,
+	// and the writes below would wait for the read transaction of B+ Tree snapshot if it were held
+	_ = txn.snap.Release()
+
+	txn.db.mu.Lock()
+	defer txn.db.mu.Unlock()
+	defer txn.db.finishTxn()
+
+	// if any key read by txn was committed by others after txn began, txn is not serializable
+	for key := range txn.readKeys {
+		if txn.db.keyCommitSeqNos[key] > txn.startSeqNo {
+			return ErrTxnConflict
+		}
+	}
+	// so is a key put or deleted in the ranges scanned by txn
+	if len(txn.readRanges) > 0 {
+		for key, seqNo := range txn.db.keyCommitSeqNos {
+			if seqNo <= txn.startSeqNo {
+				continue
+			}
+			for _, r := range txn.readRanges {
+				if r.contains([]byte(key)) {
+					return ErrTxnConflict
+				}
+			}
+		}
+	}
+
+	// deleting a key which does not exist is useless
+	for key, lr := range txn.pendingWrites {
+		if lr.Type == data.LogRecordDeleted && txn.db.index.Get(lr.Key) == nil {
+			delete(txn.pendingWrites, key)
+		}
+	}
+	if len(txn.pendingWrites) == 0 {
+		return nil
+	}
+
+	return txn.db.commitPendingWrites(txn.pendingWrites, txn.db.options.SyncWrites)
+}
+
+// abandon the transaction, it can be called after Commit
+func (txn *Txn) Discard() {
+	txn.mu.Lock()
+	defer txn.mu.Unlock()
+
+	if txn.finished {
+		return
+	}
+	txn.finished = true
+
+	txn.db.mu.Lock()
+	txn.db.finishTxn()
+	txn.db.mu.Unlock()
+	_ = txn.snap.Release()
+}
+
+// iterator over the snapshot of txn and its pending writes, the key range it goes through is read by txn
+type TxnIterator struct {
+	txn               *Txn
+	dbIter            *Iterator
+	pendingKeys       [][]byte // sorted keys of pending writes
+	pendingIdx        int
+	reverse           bool
+	keysOnly          bool
+	fromPending       bool // if current key comes from pending writes
+	lowerBound        []byte
+	upperBound        []byte
+	scanFrom          []byte    // where the current scan starts, nil means unbounded
+	scanFromInclusive bool      // if scanFrom itself is scanned, reverse only
+	scan              *keyRange // range of the current scan in txn.readRanges, nil if nothing scanned yet
+}
+
+// create an iterator of the transaction, which should be closed before Commit
+func (txn *Txn) Iterator(opts IteratorOptions) *TxnIterator {
+	txn.mu.Lock()
+	pendingKeys := make([][]byte, 0, len(txn.pendingWrites))
+	for _, lr := range txn.pendingWrites {
+		if opts.contains(lr.Key) {
+			pendingKeys = append(pendingKeys, lr.Key)
+		}
+	}
+	txn.mu.Unlock()
+
+	sort.Slice(pendingKeys, func(i, j int) bool {
+		if opts.Reverse {
+			return bytes.Compare(pendingKeys[i], pendingKeys[j]) > 0
+		}
+		return bytes.Compare(pendingKeys[i], pendingKeys[j]) < 0
+	})
+
+	lowerBound, upperBound := opts.bounds()
+	it := &TxnIterator{
+		txn:         txn,
+		dbIter:      txn.snap.NewIterator(opts),
+		pendingKeys: pendingKeys,
+		reverse:     opts.Reverse,
+		keysOnly:    opts.KeysOnly,
+		lowerBound:  lowerBound,
+		upperBound:  upperBound,
+	}
+	it.beginScan(nil)
+	it.settle()
+	return it
+}
+
+// go back to the first data of iterator
+func (it *TxnIterator) Rewind() {
+	it.dbIter.Rewind()
+	it.pendingIdx = 0
+	it.beginScan(nil)
+	it.settle()
+}
+
+// find the first target key which is >= or <=(reverse) params-key
+func (it *TxnIterator) Seek(key []byte) {
+	it.dbIter.Seek(key)
+	it.beginScan(key)
+	it.pendingIdx = sort.Search(len(it.pendingKeys), func(i int) bool {
+		if it.reverse {
+			return bytes.Compare(it.pendingKeys[i], key) <= 0
+		}
+		return bytes.Compare(it.pendingKeys[i], key) >= 0
+	})
+	it.settle()
+}
+
+// jump to the next key
+func (it *TxnIterator) Next() {
+	if it.fromPending {
+		it.pendingIdx++
+	} else {
+		it.dbIter.Next()
+	}
+	it.settle()
+}
+
+// used to determine whether the traversal has been completed
+func (it *TxnIterator) Valid() bool {
+	return it.dbIter.Valid() || it.pendingIdx < len(it.pendingKeys)
+}
+
+// get key of current postion
+func (it *TxnIterator) Key() []byte {
+	if it.fromPending {
+		return it.pendingKeys[it.pendingIdx]
+	}
+	return it.dbIter.Key()
+}
+
+// get value of current positon
+func (it *TxnIterator) Value() ([]byte, error) {
+	if it.keysOnly {
+		return nil, ErrIteratorKeysOnly
+	}
+	if it.fromPending {
+		it.txn.mu.Lock()
+		defer it.txn.mu.Unlock()
+		return it.txn.pendingWrites[string(it.pendingKeys[it.pendingIdx])].Value, nil
+	}
+	return it.dbIter.Value()
+}
+
+// close iterator and release resources
+func (it *TxnIterator) Close() {
+	it.dbIter.Close()
+}
+
+// choose the current key from db iterator and pending keys, skip keys deleted by txn
+func (it *TxnIterator) settle() {
+	it.txn.mu.Lock()
+	defer it.txn.mu.Unlock()
+
+	for it.Valid() {
+		hasPending := it.pendingIdx < len(it.pendingKeys)
+		if !hasPending {
+			it.fromPending = false
+		} else if !it.dbIter.Valid() {
+			it.fromPending = true
+		} else {
+			cmp := bytes.Compare(it.pendingKeys[it.pendingIdx], it.dbIter.Key())
+			if cmp == 0 {
+				// pending write covers the value in db
+				it.dbIter.Next()
+				continue
+			}
+			it.fromPending = (cmp < 0) != it.reverse
+		}
+
+		if it.fromPending {
+			if it.txn.pendingWrites[string(it.pendingKeys[it.pendingIdx])].Type == data.LogRecordDeleted {
+				it.pendingIdx++
+				continue
+			}
+		}
+		it.extendScan(it.Key())
+		return
+	}
+	it.extendScan(nil)
+}
+
+// start a new scan from seek key, nil key means from the bound
+func (it *TxnIterator) beginScan(key []byte) {
+	it.scan = nil
+	if it.reverse {
+		it.scanFrom, it.scanFromInclusive = it.upperBound, false
+		if key != nil && (it.upperBound == nil || bytes.Compare(key, it.upperBound) < 0) {
+			it.scanFrom, it.scanFromInclusive = key, true
+		}
+		return
+	}
+	it.scanFrom, it.scanFromInclusive = it.lowerBound, true
+	if key != nil && (it.lowerBound == nil || bytes.Compare(key, it.lowerBound) > 0) {
+		it.scanFrom = key
+	}
+}
+
+// extend the current scan to key, nil key means to the bound as iterator is exhausted, under txn.mu
+func (it *TxnIterator) extendScan(key []byte) {
+	if it.scan == nil {
+		it.scan = &keyRange{}
+		it.txn.readRanges = append(it.txn.readRanges, it.scan)
+	}
+	if key != nil {
+		key = append([]byte(nil), key...)
+	}
+	if it.reverse {
+		it.scan.start, it.scan.end, it.scan.endInclusive = key, it.scanFrom, it.scanFromInclusive
+		if key == nil {
+			it.scan.start = it.lowerBound
+		}
+		return
+	}
+	it.scan.start, it.scan.end, it.scan.endInclusive = it.scanFrom, key, true
+	if key == nil {
+		it.scan.end, it.scan.endInclusive = it.upperBound, false
+	}
+}
@@ -0,0 +1,285 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/utils"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTxn_Commit(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put(utils.GetTestKey(1), []byte("v1"))
+	assert.Nil(t, err)
+
+	// case1: read own writes, invisible to others before commit
+	txn, err := db.Begin()
+	assert.Nil(t, err)
+	err = txn.Put(utils.GetTestKey(2), []byte("v2"))
+	assert.Nil(t, err)
+	err = txn.Delete(utils.GetTestKey(1))
+	assert.Nil(t, err)
+	val, err := txn.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = txn.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	_, err = db.Get(utils.GetTestKey(2))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// case2: commit
+	err = txn.Commit()
+	assert.Nil(t, err)
+	val, err = db.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = db.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+
+	// case3: finished
+	err = txn.Put(utils.GetTestKey(3), []byte("v3"))
+	assert.Equal(t, ErrTxnFinished, err)
+	err = txn.Commit()
+	assert.Equal(t, ErrTxnFinished, err)
+	txn.Discard()
+
+	// case4: discard
+	txn2, err := db.Begin()
+	assert.Nil(t, err)
+	err = txn2.Put(utils.GetTestKey(4), []byte("v4"))
+	assert.Nil(t, err)
+	txn2.Discard()
+	_, err = db.Get(utils.GetTestKey(4))
+	assert.Equal(t, ErrKeyNotFound, err)
+	assert.Equal(t, 0, db.activeTxns)
+
+	// case5: restart
+	err = db.Close()
+	assert.Nil(t, err)
+	db2, err := Open(opts)
+	assert.Nil(t, err)
+	val, err = db2.Get(utils.GetTestKey(2))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("v2"), val)
+	_, err = db2.Get(utils.GetTestKey(1))
+	assert.Equal(t, ErrKeyNotFound, err)
+	db2.Close()
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-2")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	err = db.Put([]byte("counter"), []byte("1"))
+	assert.Nil(t, err)
+
+	// case1: read key changed by a single write
+	txn1, err := db.Begin()
+	assert.Nil(t, err)
+	_, err = txn1.Get([]byte("counter"))
+	assert.Nil(t, err)
+	err = db.Put([]byte("counter"), []byte("2"))
+	assert.Nil(t, err)
+	err = txn1.Put([]byte("counter"), []byte("3"))
+	assert.Nil(t, err)
+	err = txn1.Commit()
+	assert.Equal(t, ErrTxnConflict, err)
+	val, _ := db.Get([]byte("counter"))
+	assert.Equal(t, []byte("2"), val)
+
+	// case2: read key changed by another transaction
+	txn2, err := db.Begin()
+	assert.Nil(t, err)
+	txn3, err := db.Begin()
+	assert.Nil(t, err)
+	_, err = txn2.Get([]byte("counter"))
+	assert.Nil(t, err)
+	_, err = txn3.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Nil(t, txn2.Put([]byte("counter"), []byte("4")))
+	assert.Nil(t, txn3.Put([]byte("counter"), []byte("5")))
+	assert.Nil(t, txn2.Commit())
+	assert.Equal(t, ErrTxnConflict, txn3.Commit())
+
+	// case3: read a missing key which is put by a write batch later
+	txn4, err := db.Begin()
+	assert.Nil(t, err)
+	_, err = txn4.Get([]byte("missing"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("missing"), []byte("here")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, txn4.Put([]byte("other"), []byte("x")))
+	assert.Equal(t, ErrTxnConflict, txn4.Commit())
+
+	// case4: blind writes do not conflict
+	txn5, err := db.Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn5.Put([]byte("counter"), []byte("6")))
+	assert.Nil(t, db.Put([]byte("counter"), []byte("7")))
+	assert.Nil(t, txn5.Commit())
+	val, _ = db.Get([]byte("counter"))
+	assert.Equal(t, []byte("6"), val)
+
+	// case5: reads see the db when txn began
+	txn6, err := db.Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("counter"), []byte("8")))
+	assert.Nil(t, db.Put([]byte("later"), []byte("x")))
+	val, err = txn6.Get([]byte("counter"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("6"), val)
+	_, err = txn6.Get([]byte("later"))
+	assert.Equal(t, ErrKeyNotFound, err)
+	txn6.Discard()
+
+	assert.Equal(t, 0, db.activeTxns)
+	assert.Equal(t, 0, len(db.keyCommitSeqNos))
+}
+
+func TestTxn_Iterator(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-3")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("aaa"), []byte("db")))
+	assert.Nil(t, db.Put([]byte("ccc"), []byte("db")))
+	assert.Nil(t, db.Put([]byte("eee"), []byte("db")))
+
+	txn, err := db.Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, txn.Put([]byte("bbb"), []byte("txn")))
+	assert.Nil(t, txn.Put([]byte("ccc"), []byte("txn")))
+	assert.Nil(t, txn.Delete([]byte("eee")))
+	assert.Nil(t, txn.Put([]byte("fff"), []byte("txn")))
+
+	// forward
+	var keys, vals []string
+	it := txn.Iterator(DefaultIteratorOptions)
+	for it.Rewind(); it.Valid(); it.Next() {
+		val, err := it.Value()
+		assert.Nil(t, err)
+		keys = append(keys, string(it.Key()))
+		vals = append(vals, string(val))
+	}
+	it.Close()
+	assert.Equal(t, []string{"aaa", "bbb", "ccc", "fff"}, keys)
+	assert.Equal(t, []string{"db", "txn", "txn", "txn"}, vals)
+
+	// reverse and seek
+	keys = nil
+	itOpts := DefaultIteratorOptions
+	itOpts.Reverse = true
+	it2 := txn.Iterator(itOpts)
+	for it2.Seek([]byte("ddd")); it2.Valid(); it2.Next() {
+		keys = append(keys, string(it2.Key()))
+	}
+	it2.Close()
+	assert.Equal(t, []string{"ccc", "bbb", "aaa"}, keys)
+
+	// bounds apply to pending writes as well
+	keys = nil
+	it3 := txn.Iterator(IteratorOptions{LowerBound: []byte("b"), UpperBound: []byte("f")})
+	for it3.Rewind(); it3.Valid(); it3.Next() {
+		keys = append(keys, string(it3.Key()))
+	}
+	it3.Close()
+	assert.Equal(t, []string{"bbb", "ccc"}, keys)
+
+	// keys iterated are read by txn
+	assert.Nil(t, db.Put([]byte("aaa"), []byte("changed")))
+	assert.Equal(t, ErrTxnConflict, txn.Commit())
+}
+
+func TestTxn_IteratorRange(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-4")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	assert.Nil(t, db.Put([]byte("aaa"), []byte("db")))
+	assert.Nil(t, db.Put([]byte("ccc"), []byte("db")))
+	assert.Nil(t, db.Put([]byte("eee"), []byte("db")))
+
+	// case1: iterator reads the db when txn began
+	txn, err := db.Begin()
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("bbb"), []byte("db")))
+	var keys []string
+	it := txn.Iterator(DefaultIteratorOptions)
+	for it.Rewind(); it.Valid(); it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	it.Close()
+	assert.Equal(t, []string{"aaa", "ccc", "eee"}, keys)
+	assert.Nil(t, txn.Put([]byte("x"), []byte("txn")))
+	// key put into the scanned range conflicts
+	assert.Equal(t, ErrTxnConflict, txn.Commit())
+
+	// case2: key put out of the scanned range does not conflict
+	txn2, err := db.Begin()
+	assert.Nil(t, err)
+	it2 := txn2.Iterator(DefaultIteratorOptions)
+	it2.Seek([]byte("bbb"))
+	assert.Equal(t, []byte("bbb"), it2.Key())
+	it2.Next()
+	assert.Equal(t, []byte("ccc"), it2.Key())
+	it2.Close()
+	assert.Nil(t, db.Put([]byte("aab"), []byte("db")))
+	assert.Nil(t, db.Put([]byte("ddd"), []byte("db")))
+	assert.Nil(t, txn2.Put([]byte("x"), []byte("txn")))
+	assert.Nil(t, txn2.Commit())
+
+	// case3: reverse scan to the lower bound, key deleted in the range conflicts
+	txn3, err := db.Begin()
+	assert.Nil(t, err)
+	itOpts := DefaultIteratorOptions
+	itOpts.Reverse = true
+	it3 := txn3.Iterator(itOpts)
+	for it3.Seek([]byte("bbc")); it3.Valid(); it3.Next() {
+	}
+	it3.Close()
+	assert.Nil(t, db.Put([]byte("ddd"), []byte("changed")))
+	assert.Nil(t, txn3.Put([]byte("y"), []byte("txn")))
+	assert.Nil(t, db.Delete([]byte("aab")))
+	assert.Equal(t, ErrTxnConflict, txn3.Commit())
+}
+
+func TestTxn_Begin(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-txn-5")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	txn, err := db.Begin()
+	assert.Nil(t, err)
+	txn.Discard()
+	assert.Nil(t, db.Close())
+
+	// begin on closed db
+	_, err = db.Begin()
+	assert.Equal(t, ErrDBClosed, err)
+}
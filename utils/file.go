@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"io/fs"
 	"os"
 	"path/filepath"
@@ -61,14 +62,34 @@ func AvailableDiskSize() (uint64, error) {
 }
 
 func CopyDir(src, dest string, exclude []string) error {
+	return CopyDirContext(context.Background(), src, dest, exclude)
+}
+
+// copy dir, and check ctx before each file
+// if copying fails or ctx is done, the copied files and the created dirs are removed
+func CopyDirContext(ctx context.Context, src, dest string, exclude []string) (err error) {
+	var created []string
+	defer func() {
+		if err != nil {
+			for i := len(created) - 1; i >= 0; i-- {
+				_ = os.Remove(created[i])
+			}
+		}
+	}()
+
 	// 目标目标不存在则创建
 	if _, err := os.Stat(dest); os.IsNotExist(err) {
 		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
 			return err
 		}
+		created = append(created, dest)
 	}
 
 	return filepath.Walk(src, func(path string, info fs.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		fileName := strings.Replace(path, src, "", 1)
 		if fileName == "" {
 			return nil
@@ -85,13 +106,18 @@ func CopyDir(src, dest string, exclude []string) error {
 		}
 
 		if info.IsDir() {
-			return os.MkdirAll(filepath.Join(dest, fileName), info.Mode())
+			if err := os.MkdirAll(filepath.Join(dest, fileName), info.Mode()); err != nil {
+				return err
+			}
+			created = append(created, filepath.Join(dest, fileName))
+			return nil
 		}
 
 		data, err := os.ReadFile(filepath.Join(src, fileName))
 		if err != nil {
 			return err
 		}
+		created = append(created, filepath.Join(dest, fileName))
 		return os.WriteFile(filepath.Join(dest, fileName), data, info.Mode())
 	})
 }
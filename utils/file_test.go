@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"context"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -20,3 +22,29 @@ func TestAvailableDiskSize(t *testing.T) {
 	assert.Nil(t, err)
 	assert.True(t, size > 0)
 }
+
+func TestCopyDirContext(t *testing.T) {
+	src, _ := os.MkdirTemp("", "bitcask-go-copy-src")
+	defer os.RemoveAll(src)
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "a"), []byte("a"), 0644))
+	assert.Nil(t, os.WriteFile(filepath.Join(src, "b"), []byte("b"), 0644))
+	dest := filepath.Join(os.TempDir(), "bitcask-go-copy-dest")
+	defer os.RemoveAll(dest)
+
+	// case1: canceled, nothing is left
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := CopyDirContext(ctx, src, dest, nil)
+	assert.Equal(t, context.Canceled, err)
+	_, err = os.Stat(dest)
+	assert.True(t, os.IsNotExist(err))
+
+	// case2: copy with exclude
+	err = CopyDirContext(context.Background(), src, dest, []string{"b"})
+	assert.Nil(t, err)
+	data, err := os.ReadFile(filepath.Join(dest, "a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), data)
+	_, err = os.Stat(filepath.Join(dest, "b"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,223 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// kinds of VerifyIssue
+const (
+	IssueCorruptedRecord  = "corrupted_record"   // crc or header of record is invalid, or its value can not be decompressed
+	IssueTornRecord       = "torn_record"        // incomplete record at the end of file, no valid record follows it
+	IssueIncompleteTxn    = "incomplete_txn"     // records of a batch without LogRecordTxnFinish, they are ignored by Open
+	IssueHintPastEnd      = "hint_past_end"      // hint entry points past the end of data file, or to a missing one
+	IssueLeftoverMergeDir = "leftover_merge_dir" // merge dir is left by a crash, Open applies it if finished, or removes it
+	IssueInvalidFile      = "invalid_file"       // the file can not be opened, e.g. its header is invalid or of another db
+	IssueNotVerified      = "not_verified"       // the file is encrypted, and its records can not be read without the key
+)
+
+// problem found by VerifyDir
+type VerifyIssue struct {
+	Kind   string `json:"kind"`
+	File   string `json:"file"`   // path of the file or dir
+	Offset int64  `json:"offset"` // offset of the record, not counting the file header, -1 if the issue is of the whole file
+	Detail string `json:"detail"`
+}
+
+// file checked by VerifyDir
+type VerifiedFile struct {
+	File    string `json:"file"`
+	Size    int64  `json:"size"` // size of records, not counting the file header
+	Records int    `json:"records"`
+}
+
+// result of VerifyDir, it can be encoded to json
+type VerifyReport struct {
+	Dir    string         `json:"dir"`
+	Files  []VerifiedFile `json:"files"`
+	Issues []VerifyIssue  `json:"issues"`
+}
+
+// if no issue is found
+func (r *VerifyReport) OK() bool {
+	return len(r.Issues) == 0
+}
+
+func (r *VerifyReport) addIssue(kind, file string, offset int64, detail string) {
+	r.Issues = append(r.Issues, VerifyIssue{Kind: kind, File: file, Offset: offset, Detail: detail})
+}
+
+// first record of a batch and the number of its records, for finding incomplete batches
+type verifiedTxn struct {
+	file    string
+	offset  int64
+	records int
+}
+
+// check the files of db dir offline, without opening the db, the db should not be written meanwhile.
+// crcs of records in data, blob, hint, merge-finished and seq-no files are checked,
+// and incomplete batches, hint entries past the end of data files and leftover merge dir are reported.
+// files are opened read-only, and encrypted files are verified only if keys provides their keys, keys may be nil.
+// the error is returned only if the dir can not be read
+func VerifyDir(dirPath string, keys KeyProvider) (*VerifyReport, error) {
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{Dir: dirPath, Files: []VerifiedFile{}, Issues: []VerifyIssue{}}
+
+	// files of other dbs are reported
+	opts := &data.FileOptions{Keys: keys, ReadOnly: true}
+	idFileName := filepath.Join(dirPath, data.DBIdFileName)
+	if content, err := os.ReadFile(idFileName); err == nil {
+		if opts.DBId, err = data.ParseUUID(strings.TrimSpace(string(content))); err != nil {
+			report.addIssue(IssueInvalidFile, idFileName, -1, err.Error())
+		}
+	}
+
+	fileIds, err := fileIdsOf(entries, data.DataFileNameSuffix)
+	if err != nil {
+		report.addIssue(IssueInvalidFile, dirPath, -1, fmt.Sprintf("invalid data file name, %v", err))
+	}
+	blobIds, err := fileIdsOf(entries, data.BlobFileNameSuffix)
+	if err != nil {
+		report.addIssue(IssueInvalidFile, dirPath, -1, fmt.Sprintf("invalid blob file name, %v", err))
+	}
+
+	// data files, batches may span files, so they are checked in order
+	dataFileSizes := make(map[uint32]int64)
+	txns := make(map[uint64]*verifiedTxn)
+	for _, fid := range fileIds {
+		fileName := data.GetDataFileName(dirPath, uint32(fid))
+		size, ok := report.verifyFile(fileName, func() (*data.DataFile, error) {
+			return data.OpenDataFile(dirPath, uint32(fid), fio.ReadOnlyFIO, opts)
+		}, func(lr *data.LogRecord, offset int64) {
+			_, seqNo := parseLogRecordKey(lr.Key)
+			if seqNo == noTransactionSeqNo {
+				return
+			}
+			if lr.Type == data.LogRecordTxnFinish {
+				delete(txns, seqNo)
+				return
+			}
+			if txn, ok := txns[seqNo]; ok {
+				txn.records++
+				return
+			}
+			txns[seqNo] = &verifiedTxn{file: fileName, offset: offset, records: 1}
+		})
+		if ok {
+			dataFileSizes[uint32(fid)] = size
+		}
+	}
+	seqNos := make([]uint64, 0, len(txns))
+	for seqNo := range txns {
+		seqNos = append(seqNos, seqNo)
+	}
+	sort.Slice(seqNos, func(i, j int) bool { return seqNos[i] < seqNos[j] })
+	for _, seqNo := range seqNos {
+		txn := txns[seqNo]
+		report.addIssue(IssueIncompleteTxn, txn.file, txn.offset,
+			fmt.Sprintf("%d records of batch %d without finish record", txn.records, seqNo))
+	}
+
+	for _, fid := range blobIds {
+		report.verifyFile(data.GetBlobFileName(dirPath, uint32(fid)), func() (*data.DataFile, error) {
+			return data.OpenBlobFile(dirPath, uint32(fid), opts)
+		}, nil)
+	}
+
+	// hint entries point to merged data files
+	hintFileName := filepath.Join(dirPath, data.HintFileName)
+	if _, err := os.Stat(hintFileName); err == nil {
+		report.verifyFile(hintFileName, func() (*data.DataFile, error) {
+			return data.OpenHintFile(dirPath, opts)
+		}, func(lr *data.LogRecord, offset int64) {
+			if len(lr.Value) == 0 {
+				return
+			}
+			pos := data.DeCodeLogRecordPos(lr.Value)
+			size, ok := dataFileSizes[pos.Fid]
+			if !ok {
+				report.addIssue(IssueHintPastEnd, hintFileName, offset, fmt.Sprintf("data file %d is missing or invalid", pos.Fid))
+			} else if pos.Offset+int64(pos.Size) > size {
+				report.addIssue(IssueHintPastEnd, hintFileName, offset,
+					fmt.Sprintf("record at offset %d of size %d is past the end of data file %d of size %d", pos.Offset, pos.Size, pos.Fid, size))
+			}
+		})
+	}
+
+	mergeFinishedFileName := filepath.Join(dirPath, data.MergeFinishedFileName)
+	if _, err := os.Stat(mergeFinishedFileName); err == nil {
+		report.verifyFile(mergeFinishedFileName, func() (*data.DataFile, error) {
+			return data.OpenMergeFinishedFile(dirPath, opts)
+		}, nil)
+	}
+
+	seqNoFileName := filepath.Join(dirPath, data.SeqNoFileName)
+	if _, err := os.Stat(seqNoFileName); err == nil {
+		report.verifyFile(seqNoFileName, func() (*data.DataFile, error) {
+			return data.OpenSeqNoFile(dirPath, opts)
+		}, nil)
+	}
+
+	// merge dir is removed by Compact or the next Open
+	mergePath := filepath.Join(filepath.Dir(dirPath), filepath.Base(dirPath)+MergeDirSuffix)
+	if _, err := os.Stat(mergePath); err == nil {
+		detail := "unfinished merge, removed by the next open"
+		if _, err := os.Stat(filepath.Join(mergePath, data.MergeFinishedFileName)); err == nil {
+			detail = "finished merge, applied by the next open"
+		}
+		report.addIssue(IssueLeftoverMergeDir, mergePath, -1, detail)
+	}
+
+	return report, nil
+}
+
+// read all records of the file opened by open, visit is called with the valid ones,
+// return the size of records and if the file is read
+func (r *VerifyReport) verifyFile(fileName string, open func() (*data.DataFile, error), visit func(lr *data.LogRecord, offset int64)) (int64, bool) {
+	dataFile, err := open()
+	if err != nil {
+		if errors.Is(err, data.ErrKeyRequired) {
+			r.addIssue(IssueNotVerified, fileName, -1, err.Error())
+		} else {
+			r.addIssue(IssueInvalidFile, fileName, -1, err.Error())
+		}
+		return 0, false
+	}
+	defer dataFile.Close()
+	size, err := dataFile.IOManager.Size()
+	if err != nil {
+		r.addIssue(IssueInvalidFile, fileName, -1, err.Error())
+		return 0, false
+	}
+
+	verified := VerifiedFile{File: fileName, Size: size}
+	corruptions, err := scanDataFile(dataFile, size, func(lr *data.LogRecord, offset, _ int64) {
+		if visit != nil {
+			visit(lr, offset)
+		}
+		verified.Records++
+	})
+	if err != nil {
+		r.addIssue(IssueInvalidFile, fileName, -1, err.Error())
+		return 0, false
+	}
+	for _, c := range corruptions {
+		if c.Err == io.ErrUnexpectedEOF {
+			r.addIssue(IssueTornRecord, fileName, c.Offset, fmt.Sprintf("%d bytes of incomplete record at the end of file", c.Size))
+		} else {
+			r.addIssue(IssueCorruptedRecord, fileName, c.Offset, fmt.Sprintf("%v, %d bytes skipped", c.Err, c.Size))
+		}
+	}
+	r.Files = append(r.Files, verified)
+	return size, true
+}
@@ -0,0 +1,218 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/data"
+	"bitcask-go/fio"
+	"bitcask-go/utils"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// issues of the kind in report
+func issuesOf(report *VerifyReport, kind string) []VerifyIssue {
+	var issues []VerifyIssue
+	for _, issue := range report.Issues {
+		if issue.Kind == kind {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// contents of the files in dir
+func readDirFiles(t *testing.T, dir string) map[string][]byte {
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	files := make(map[string][]byte)
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		assert.Nil(t, err)
+		files[entry.Name()] = content
+	}
+	return files
+}
+
+func TestVerifyDir(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	opts.DataFileMergeRatio = 0
+	opts.ValueThreshold = 256
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Put([]byte("large"), utils.RandomValue(1024)))
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("batch"), []byte("bitcask-go")))
+	assert.Nil(t, wb.Commit())
+	assert.Nil(t, db.Compact())
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Put([]byte("after-merge"), []byte("bitcask-go")))
+	activeFid := db.activeFile.FileId
+	assert.Nil(t, db.Close())
+
+	// case1: healthy db
+	report, err := VerifyDir(dir, nil)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, []VerifyIssue{}, report.Issues)
+	records := 0
+	kinds := make(map[string]bool)
+	for _, f := range report.Files {
+		records += f.Records
+		kinds[filepath.Ext(f.File)] = true
+		kinds[filepath.Base(f.File)] = true
+	}
+	assert.True(t, records > 200)
+	assert.True(t, kinds[data.DataFileNameSuffix])
+	assert.True(t, kinds[data.BlobFileNameSuffix])
+	assert.True(t, kinds[data.HintFileName])
+	assert.True(t, kinds[data.MergeFinishedFileName])
+
+	// files are opened read-only, and empty file is not given a header
+	emptyFileName := data.GetDataFileName(dir, activeFid+1)
+	assert.Nil(t, os.WriteFile(emptyFileName, nil, 0644))
+	files := readDirFiles(t, dir)
+	report, err = VerifyDir(dir, nil)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	assert.Equal(t, files, readDirFiles(t, dir))
+	assert.Nil(t, os.Remove(emptyFileName))
+
+	// case2: corrupted record, torn record and incomplete batch
+	fileName := data.GetDataFileName(dir, 0)
+	content, err := os.ReadFile(fileName)
+	assert.Nil(t, err)
+	content[len(content)/2] ^= 0xff
+	assert.Nil(t, os.WriteFile(fileName, content, 0644))
+	dataFile, err := data.OpenDataFile(dir, activeFid, fio.StandardFIO, nil)
+	assert.Nil(t, err)
+	encRecord, _ := data.EncodeLogRecord(&data.LogRecord{Key: logRecordKeyWithSeq([]byte("unfinished"), 100), Value: []byte("bitcask-go")})
+	assert.Nil(t, dataFile.Write(encRecord))
+	assert.Nil(t, dataFile.Write(encRecord[:10]))
+	assert.Nil(t, dataFile.Close())
+
+	// case3: hint entry past the end and leftover merge dir
+	hintFile, err := data.OpenHintFile(dir, nil)
+	assert.Nil(t, err)
+	pos := &data.LogRecordPos{Fid: 0, Offset: int64(len(content)), Size: 100}
+	assert.Nil(t, hintFile.WriteHintRecord(data.LogRecordNormal, []byte("past-end"), pos, &data.LogRecord{}))
+	assert.Nil(t, hintFile.Close())
+	mergePath := dir + MergeDirSuffix
+	assert.Nil(t, os.MkdirAll(mergePath, os.ModePerm))
+	defer os.RemoveAll(mergePath)
+
+	report, err = VerifyDir(dir, nil)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.Equal(t, 1, len(issuesOf(report, IssueCorruptedRecord)))
+	assert.Equal(t, fileName, issuesOf(report, IssueCorruptedRecord)[0].File)
+	assert.Equal(t, 1, len(issuesOf(report, IssueTornRecord)))
+	assert.Equal(t, 1, len(issuesOf(report, IssueIncompleteTxn)))
+	assert.Equal(t, 1, len(issuesOf(report, IssueHintPastEnd)))
+	assert.Equal(t, 1, len(issuesOf(report, IssueLeftoverMergeDir)))
+	assert.Equal(t, mergePath, issuesOf(report, IssueLeftoverMergeDir)[0].File)
+	assert.Equal(t, 5, len(report.Issues))
+
+	// report is json
+	b, err := json.Marshal(report)
+	assert.Nil(t, err)
+	var decoded VerifyReport
+	assert.Nil(t, json.Unmarshal(b, &decoded))
+	assert.Equal(t, *report, decoded)
+
+	// case4: dir does not exist
+	_, err = VerifyDir(filepath.Join(dir, "not-exist"), nil)
+	assert.NotNil(t, err)
+}
+
+func TestVerifyDir_Encrypted(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-encrypted")
+	opts.DirPath = dir
+	opts.DataFileSize = 4 * 1024
+	keys := &mapKeyProvider{current: "k1", keys: map[string][]byte{
+		"k1": bytes.Repeat([]byte("a"), 32),
+		"k2": bytes.Repeat([]byte("b"), 32),
+	}}
+	opts.KeyProvider = keys
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	keys.current = "k2"
+	assert.Nil(t, db.Close())
+	db, err = Open(opts)
+	assert.Nil(t, err)
+	for i := 100; i < 200; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(64)))
+	}
+	assert.Nil(t, db.Close())
+
+	// case1: encrypted files are not verified without keys
+	report, err := VerifyDir(dir, nil)
+	assert.Nil(t, err)
+	assert.False(t, report.OK())
+	assert.True(t, len(issuesOf(report, IssueNotVerified)) > 0)
+
+	// case2: keys of the rotated provider
+	keyList, err := ParseKeyList("k2=" + hex.EncodeToString(keys.keys["k2"]) + ",k1=" + hex.EncodeToString(keys.keys["k1"]))
+	assert.Nil(t, err)
+	report, err = VerifyDir(dir, keyList)
+	assert.Nil(t, err)
+	assert.True(t, report.OK())
+	records := 0
+	for _, f := range report.Files {
+		records += f.Records
+	}
+	assert.True(t, records >= 200)
+}
+
+func TestVerifyDir_CorruptedHeader(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-verify-header")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.GetTestKey(i)))
+	}
+	pos := db.index.Get(utils.GetTestKey(50))
+	assert.Nil(t, db.Close())
+	corruptKeySize(t, dir, pos.Fid, pos.Offset)
+
+	// the header states a size past the end of file, and the records after it are still checked
+	report, err := VerifyDir(dir, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(report.Issues))
+	issue := report.Issues[0]
+	assert.Equal(t, IssueCorruptedRecord, issue.Kind)
+	assert.Equal(t, data.GetDataFileName(dir, pos.Fid), issue.File)
+	assert.Equal(t, pos.Offset, issue.Offset)
+	for _, f := range report.Files {
+		if f.File == data.GetDataFileName(dir, pos.Fid) {
+			assert.Equal(t, 99, f.Records)
+		}
+	}
+}
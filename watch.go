@@ -0,0 +1,130 @@
+package bitcaskminidb
+
+import (
+	"bytes"
+	"context"
+)
+
+type EventType byte
+
+const (
+	EventPut         EventType = iota
+	EventDelete                // Value is nil
+	EventOverflow              // the watcher falls behind, the following events are dropped and the channel is closed
+	EventMerge                 // only in ChangesSince, Value is the merge operand
+	EventDeleteRange           // only in ChangesSince, Key is the start and Value is the end of range
+)
+
+// change event of a committed write, events of one batch share the same SeqNo
+// Key and Value are shared by watchers, do not modify them
+type Event struct {
+	Type  EventType
+	Key   []byte
+	Value []byte
+	SeqNo uint64
+}
+
+// number of events buffered for a watcher
+const watchChannelSize = 1024
+
+type watcher struct {
+	prefix []byte
+	ch     chan *Event
+	done   chan struct{}
+}
+
+// watch changes of keys with prefix (nil means all keys), until ctx is done or db is closed
+// events are sent without blocking writers, a watcher that falls behind receives EventOverflow,
+// then its channel is closed
+func (db *DB) Watch(ctx context.Context, prefix []byte) <-chan *Event {
+	w := &watcher{
+		prefix: append([]byte(nil), prefix...),
+		ch:     make(chan *Event, watchChannelSize),
+		done:   make(chan struct{}),
+	}
+
+	// watchers are closed by db.Close after closed is set
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	if db.closed {
+		close(w.ch)
+		return w.ch
+	}
+
+	db.watchLock.Lock()
+	db.watchers[w] = struct{}{}
+	db.watchLock.Unlock()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			db.watchLock.Lock()
+			db.removeWatcher(w)
+			db.watchLock.Unlock()
+		case <-w.done:
+		}
+	}()
+	return w.ch
+}
+
+// whether any watcher is interested in key
+func (db *DB) isWatched(key []byte) bool {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+	for w := range db.watchers {
+		if bytes.HasPrefix(key, w.prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// send event to watchers after the write is committed, under db.mu so that events are in commit order
+func (db *DB) notifyWatchers(typ EventType, key []byte, value []byte, seqNo uint64) {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+
+	var event *Event
+	for w := range db.watchers {
+		if !bytes.HasPrefix(key, w.prefix) {
+			continue
+		}
+		if event == nil {
+			// key and value belong to the caller, copy them
+			event = &Event{
+				Type:  typ,
+				Key:   append([]byte(nil), key...),
+				SeqNo: seqNo,
+			}
+			if value != nil {
+				event.Value = append([]byte(nil), value...)
+			}
+		}
+		// the last slot is left for overflow event
+		if len(w.ch) == cap(w.ch)-1 {
+			w.ch <- &Event{Type: EventOverflow, SeqNo: seqNo}
+			db.removeWatcher(w)
+			continue
+		}
+		w.ch <- event
+	}
+}
+
+// close all watchers when db is closed
+func (db *DB) closeWatchers() {
+	db.watchLock.Lock()
+	defer db.watchLock.Unlock()
+	for w := range db.watchers {
+		db.removeWatcher(w)
+	}
+}
+
+// under db.watchLock
+func (db *DB) removeWatcher(w *watcher) {
+	if _, ok := db.watchers[w]; !ok {
+		return
+	}
+	delete(db.watchers, w)
+	close(w.ch)
+	close(w.done)
+}
@@ -0,0 +1,103 @@
+package bitcaskminidb
+
+import (
+	"bitcask-go/utils"
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDB_Watch(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-1")
+	opts.DirPath = dir
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := db.Watch(ctx, []byte("user:"))
+
+	assert.Nil(t, db.Put([]byte("user:1"), []byte("a")))
+	assert.Nil(t, db.Put([]byte("other"), []byte("b")))
+	assert.Nil(t, db.Delete([]byte("user:1")))
+	assert.Nil(t, db.Delete([]byte("user:not-exist")))
+
+	wb := db.NewWriteBatch(DefaultWriteBatchOptions)
+	assert.Nil(t, wb.Put([]byte("user:2"), []byte("c")))
+	assert.Nil(t, wb.Put([]byte("user:3"), []byte("d")))
+	assert.Nil(t, wb.Commit())
+
+	assert.Nil(t, db.DeletePrefix([]byte("user:")))
+
+	// case1: put and delete
+	e := <-ch
+	assert.Equal(t, EventPut, e.Type)
+	assert.Equal(t, []byte("user:1"), e.Key)
+	assert.Equal(t, []byte("a"), e.Value)
+	e2 := <-ch
+	assert.Equal(t, EventDelete, e2.Type)
+	assert.Equal(t, []byte("user:1"), e2.Key)
+	assert.Nil(t, e2.Value)
+	assert.True(t, e2.SeqNo > e.SeqNo)
+
+	// case2: events of a batch share the seqNo
+	e3, e4 := <-ch, <-ch
+	assert.Equal(t, EventPut, e3.Type)
+	assert.Equal(t, e3.SeqNo, e4.SeqNo)
+	assert.True(t, e3.SeqNo > e2.SeqNo)
+	assert.ElementsMatch(t, [][]byte{[]byte("user:2"), []byte("user:3")}, [][]byte{e3.Key, e4.Key})
+
+	// case3: range deletion
+	e5, e6 := <-ch, <-ch
+	assert.Equal(t, EventDelete, e5.Type)
+	assert.Equal(t, EventDelete, e6.Type)
+	assert.Equal(t, []byte("user:2"), e5.Key)
+	assert.Equal(t, []byte("user:3"), e6.Key)
+
+	// case4: cancel ctx closes the channel
+	cancel()
+	_, ok := <-ch
+	assert.False(t, ok)
+	assert.Nil(t, db.Put([]byte("user:4"), []byte("e")))
+}
+
+func TestDB_Watch_Overflow(t *testing.T) {
+	opts := DefaultOptions
+	dir, _ := os.MkdirTemp("", "bitcask-go-watch-2")
+	opts.DirPath = dir
+	opts.MergeOperator = MergeOperatorAppend
+	db, err := Open(opts)
+	defer destroyDB(db)
+	assert.Nil(t, err)
+	assert.NotNil(t, db)
+
+	// case1: merge operand is sent with the merged value
+	ch := db.Watch(context.Background(), nil)
+	assert.Nil(t, db.Put([]byte("list"), []byte("a")))
+	assert.Nil(t, db.Merge([]byte("list"), []byte("b")))
+	<-ch
+	e := <-ch
+	assert.Equal(t, EventPut, e.Type)
+	assert.Equal(t, []byte("ab"), e.Value)
+
+	// case2: watcher falls behind, writers are not blocked
+	for i := 0; i < watchChannelSize*2; i++ {
+		assert.Nil(t, db.Put(utils.GetTestKey(i), utils.RandomValue(10)))
+	}
+	var events []*Event
+	for e := range ch {
+		events = append(events, e)
+	}
+	assert.Equal(t, watchChannelSize, len(events))
+	assert.Equal(t, EventOverflow, events[len(events)-1].Type)
+
+	// case3: close db closes the channel
+	ch2 := db.Watch(context.Background(), nil)
+	assert.Nil(t, db.Close())
+	_, ok := <-ch2
+	assert.False(t, ok)
+}